@@ -0,0 +1,206 @@
+package auth
+
+import (
+	"fmt"
+	"github.com/go-errors/errors"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Supported host key verification policies
+const (
+	// HostKeyPolicyStrict: Only connect to hosts whose key is already known
+	HostKeyPolicyStrict = "strict"
+	// HostKeyPolicyTOFU: Trust and record the key of hosts seen for the first time, refuse keys that have changed
+	HostKeyPolicyTOFU = "tofu"
+	// HostKeyPolicyInsecure: Don't verify host keys
+	HostKeyPolicyInsecure = "insecure"
+)
+
+// NewHostKeyVerifier: Constructs a HostKeyVerifier that applies policy using the known_hosts file at knownHostsPath
+func NewHostKeyVerifier(policy string, knownHostsPath string) (*HostKeyVerifier, error) {
+	switch policy {
+	case HostKeyPolicyStrict, HostKeyPolicyInsecure:
+	case HostKeyPolicyTOFU:
+		if knownHostsPath == "" {
+			return nil, errors.New("A known_hosts file is required to record trusted host keys")
+		}
+
+		// Create the file so there is somewhere to record new keys
+		if err := os.MkdirAll(filepath.Dir(knownHostsPath), os.ModePerm); err != nil {
+			return nil, errors.Errorf("Unable to create directory for '%s': %s", knownHostsPath, err)
+		}
+		f, err := os.OpenFile(knownHostsPath, os.O_CREATE|os.O_RDONLY, 0600)
+		if err != nil {
+			return nil, errors.Errorf("Unable to create known_hosts file '%s': %s", knownHostsPath, err)
+		}
+		f.Close()
+	default:
+		return nil, errors.Errorf("Unsupported host key policy '%s'", policy)
+	}
+
+	if knownHostsPath != "" {
+		// Fail early on a missing or malformed file
+		if _, err := knownhosts.New(knownHostsPath); err != nil {
+			return nil, errors.Errorf("Unable to load known_hosts file: %s", err)
+		}
+	}
+
+	return &HostKeyVerifier{
+		policy:         policy,
+		knownHostsPath: knownHostsPath,
+		mutex:          &sync.Mutex{},
+	}, nil
+}
+
+// HostKeyVerifier verifies the host keys presented by devices
+type HostKeyVerifier struct {
+	policy         string
+	knownHostsPath string
+	mutex          *sync.Mutex
+}
+
+// Callback returns a HostKeyCallback for a device. If fingerprint is non-empty the device's key must match it,
+// regardless of the policy. Fingerprints may be given in either the SHA256 or legacy MD5 format.
+func (v *HostKeyVerifier) Callback(fingerprint string) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if fingerprint != "" {
+			return checkFingerprint(fingerprint, key)
+		}
+
+		if v.policy == HostKeyPolicyInsecure {
+			return nil
+		}
+
+		return v.checkKnownHosts(hostname, remote, key)
+	}
+}
+
+// HostKeyAlgorithms returns the key types recorded in the known_hosts file for address, in a form suitable for
+// ssh.ClientConfig.HostKeyAlgorithms. Restricting negotiation to them stops a device that also offers a key of another
+// type from presenting that one instead, which would be refused as a changed key. nil is returned if the device's key
+// is pinned by fingerprint, isn't checked against the known_hosts file, or isn't in it, leaving the default algorithms.
+func (v *HostKeyVerifier) HostKeyAlgorithms(fingerprint string, address string) []string {
+	if fingerprint != "" || v.policy == HostKeyPolicyInsecure || v.knownHostsPath == "" {
+		return nil
+	}
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	callback, err := knownhosts.New(v.knownHostsPath)
+	if err != nil {
+		return nil
+	}
+
+	// No key matches one of an unknown type, so the error lists every key known for the host
+	keyErr, ok := callback(address, &net.TCPAddr{}, probeKey{}).(*knownhosts.KeyError)
+	if !ok {
+		return nil
+	}
+
+	var algorithms []string
+	seen := make(map[string]bool)
+	for _, known := range keyErr.Want {
+		if algorithm := known.Key.Type(); !seen[algorithm] {
+			seen[algorithm] = true
+			algorithms = append(algorithms, algorithm)
+		}
+	}
+	sort.Strings(algorithms)
+
+	return algorithms
+}
+
+// probeKey is a public key of a type no host has, used to look up the keys known for a host
+type probeKey struct{}
+
+func (probeKey) Type() string {
+	return "ndm-probe"
+}
+
+func (probeKey) Marshal() []byte {
+	return []byte("ndm-probe")
+}
+
+func (probeKey) Verify(data []byte, sig *ssh.Signature) error {
+	return errors.New("probe keys can't verify signatures")
+}
+
+func (v *HostKeyVerifier) checkKnownHosts(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	if v.knownHostsPath == "" {
+		return errors.Errorf("Unable to verify the host key of %s (%s): no known_hosts file or host_key fingerprint was configured",
+			hostname, ssh.FingerprintSHA256(key))
+	}
+
+	// Serialize access so keys recorded by one connection are seen by the next
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	callback, err := knownhosts.New(v.knownHostsPath)
+	if err != nil {
+		return errors.Errorf("Unable to load known_hosts file: %s", err)
+	}
+
+	err = callback(hostname, remote, key)
+	if err == nil {
+		return nil
+	}
+
+	keyErr, ok := err.(*knownhosts.KeyError)
+	if !ok {
+		return err
+	}
+
+	if len(keyErr.Want) > 0 {
+		want := make([]string, 0, len(keyErr.Want))
+		for _, k := range keyErr.Want {
+			want = append(want, fmt.Sprintf("%s (%s:%d)", ssh.FingerprintSHA256(k.Key), k.Filename, k.Line))
+		}
+		return errors.Errorf("HOST KEY CHANGED for %s: presented %s, expected %s. Refusing to connect",
+			hostname, ssh.FingerprintSHA256(key), strings.Join(want, ", "))
+	}
+
+	if v.policy != HostKeyPolicyTOFU {
+		return errors.Errorf("Unknown host key for %s (%s), not found in '%s'",
+			hostname, ssh.FingerprintSHA256(key), v.knownHostsPath)
+	}
+
+	// Trust on first use, record the key
+	f, err := os.OpenFile(v.knownHostsPath, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Errorf("Unable to record host key: %s", err)
+	}
+	defer f.Close()
+
+	if _, err := fmt.Fprintln(f, knownhosts.Line([]string{hostname}, key)); err != nil {
+		return errors.Errorf("Unable to record host key: %s", err)
+	}
+
+	log.Printf("Trusting new host key for %s: %s\n", hostname, ssh.FingerprintSHA256(key))
+
+	return nil
+}
+
+// checkFingerprint checks that key matches the pinned fingerprint
+func checkFingerprint(fingerprint string, key ssh.PublicKey) error {
+	if strings.HasPrefix(fingerprint, "SHA256:") {
+		if actual := ssh.FingerprintSHA256(key); actual != fingerprint {
+			return errors.Errorf("HOST KEY MISMATCH: presented %s, expected %s. Refusing to connect", actual, fingerprint)
+		}
+		return nil
+	}
+
+	expected := strings.ToLower(strings.TrimPrefix(fingerprint, "MD5:"))
+	if actual := ssh.FingerprintLegacyMD5(key); actual != expected {
+		return errors.Errorf("HOST KEY MISMATCH: presented MD5:%s, expected %s. Refusing to connect", actual, fingerprint)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"github.com/samhug/ndm/config"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"testing"
+)
+
+func generateTestHostKey(t *testing.T) ssh.PublicKey {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+
+	pub, err := ssh.NewPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+
+	return pub
+}
+
+func TestHostKeyVerifier_TOFU(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ndm-known-hosts")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	knownHosts := path.Join(dir, "ssh", "known_hosts")
+	remote := &net.TCPAddr{IP: net.ParseIP("192.168.1.1"), Port: 22}
+	key := generateTestHostKey(t)
+
+	v, err := NewHostKeyVerifier(HostKeyPolicyTOFU, knownHosts)
+	require.NoError(t, err)
+
+	// The first connection records the key
+	require.NoError(t, v.Callback("")("192.168.1.1:22", remote, key))
+
+	data, err := ioutil.ReadFile(knownHosts)
+	require.NoError(t, err)
+	require.Contains(t, string(data), "192.168.1.1 ssh-rsa ")
+
+	// Subsequent connections with the same key succeed
+	require.NoError(t, v.Callback("")("192.168.1.1:22", remote, key))
+
+	// A changed key is refused
+	err = v.Callback("")("192.168.1.1:22", remote, generateTestHostKey(t))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "HOST KEY CHANGED")
+
+	// A strict verifier using the same file accepts the recorded key but refuses unknown hosts
+	strict, err := NewHostKeyVerifier(HostKeyPolicyStrict, knownHosts)
+	require.NoError(t, err)
+
+	require.NoError(t, strict.Callback("")("192.168.1.1:22", remote, key))
+	require.Error(t, strict.Callback("")("192.168.1.2:22", remote, key))
+}
+
+func TestHostKeyVerifier_Fingerprint(t *testing.T) {
+	remote := &net.TCPAddr{IP: net.ParseIP("192.168.1.1"), Port: 22}
+	key := generateTestHostKey(t)
+
+	v, err := NewHostKeyVerifier(HostKeyPolicyInsecure, "")
+	require.NoError(t, err)
+
+	// Without a pinned fingerprint anything goes
+	require.NoError(t, v.Callback("")("192.168.1.1:22", remote, key))
+
+	// Pinned fingerprints are enforced regardless of the policy
+	require.NoError(t, v.Callback(ssh.FingerprintSHA256(key))("192.168.1.1:22", remote, key))
+	require.NoError(t, v.Callback("MD5:"+ssh.FingerprintLegacyMD5(key))("192.168.1.1:22", remote, key))
+	require.Error(t, v.Callback(ssh.FingerprintSHA256(key))("192.168.1.1:22", remote, generateTestHostKey(t)))
+
+	// Strict mode without a known_hosts file can only verify pinned keys
+	strict, err := NewHostKeyVerifier(HostKeyPolicyStrict, "")
+	require.NoError(t, err)
+
+	require.Error(t, strict.Callback("")("192.168.1.1:22", remote, key))
+	require.NoError(t, strict.Callback(ssh.FingerprintSHA256(key))("192.168.1.1:22", remote, key))
+}
+
+func TestNewHostKeyVerifier_Invalid(t *testing.T) {
+	_, err := NewHostKeyVerifier("paranoid", "")
+	require.Error(t, err)
+
+	_, err = NewHostKeyVerifier(HostKeyPolicyTOFU, "")
+	require.Error(t, err)
+
+	_, err = NewHostKeyVerifier(HostKeyPolicyStrict, "/nonexistent/known_hosts")
+	require.Error(t, err)
+}
+
+func TestHostKeyPolicy_MatchesConfig(t *testing.T) {
+	// The config package validates host_key_policy with its own copy of the policy names
+	require.Equal(t, HostKeyPolicyStrict, config.HostKeyPolicyStrict)
+	require.Equal(t, HostKeyPolicyTOFU, config.HostKeyPolicyTOFU)
+	require.Equal(t, HostKeyPolicyInsecure, config.HostKeyPolicyInsecure)
+}
+
+func TestHostKeyVerifier_HostKeyAlgorithms(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ndm-known-hosts")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	edPub, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	edKey, err := ssh.NewPublicKey(edPub)
+	require.NoError(t, err)
+
+	knownHosts := path.Join(dir, "known_hosts")
+	lines := knownhosts.Line([]string{"192.168.1.1"}, edKey) + "\n" +
+		knownhosts.Line([]string{"192.168.1.1"}, generateTestHostKey(t)) + "\n" +
+		knownhosts.Line([]string{"[192.168.1.2]:2222"}, edKey) + "\n"
+	require.NoError(t, ioutil.WriteFile(knownHosts, []byte(lines), 0600))
+
+	v, err := NewHostKeyVerifier(HostKeyPolicyStrict, knownHosts)
+	require.NoError(t, err)
+
+	// Only the key types known for the host are negotiated
+	require.Equal(t, []string{"ssh-ed25519", "ssh-rsa"}, v.HostKeyAlgorithms("", "192.168.1.1:22"))
+	require.Equal(t, []string{"ssh-ed25519"}, v.HostKeyAlgorithms("", "192.168.1.2:2222"))
+
+	// Unknown hosts and pinned keys use the default algorithms
+	require.Nil(t, v.HostKeyAlgorithms("", "192.168.1.3:22"))
+	require.Nil(t, v.HostKeyAlgorithms(ssh.FingerprintSHA256(edKey), "192.168.1.1:22"))
+
+	insecure, err := NewHostKeyVerifier(HostKeyPolicyInsecure, knownHosts)
+	require.NoError(t, err)
+	require.Nil(t, insecure.HostKeyAlgorithms("", "192.168.1.1:22"))
+}
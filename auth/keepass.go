@@ -13,10 +13,15 @@ var UsernameNotFound = errors.Errorf("Unable to find a username in KeePass entry
 var PasswordNotFound = errors.Errorf("Unable to find a password in KeePass entry")
 var AttributeNotFound = errors.Errorf("Unable to find attribute in KeePass entry")
 
-//var PrivateKeyNotFound = errors.Errorf("Unable to find a private key in KeePass entry")
+// Entry fields and attachments used to hold an SSH private key
+const (
+	keePassPrivateKeyField = "SSHPrivateKey"
+	keePassPassphraseField = "SSHPrivateKeyPassphrase"
+)
 
 type KeePassAuth struct {
 	entry *gokeepasslib.Entry
+	db    *gokeepasslib.Database
 }
 
 func (t *KeePassAuth) GetSSHClientConfig() (*ssh.ClientConfig, error) {
@@ -25,33 +30,56 @@ func (t *KeePassAuth) GetSSHClientConfig() (*ssh.ClientConfig, error) {
 		return nil, errors.New(UsernameNotFound)
 	}
 
-	passwordVal := t.entry.Get("Password")
-	if usernameVal == nil {
-		return nil, errors.New(PasswordNotFound)
+	// If there is an SSH key in the entry, we will use that for authentication with the password as a fallback
+	var signer ssh.Signer
+	if sshKey, err := t.privateKey(); err != nil {
+		return nil, err
+	} else if sshKey != nil {
+		signer, err = ParsePrivateKey(sshKey, t.entry.GetContent(keePassPassphraseField))
+		if err != nil {
+			return nil, errors.Errorf("Unable to parse private key: %s", err)
+		}
+	}
+
+	methods, err := sshAuthMethods(signer, t.entry.GetPassword())
+	if err != nil {
+		return nil, err
 	}
 
-	/*
-		// If there is an SSH key in the entry, we will use that for authentication. Otherwise fallback to user/pass method
-		sshKey := t.entry.Get("SSHPrivateKey")
-		if sshKey == nil {
-			return nil, errors.New(PrivateKeyNotFound)
+	config := GetDefaultClientConfig()
+	config.User = usernameVal.Value.Content
+	config.Auth = methods
+	return config, nil
+}
+
+// privateKey returns the SSH private key stored in the entry, either in the SSHPrivateKey field or in an attachment
+// named SSHPrivateKey. It returns nil if the entry doesn't contain a key.
+func (t *KeePassAuth) privateKey() ([]byte, error) {
+	if key := t.entry.GetContent(keePassPrivateKeyField); key != "" {
+		return []byte(key), nil
+	}
+
+	for _, ref := range t.entry.Binaries {
+		if ref.Name != keePassPrivateKeyField && !strings.HasPrefix(ref.Name, keePassPrivateKeyField+".") {
+			continue
 		}
-		// Create the Signer for this private key.
-		signer, err := ssh.ParsePrivateKey([]byte(sshKey.Value.Content))
+
+		binary := ref.Find(t.db.Content.Meta.Binaries)
+		if binary == nil {
+			return nil, errors.Errorf("Unable to find the contents of attachment '%s'", ref.Name)
+		}
+
+		key, err := binary.GetContent()
 		if err != nil {
-			return nil, errors.Wrap("unable to parse private key", err)
+			return nil, errors.Errorf("Unable to read attachment '%s': %s", ref.Name, err)
 		}
-	*/
 
-	config := GetDefaultClientConfig()
-	config.User = usernameVal.Value.Content
-	config.Auth = []ssh.AuthMethod{
-		// TODO: Use the PublicKeys method for remote authentication.
-		//ssh.PublicKeys(signer),
-		ssh.Password(passwordVal.Value.Content),
+		return []byte(key), nil
 	}
-	return config, nil
+
+	return nil, nil
 }
+
 func (t *KeePassAuth) GetAttribute(attr_name string) (string, error) {
 	attrVal := t.entry.Get(attr_name)
 	if attrVal == nil {
@@ -106,14 +134,22 @@ func (t *KeePassProvider) Lookup(path string) (Auth, error) {
 		return nil, errors.New("Unable to find a username in entry")
 	}
 
+	a := &KeePassAuth{
+		entry: entry,
+		db:    t.db,
+	}
+
+	sshKey, err := a.privateKey()
+	if err != nil {
+		return nil, err
+	}
+
 	passwordVal := entry.Get("Password")
-	if passwordVal == nil {
-		return nil, errors.New("Unable to find a password in entry")
+	if passwordVal == nil && sshKey == nil {
+		return nil, errors.New("Unable to find a password or SSH private key in entry")
 	}
 
-	return &KeePassAuth{
-		entry: entry,
-	}, nil
+	return a, nil
 }
 
 func (t *KeePassProvider) ResolveEntryPath(path string) (*gokeepasslib.Entry, error) {
@@ -2,6 +2,7 @@ package auth
 
 import (
 	"github.com/stretchr/testify/require"
+	"github.com/tobischo/gokeepasslib"
 	"golang.org/x/crypto/ssh"
 	"testing"
 )
@@ -41,3 +42,46 @@ func TestKeePassBasic(t *testing.T) {
 	_, err = kp.Lookup("test/Fake Entry")
 	require.Error(t, err)
 }
+
+func newTestKeePassEntry(values map[string]string) gokeepasslib.Entry {
+	entry := gokeepasslib.NewEntry()
+	for k, v := range values {
+		entry.Values = append(entry.Values, gokeepasslib.ValueData{Key: k, Value: gokeepasslib.V{Content: v}})
+	}
+	return entry
+}
+
+func TestKeePassAuth_PrivateKey(t *testing.T) {
+	db := gokeepasslib.NewDatabase()
+
+	// Key stored in an entry field, with a password fallback
+	entry := newTestKeePassEntry(map[string]string{
+		"UserName":                "User Name",
+		"Password":                "Password",
+		"SSHPrivateKey":           string(generateTestKey(t, "secret")),
+		"SSHPrivateKeyPassphrase": "secret",
+	})
+	a := &KeePassAuth{entry: &entry, db: db}
+
+	config, err := a.GetSSHClientConfig()
+	require.NoError(t, err)
+	require.Equal(t, "User Name", config.User)
+	require.Len(t, config.Auth, 2)
+
+	// Key stored as an attachment, without a password
+	binary := db.Content.Meta.Binaries.Add(generateTestKey(t, ""))
+	entry = newTestKeePassEntry(map[string]string{"UserName": "User Name"})
+	entry.Binaries = append(entry.Binaries, binary.CreateReference("SSHPrivateKey"))
+	a = &KeePassAuth{entry: &entry, db: db}
+
+	config, err = a.GetSSHClientConfig()
+	require.NoError(t, err)
+	require.Len(t, config.Auth, 1)
+
+	// Neither a key nor a password
+	entry = newTestKeePassEntry(map[string]string{"UserName": "User Name"})
+	a = &KeePassAuth{entry: &entry, db: db}
+
+	_, err = a.GetSSHClientConfig()
+	require.Error(t, err)
+}
@@ -11,16 +11,41 @@ type Auth interface {
 }
 
 var AuthNotFound = errors.Errorf("Unable to find auth")
+var CredentialsNotFound = errors.Errorf("Neither a password nor a private key is available")
 
 type Provider interface {
 	Init() error
 	Lookup(key string) (Auth, error)
 }
 
+// ParsePrivateKey parses a PEM encoded SSH private key, decrypting it with passphrase if one is given
+func ParsePrivateKey(pemBytes []byte, passphrase string) (ssh.Signer, error) {
+	if passphrase != "" {
+		return ssh.ParsePrivateKeyWithPassphrase(pemBytes, []byte(passphrase))
+	}
+	return ssh.ParsePrivateKey(pemBytes)
+}
+
+// sshAuthMethods builds the SSH authentication methods for a set of credentials. Public key authentication is tried
+// first when a key is available, with password authentication as the fallback.
+func sshAuthMethods(signer ssh.Signer, password string) ([]ssh.AuthMethod, error) {
+	methods := []ssh.AuthMethod{}
+	if signer != nil {
+		methods = append(methods, ssh.PublicKeys(signer))
+	}
+	if password != "" {
+		methods = append(methods, ssh.Password(password))
+	}
+	if len(methods) == 0 {
+		return nil, errors.New(CredentialsNotFound)
+	}
+	return methods, nil
+}
+
 func GetDefaultClientConfig() *ssh.ClientConfig {
 	config := &ssh.ClientConfig{}
 	config.SetDefaults()
-	// TODO: Allow specification of host keys
+	// Host keys aren't verified unless the caller installs a HostKeyVerifier callback
 	config.HostKeyCallback = ssh.InsecureIgnoreHostKey()
 	return config
 }
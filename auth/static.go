@@ -9,17 +9,19 @@ import (
 type StaticAuth struct {
 	username   string
 	password   string
+	signer     ssh.Signer
 	attributes map[string]string
 }
 
 func (t *StaticAuth) GetSSHClientConfig() (*ssh.ClientConfig, error) {
+	methods, err := sshAuthMethods(t.signer, t.password)
+	if err != nil {
+		return nil, err
+	}
+
 	config := GetDefaultClientConfig()
 	config.User = t.username
-	config.Auth = []ssh.AuthMethod{
-		// TODO: Use the PublicKeys method for remote authentication.
-		//ssh.PublicKeys(signer),
-		ssh.Password(t.password),
-	}
+	config.Auth = methods
 	return config, nil
 }
 func (t *StaticAuth) GetAttribute(attr_name string) (string, error) {
@@ -34,7 +36,7 @@ func (t *StaticAuth) GetAttribute(attr_name string) (string, error) {
 	return val, nil
 }
 
-var _ Auth = &KeePassAuth{}
+var _ Auth = &StaticAuth{}
 
 func NewStaticProvider() *StaticProvider {
 	return &StaticProvider{
@@ -72,5 +74,21 @@ func (t *StaticProvider) AddAuth(path string, username string, password string,
 	return nil
 }
 
+// SetPrivateKey: Configures a previously added auth to authenticate using the given PEM encoded private key
+func (t *StaticProvider) SetPrivateKey(path string, privateKey []byte, passphrase string) error {
+	a, exists := t.auths[path]
+	if !exists {
+		return errors.Errorf("No auth with the path '%s' has been added", path)
+	}
+
+	signer, err := ParsePrivateKey(privateKey, passphrase)
+	if err != nil {
+		return errors.Errorf("Unable to parse private key: %s", err)
+	}
+
+	a.signer = signer
+	return nil
+}
+
 // Assert interface compatibility
 var _ Provider = &StaticProvider{}
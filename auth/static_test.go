@@ -1,6 +1,10 @@
 package auth
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/crypto/ssh"
 	"testing"
@@ -32,3 +36,54 @@ func TestStaticProvider(t *testing.T) {
 	_, err = provider.Lookup("MissingAuth2")
 	require.Error(t, err)
 }
+
+// generateTestKey returns a PEM encoded RSA private key, encrypted with passphrase if one is given
+func generateTestKey(t *testing.T, passphrase string) []byte {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+
+	block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	if passphrase != "" {
+		block, err = x509.EncryptPEMBlock(rand.Reader, block.Type, block.Bytes, []byte(passphrase), x509.PEMCipherAES256)
+		require.NoError(t, err)
+	}
+
+	return pem.EncodeToMemory(block)
+}
+
+func TestStaticProvider_PrivateKey(t *testing.T) {
+
+	provider := NewStaticProvider()
+
+	require.NoError(t, provider.AddAuth("KeyOnly", "User Name", "", map[string]string{}))
+	require.NoError(t, provider.SetPrivateKey("KeyOnly", generateTestKey(t, ""), ""))
+
+	require.NoError(t, provider.AddAuth("KeyAndPassword", "User Name", "Password", map[string]string{}))
+	require.NoError(t, provider.SetPrivateKey("KeyAndPassword", generateTestKey(t, "secret"), "secret"))
+
+	require.NoError(t, provider.AddAuth("Nothing", "User Name", "", map[string]string{}))
+
+	a, err := provider.Lookup("KeyOnly")
+	require.NoError(t, err)
+	config, err := a.GetSSHClientConfig()
+	require.NoError(t, err)
+	require.Len(t, config.Auth, 1)
+
+	// The password is kept as a fallback
+	a, err = provider.Lookup("KeyAndPassword")
+	require.NoError(t, err)
+	config, err = a.GetSSHClientConfig()
+	require.NoError(t, err)
+	require.Len(t, config.Auth, 2)
+
+	a, err = provider.Lookup("Nothing")
+	require.NoError(t, err)
+	_, err = a.GetSSHClientConfig()
+	require.Error(t, err)
+
+	// Wrong passphrase
+	require.Error(t, provider.SetPrivateKey("KeyOnly", generateTestKey(t, "secret"), "wrong"))
+
+	// Unknown auth
+	require.Error(t, provider.SetPrivateKey("MissingAuth", generateTestKey(t, ""), ""))
+}
@@ -3,29 +3,41 @@ package cmd
 import (
 	"fmt"
 	"github.com/go-errors/errors"
-	"github.com/ryanuber/go-glob"
 	"github.com/samhug/ndm/auth"
 	"github.com/samhug/ndm/config"
 	"github.com/samhug/ndm/config/auth_providers"
 	"github.com/samhug/ndm/device_processor"
 	"github.com/samhug/ndm/devices"
+	"github.com/samhug/ndm/history"
+	"github.com/samhug/ndm/report"
+	"github.com/samhug/ndm/scheduler"
 	"github.com/segmentio/go-prompt"
 	"github.com/spf13/cobra"
+	"io/ioutil"
 	"log"
 	"net"
-	"sync"
+	"os"
+	"sort"
+	"time"
 )
 
 func init() {
 	rootCmd.AddCommand(backupCmd)
 
 	backupCmd.Flags().StringVar(&cfgPath, "config", "config.hcl", "config file path")
+	backupCmd.Flags().IntVar(&backupParallel, "parallel", 0, "maximum number of devices to back up concurrently, 0 for no limit (overrides max_parallel)")
+	backupCmd.Flags().StringVar(&backupReport, "report", "", "write a JSON report of the run to this file")
+	backupCmd.Flags().StringVar(&backupTranscriptDir, "transcript-dir", "", "record a transcript of every device session in this directory")
+	addSelectionFlags(backupCmd)
 }
 
 var cfgPath string
+var backupParallel int
+var backupReport string
+var backupTranscriptDir string
 
 var backupCmd = &cobra.Command{
-	Use:   "backup",
+	Use:   "backup [device-glob]...",
 	Short: "Backup device configs",
 	Run:   backupMain,
 }
@@ -44,6 +56,18 @@ func initAuthProviderPool(providersCfg map[string]auth_providers.AuthProviderCon
 				if err = static_provider.AddAuth(path, auth.Username, auth.Password, auth.Attributes); err != nil {
 					return nil, errors.Errorf("Unable to add Auth(%s) to StaticAuthProvider(%s): %s", path, providerName, err)
 				}
+
+				privateKey := []byte(auth.PrivateKey)
+				if auth.PrivateKeyFile != "" {
+					if privateKey, err = ioutil.ReadFile(auth.PrivateKeyFile); err != nil {
+						return nil, errors.Errorf("Unable to read private key for Auth(%s) in StaticAuthProvider(%s): %s", path, providerName, err)
+					}
+				}
+				if len(privateKey) > 0 {
+					if err = static_provider.SetPrivateKey(path, privateKey, auth.Passphrase); err != nil {
+						return nil, errors.Errorf("Unable to add private key for Auth(%s) to StaticAuthProvider(%s): %s", path, providerName, err)
+					}
+				}
 			}
 			provider = static_provider
 		case "keepass":
@@ -69,32 +93,17 @@ func initAuthProviderPool(providersCfg map[string]auth_providers.AuthProviderCon
 
 func backupMain(cmd *cobra.Command, args []string) {
 
-	deviceFilter := "*"
-	if len(args) > 0 {
-		deviceFilter = args[0]
-	}
-
 	cfg, err := config.LoadFile(cfgPath)
 	if err != nil {
 		log.Fatalln("Unable to load configuration:", err)
 	}
 
-	hostIP := cfg.Preferences.HostIP
-	if hostIP == "" {
-		log.Println("No external IP was specified, please choose an IP address for the TFTP server to listen on")
-		hostIP, err = getExternalIPAddr()
-		if err != nil {
-			log.Fatalln("Unable to detect external interface IP address and no HostIP was specified:", err)
-		}
-		log.Printf("IP %s was selected\n", hostIP)
-	}
-
 	authProviderPool, err := initAuthProviderPool(cfg.AuthProviders)
 	if err != nil {
 		log.Fatalln("Unable to initialize the auth provider pool:", err)
 	}
 
-	deviceClasses, err := devices.LoadDeviceClasses(cfg.DeviceClasses)
+	deviceClasses, err := devices.LoadDeviceClasses(cfg.DeviceClasses, cfg.MacroLibraries)
 	if err != nil {
 		log.Fatalln("Error initializing device classes:", err)
 	}
@@ -104,47 +113,160 @@ func backupMain(cmd *cobra.Command, args []string) {
 		log.Fatalln("Error initializing devices:", err)
 	}
 
-	deviceList := filterDevices(_devices, deviceFilter)
+	deviceList, err := selectDevices(_devices, args)
+	if err != nil {
+		log.Fatalln("Invalid device selection:", err)
+	}
 	if len(deviceList) == 0 {
 		log.Fatalln("No devices mached the given filter")
 	}
 
-	tftpReceiver := device_processor.NewTFTPReceiver(hostIP)
-	tftpReceiver.Run()
+	hostKeyPolicy := cfg.Preferences.HostKeyPolicy
+	if hostKeyPolicy == "" {
+		hostKeyPolicy = auth.HostKeyPolicyInsecure
+	}
+	if hostKeyPolicy == auth.HostKeyPolicyInsecure {
+		log.Println("WARNING: Host keys are not being verified, set host_key_policy in the preferences block to enable verification")
+	}
 
-	var wg sync.WaitGroup
+	hostKeys, err := auth.NewHostKeyVerifier(hostKeyPolicy, cfg.Preferences.KnownHosts)
+	if err != nil {
+		log.Fatalln("Unable to initialize host key verification:", err)
+	}
 
-	for _, device := range deviceList {
-		p := device_processor.NewDeviceProcessor(device, authProviderPool, cfg.Preferences.BackupDir)
+	var historyRepo *history.GitRepo
+	if cfg.Preferences.History == config.HistoryGit {
+		historyRepo, err = history.OpenGitRepo(cfg.Preferences.BackupDir)
+		if err != nil {
+			log.Fatalln("Unable to open the backup history repository:", err)
+		}
+	}
+
+	// The TFTP server is only needed when a device uploads its config rather than having it captured
+	var tftpReceiver *device_processor.TFTPReceiver
+	if needsTFTP(deviceList) {
+		hostIP := cfg.Preferences.HostIP
+		if hostIP == "" {
+			log.Println("No external IP was specified, please choose an IP address for the TFTP server to listen on")
+			hostIP, err = getExternalIPAddr()
+			if err != nil {
+				log.Fatalln("Unable to detect external interface IP address and no HostIP was specified:", err)
+			}
+			log.Printf("IP %s was selected\n", hostIP)
+		}
 
-		wg.Add(1)
+		// If the server can't start, the targets that need it fail but the rest of the run continues
+		tftpReceiver = device_processor.NewTFTPReceiver(hostIP)
+		if err := tftpReceiver.Run(); err != nil {
+			log.Println("ERROR:", err)
+		}
+	}
 
-		go func(d *devices.Device) {
-			defer wg.Done()
+	retryDefaults, err := devices.NewRetryPolicy(cfg.Preferences.Retries, cfg.Preferences.RetryBackoff)
+	if err != nil {
+		log.Fatalln("Invalid retry settings:", err)
+	}
+
+	started := time.Now()
 
-			if err := p.Process(tftpReceiver); err != nil {
-				log.Printf("Device Processing Error '%s': %s", d.Name, err)
+	maxParallel := cfg.Preferences.MaxParallel
+	if cmd.Flags().Changed("parallel") {
+		maxParallel = backupParallel
+	}
+
+	sched := scheduler.NewScheduler(maxParallel)
+	for groupName, groupCfg := range cfg.DeviceGroups {
+		sched.SetGroupLimit(groupName, groupCfg.MaxParallel)
+	}
+
+	// Submit the devices in a stable order
+	deviceNames := make([]string, 0, len(deviceList))
+	for name := range deviceList {
+		deviceNames = append(deviceNames, name)
+	}
+	sort.Strings(deviceNames)
+
+	runReport := report.New(started)
+
+	for _, name := range deviceNames {
+		device := deviceList[name]
+		p := device_processor.NewDeviceProcessor(device, authProviderPool, hostKeys, device.RetryPolicy(retryDefaults), cfg.Preferences.BackupDir)
+		p.SetTranscriptDir(backupTranscriptDir)
+
+		sched.Submit(device.Group, func() {
+			results := p.Process(tftpReceiver)
+			for _, result := range results {
+				if result.Status != report.StatusSuccess {
+					log.Printf("Device Processing Error '%s': target %s: %s", device.Name, result.Target, result.Error)
+				}
 			}
-		}(device)
+			runReport.Add(results...)
+		})
 	}
 
-	wg.Wait()
+	sched.Wait()
 
-	tftpReceiver.Stop()
+	if tftpReceiver != nil {
+		tftpReceiver.Stop()
+	}
 
-}
+	runReport.Finish(time.Now())
 
-func filterDevices(_devices map[string]*devices.Device, filter string) map[string]*devices.Device {
+	// A history failure doesn't discard the report of a run that finished
+	var historyErr error
+	if historyRepo != nil {
+		if historyErr = recordHistory(historyRepo, cfg.Preferences.HistoryAuthor, started, runReport.SavedPaths()); historyErr != nil {
+			log.Println("ERROR: Unable to record backup history:", historyErr)
+		}
+	}
 
-	filteredDevices := make(map[string]*devices.Device)
+	fmt.Println()
+	if err := runReport.WriteTable(os.Stdout); err != nil {
+		log.Println("Unable to print the run summary:", err)
+	}
 
-	for k, v := range _devices {
-		if glob.Glob(filter, k) {
-			filteredDevices[k] = v
+	if backupReport != "" {
+		if err := runReport.SaveJSON(backupReport); err != nil {
+			log.Println("ERROR:", err)
+			os.Exit(1)
 		}
 	}
 
-	return filteredDevices
+	// Let cron wrappers and monitoring know something went wrong
+	if runReport.Failed() > 0 || historyErr != nil {
+		os.Exit(1)
+	}
+}
+
+// recordHistory commits any changes to the configs saved during the run, at paths relative to the backup directory, to
+// the backup history repository. Other files in the backup directory aren't recorded.
+func recordHistory(repo *history.GitRepo, author string, started time.Time, paths []string) error {
+	if author == "" {
+		author = history.DefaultAuthor()
+	}
+
+	changed, err := repo.CommitRun(author, started, paths)
+	if err != nil {
+		return err
+	}
+
+	if len(changed) == 0 {
+		log.Println("No config changes detected, nothing to record in the backup history")
+		return nil
+	}
+
+	log.Printf("Recorded config changes for %d device(s) in the backup history\n", len(changed))
+	return nil
+}
+
+// needsTFTP reports whether any of the devices have a backup target that uploads its config via TFTP
+func needsTFTP(deviceList map[string]*devices.Device) bool {
+	for _, device := range deviceList {
+		if device.Class.UsesTFTP() {
+			return true
+		}
+	}
+	return false
 }
 
 func getExternalIPAddr() (string, error) {
@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/samhug/ndm/config"
+	"github.com/samhug/ndm/devices"
+	"github.com/samhug/ndm/history"
+	"github.com/spf13/cobra"
+	"log"
+	"path"
+	"sort"
+	"time"
+)
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+
+	diffCmd.Flags().StringVar(&cfgPath, "config", "config.hcl", "config file path")
+	diffCmd.Flags().StringVar(&diffTarget, "target", "", "only show changes to the named backup target")
+	addSelectionFlags(diffCmd)
+	diffCmd.Flags().StringVar(&diffSince, "since", "", "date|rev to compare against: the last snapshot before a date, such as 2019-02-01, '2019-02-01 15:04' or 7d for a week ago, or a git revision (default: the previous snapshot)")
+}
+
+var diffTarget string
+var diffSince string
+
+var diffCmd = &cobra.Command{
+	Use:   "diff [device-glob]...",
+	Short: "Show device config changes between backup snapshots",
+	Run:   diffMain,
+}
+
+func diffMain(cmd *cobra.Command, args []string) {
+
+	cfg, err := config.LoadFile(cfgPath)
+	if err != nil {
+		log.Fatalln("Unable to load configuration:", err)
+	}
+
+	if cfg.Preferences.History != config.HistoryGit {
+		log.Fatalln("Config history is not enabled, set history = \"git\" in the preferences block")
+	}
+
+	deviceClasses, err := devices.LoadDeviceClasses(cfg.DeviceClasses, cfg.MacroLibraries)
+	if err != nil {
+		log.Fatalln("Error initializing device classes:", err)
+	}
+
+	// Credentials aren't needed to inspect the history, so skip the auth providers entirely
+	_devices, err := devices.LoadDevices(cfg.DeviceGroups, deviceClasses, nil)
+	if err != nil {
+		log.Fatalln("Error initializing devices:", err)
+	}
+
+	deviceList, err := selectDevices(_devices, args)
+	if err != nil {
+		log.Fatalln("Invalid device selection:", err)
+	}
+	if len(deviceList) == 0 {
+		log.Fatalln("No devices mached the given filter")
+	}
+
+	repo, err := history.OpenGitRepo(cfg.Preferences.BackupDir)
+	if err != nil {
+		log.Fatalln("Unable to open the backup history repository:", err)
+	}
+
+	from, err := repo.ResolveSince(diffSince, time.Now())
+	if err != nil {
+		log.Fatalln("Unable to resolve snapshot:", err)
+	}
+
+	diff, err := repo.Diff(from, "HEAD", diffPaths(deviceList, diffTarget))
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	if diff == "" {
+		log.Println("No config changes found")
+		return
+	}
+
+	fmt.Print(diff)
+}
+
+// diffPaths returns the backup file paths, relative to the backup directory, of the given devices and target
+func diffPaths(deviceList map[string]*devices.Device, target string) []string {
+	paths := make([]string, 0, len(deviceList))
+
+	for name := range deviceList {
+		if target != "" {
+			paths = append(paths, path.Join(name, fmt.Sprintf("%s.conf", target)))
+		} else {
+			paths = append(paths, name+"/")
+		}
+	}
+
+	sort.Strings(paths)
+	return paths
+}
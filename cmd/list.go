@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"github.com/samhug/ndm/config"
+	"github.com/samhug/ndm/devices"
+	"github.com/samhug/ndm/inventory"
+	"github.com/spf13/cobra"
+	"log"
+	"os"
+	"strings"
+)
+
+func init() {
+	rootCmd.AddCommand(listCmd)
+
+	listCmd.Flags().StringVar(&cfgPath, "config", "config.hcl", "config file path")
+	listCmd.Flags().StringVar(&listFormat, "format", inventory.FormatTable, "output format, one of: "+strings.Join(inventory.Formats, ", "))
+	addSelectionFlags(listCmd)
+}
+
+var listFormat string
+
+var listCmd = &cobra.Command{
+	Use:   "list [device-glob]...",
+	Short: "List the devices in the inventory",
+	Long: `Lists the devices in the inventory as resolved from the configuration, after includes, device groups and
+device classes have been applied. Credentials are never shown, only the auth provider and path they are looked up in.`,
+	Run: listMain,
+}
+
+func listMain(cmd *cobra.Command, args []string) {
+
+	cfg, err := config.LoadFile(cfgPath)
+	if err != nil {
+		log.Fatalln("Unable to load configuration:", err)
+	}
+
+	deviceClasses, err := devices.LoadDeviceClasses(cfg.DeviceClasses, cfg.MacroLibraries)
+	if err != nil {
+		log.Fatalln("Error initializing device classes:", err)
+	}
+
+	// Listing the inventory shouldn't require unlocking the auth providers
+	_devices, err := devices.LoadDevices(cfg.DeviceGroups, deviceClasses, nil)
+	if err != nil {
+		log.Fatalln("Error initializing devices:", err)
+	}
+
+	deviceList, err := selectDevices(_devices, args)
+	if err != nil {
+		log.Fatalln("Invalid device selection:", err)
+	}
+
+	if err := inventory.New(deviceList).Write(os.Stdout, listFormat); err != nil {
+		log.Fatalln(err)
+	}
+}
@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/samhug/ndm/config"
+	"github.com/samhug/ndm/device_processor"
+	"github.com/samhug/ndm/devices"
+	"github.com/spf13/cobra"
+	"log"
+	"os"
+)
+
+func init() {
+	rootCmd.AddCommand(macroCmd)
+	macroCmd.AddCommand(macroTestCmd)
+
+	macroTestCmd.Flags().StringVar(&cfgPath, "config", "config.hcl", "config file path")
+	macroTestCmd.Flags().StringVar(&macroTestClass, "class", "", "device class whose macro is tested")
+	macroTestCmd.Flags().StringVar(&macroTestTarget, "target", "", "backup target whose macro is tested")
+	macroTestCmd.MarkFlagRequired("class")
+	macroTestCmd.MarkFlagRequired("target")
+}
+
+var macroTestClass string
+var macroTestTarget string
+
+var macroCmd = &cobra.Command{
+	Use:   "macro",
+	Short: "Work with device class macros",
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+var macroTestCmd = &cobra.Command{
+	Use:   "test <transcript>...",
+	Short: "Replay recorded session transcripts against a device class macro",
+	Long: `Replays session transcripts recorded with 'ndm backup --transcript-dir' against a device class macro, without
+connecting to a device. The recorded output is played back to the macro, and everything the macro sends must match
+the recorded input.`,
+	Args: cobra.MinimumNArgs(1),
+	Run:  macroTestMain,
+}
+
+func macroTestMain(cmd *cobra.Command, args []string) {
+
+	cfg, err := config.LoadFile(cfgPath)
+	if err != nil {
+		log.Fatalln("Unable to load configuration:", err)
+	}
+
+	deviceClasses, err := devices.LoadDeviceClasses(cfg.DeviceClasses, cfg.MacroLibraries)
+	if err != nil {
+		log.Fatalln("Error initializing device classes:", err)
+	}
+
+	class, ok := deviceClasses[macroTestClass]
+	if !ok {
+		log.Fatalf("Device class '%s' doesn't exist\n", macroTestClass)
+	}
+
+	device := &devices.Device{Name: fmt.Sprintf("%s-replay", macroTestClass), Class: class}
+
+	failed := 0
+	for _, transcriptPath := range args {
+		if err := replayTranscript(device, transcriptPath); err != nil {
+			fmt.Printf("FAIL %s: %s\n", transcriptPath, err)
+			failed++
+			continue
+		}
+		fmt.Printf("PASS %s\n", transcriptPath)
+	}
+
+	if failed > 0 {
+		fmt.Printf("%d of %d transcript(s) failed\n", failed, len(args))
+		os.Exit(1)
+	}
+}
+
+func replayTranscript(device *devices.Device, transcriptPath string) error {
+	f, err := os.Open(transcriptPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = device_processor.ReplayMacro(device, macroTestTarget, f)
+	return err
+}
@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"github.com/samhug/ndm/devices"
+	"github.com/spf13/cobra"
+)
+
+var selectTags []string
+var selectClasses []string
+var selectGroups []string
+var selectAddresses []string
+
+// addSelectionFlags registers the device selection flags on a command that takes device name globs as arguments
+func addSelectionFlags(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVar(&selectTags, "tag", nil, "only select devices with this tag, may be repeated to require several tags")
+	cmd.Flags().StringSliceVar(&selectClasses, "class", nil, "only select devices of this device_class, may be repeated")
+	cmd.Flags().StringSliceVar(&selectGroups, "group", nil, "only select devices in this device_group, may be repeated")
+	cmd.Flags().StringSliceVar(&selectAddresses, "address", nil, "only select devices with this IP address, CIDR range or hostname, may be repeated")
+}
+
+// selectDevices returns the devices picked by the name globs in args and the selection flags. Prefixing a glob or
+// flag value with '!' excludes the devices it matches.
+func selectDevices(_devices map[string]*devices.Device, args []string) (map[string]*devices.Device, error) {
+	selector, err := devices.NewSelector(args, selectTags, selectClasses, selectGroups, selectAddresses)
+	if err != nil {
+		return nil, err
+	}
+	return selector.Filter(_devices), nil
+}
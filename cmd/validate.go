@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/samhug/ndm/auth"
+	"github.com/samhug/ndm/config"
+	"github.com/samhug/ndm/validation"
+	"github.com/spf13/cobra"
+	"log"
+	"os"
+)
+
+func init() {
+	rootCmd.AddCommand(validateCmd)
+
+	validateCmd.Flags().StringVar(&cfgPath, "config", "config.hcl", "config file path")
+	validateCmd.Flags().BoolVar(&validateResolveAuth, "resolve-auth", false, "look up every device's credentials in its auth provider, which may prompt to unlock them")
+}
+
+var validateResolveAuth bool
+
+var validateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check the configuration for problems without connecting to any devices",
+	Long: `Loads the configuration, compiles every macro, checks each device's device_class and auth references and
+reports unused device classes, auth providers and macro libraries and devices that share an address. Errors loading
+the configuration don't stop the rest of it being checked, every problem is reported with its position in the
+configuration files. Exits with a non-zero status if any errors are found, warnings
+alone don't fail validation.`,
+	Args: cobra.NoArgs,
+	Run:  validateMain,
+}
+
+func validateMain(cmd *cobra.Command, args []string) {
+
+	// Keep going after load errors, so every problem is reported at once
+	cfg, loadErrs := config.LoadFileLenient(cfgPath)
+	if cfg == nil {
+		for _, err := range loadErrs {
+			fmt.Println("Unable to load configuration:", err)
+		}
+		os.Exit(1)
+	}
+
+	var authProviderPool *auth.ProviderPool
+	if validateResolveAuth {
+		var err error
+		authProviderPool, err = initAuthProviderPool(cfg.AuthProviders)
+		if err != nil {
+			log.Fatalln("Unable to initialize the auth provider pool:", err)
+		}
+	}
+
+	problems := validation.Validate(cfg, loadErrs, authProviderPool)
+
+	errorCount := 0
+	for _, problem := range problems {
+		fmt.Println(problem)
+		if problem.Severity == validation.SeverityError {
+			errorCount++
+		}
+	}
+
+	fmt.Printf("%d error(s), %d warning(s)\n", errorCount, len(problems)-errorCount)
+
+	if validation.HasErrors(problems) {
+		os.Exit(1)
+	}
+}
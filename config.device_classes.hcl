@@ -1,24 +1,50 @@
-device_class "cisco_isr" {
-    backup_target "startup_config" {
-        macro = <<-MACRO
+// Functions shared by devices with a Cisco style CLI
+macro_library "cisco_cli" {
+    source = <<-JS
+        // enable enters privileged mode using the device's enable_password auth attribute
+        function enable() {
+            sendLine("enable")
+            expect("Password:")
+            sendLine(getAuthAttr("enable_password"))
             expect("#")
-            sendLine("copy startup-config tftp://" + ctx.TFTPHost + "/" + ctx.TFTPFilename)
+        }
+
+        // copyToTFTP uploads file to our TFTP server given as a tftp:// URL, accepting the host and filename the
+        // device offers as defaults
+        function copyToTFTP(file) {
+            sendLine("copy " + file + " tftp://" + ctx.TFTPHost + "/" + ctx.TFTPFilename)
             expect("["+ctx.TFTPHost+"]?")
             sendLine("")
             expect("["+ctx.TFTPFilename+"]?")
             sendLine("")
             expect("#")
+        }
+
+        // copyToTFTPPrompted uploads file to our TFTP server, answering the device's prompts for the host and filename
+        function copyToTFTPPrompted(file) {
+            sendLine("copy " + file + " tftp")
+            expect("?")
+            sendLine(ctx.TFTPHost)
+            expect("?")
+            sendLine(ctx.TFTPFilename)
+            expect("#")
+        }
+    JS
+}
+
+device_class "cisco_isr" {
+    libraries = ["cisco_cli"]
+
+    backup_target "startup_config" {
+        macro = <<-MACRO
+            expect("#")
+            copyToTFTP("startup-config")
         MACRO
     }
     backup_target "running_config" {
         macro = <<-MACRO
             expect("#")
-            sendLine("copy running-config tftp://" + ctx.TFTPHost + "/" + ctx.TFTPFilename)
-            expect("["+ctx.TFTPHost+"]?")
-            sendLine("")
-            expect("["+ctx.TFTPFilename+"]?")
-            sendLine("")
-            expect("#")
+            copyToTFTP("running-config")
         MACRO
     }
 }
@@ -34,34 +60,20 @@ device_class "hp_switch" {
 }
 
 device_class "adtran" {
+    libraries = ["cisco_cli"]
+
     backup_target "startup_config" {
         macro = <<-MACRO
             expect(">")
-            sendLine("enable")
-            expect("Password:")
-            sendLine(getAuthAttr("enable_password"))
-            expect("#")
-            sendLine("copy startup-config tftp")
-            expect("?")
-            sendLine(ctx.TFTPHost)
-            expect("?")
-            sendLine(ctx.TFTPFilename)
-            expect("#")
+            enable()
+            copyToTFTPPrompted("startup-config")
         MACRO
     }
     backup_target "running_config" {
         macro = <<-MACRO
             expect(">")
-            sendLine("enable")
-            expect("Password:")
-            sendLine(getAuthAttr("enable_password"))
-            expect("#")
-            sendLine("copy running-config tftp")
-            expect("?")
-            sendLine(ctx.TFTPHost)
-            expect("?")
-            sendLine(ctx.TFTPFilename)
-            expect("#")
+            enable()
+            copyToTFTPPrompted("running-config")
         MACRO
     }
 }
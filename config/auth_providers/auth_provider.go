@@ -9,7 +9,9 @@ type AuthProviderConfig interface {
 	Type() string
 }
 
-func LoadAuthProviderConfigHcl(list *ast.ObjectList, providers *map[string]AuthProviderConfig) error {
+// LoadAuthProviderConfigHcl loads auth_provider blocks into providers. Relative paths in the blocks are resolved
+// against configDir, the directory of the config file that defines them.
+func LoadAuthProviderConfigHcl(list *ast.ObjectList, configDir string, providers *map[string]AuthProviderConfig) error {
 	list = list.Children()
 	if len(list.Items) == 0 {
 		return nil
@@ -19,9 +21,9 @@ func LoadAuthProviderConfigHcl(list *ast.ObjectList, providers *map[string]AuthP
 
 	// - Static
 	if o := list.Filter("static"); len(o.Items) > 0 {
-		err := loadStaticAuthProviderConfigHcl(o, providers)
+		err := loadStaticAuthProviderConfigHcl(o, configDir, providers)
 		if err != nil {
-			multierror.Append(errorAccum, err)
+			errorAccum = multierror.Append(errorAccum, err)
 		}
 	}
 
@@ -29,7 +31,7 @@ func LoadAuthProviderConfigHcl(list *ast.ObjectList, providers *map[string]AuthP
 	if o := list.Filter("keepass"); len(o.Items) > 0 {
 		err := loadKeePassAuthProviderConfigHcl(o, providers)
 		if err != nil {
-			multierror.Append(errorAccum, err)
+			errorAccum = multierror.Append(errorAccum, err)
 		}
 	}
 
@@ -28,7 +28,7 @@ func TestAuthProviderConfig_Basic(t *testing.T) {
 	require.True(t, ok)
 
 	result := make(map[string]AuthProviderConfig)
-	err = LoadAuthProviderConfigHcl(list.Filter("auth_provider"), &result)
+	err = LoadAuthProviderConfigHcl(list.Filter("auth_provider"), "", &result)
 	require.NoError(t, err)
 
 	expected := map[string]AuthProviderConfig{
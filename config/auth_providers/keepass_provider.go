@@ -40,7 +40,8 @@ func loadKeePassAuthProviderConfigHcl(list *ast.ObjectList, providers *map[strin
 		// Decode the parse tree into an object map
 		var parsed map[string]interface{}
 		if err := hcl.DecodeObject(&parsed, item.Val); err != nil {
-			return err
+			errorAccum = multierror.Append(errorAccum, utilities.ErrorAt(item.Pos(), "auth_provider 'keepass' '%s': %s", name, err))
+			continue
 		}
 
 		decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
@@ -53,11 +54,11 @@ func loadKeePassAuthProviderConfigHcl(list *ast.ObjectList, providers *map[strin
 
 		// Decode the object map into our structure
 		if err := decoder.Decode(parsed); err != nil {
-			errorAccum = multierror.Append(errorAccum, errors.Errorf("auth_provider 'keepass' '%s': %s", name, err))
+			errorAccum = multierror.Append(errorAccum, utilities.ErrorAt(item.Pos(), "auth_provider 'keepass' '%s': %s", name, err))
 		}
 
 		if err = utilities.CheckForRequiredFields(&metadata, []string{"db_path"}); err != nil {
-			errorAccum = multierror.Append(errorAccum, errors.Errorf("auth_provider 'keepass' '%s': %s", name, err))
+			errorAccum = multierror.Append(errorAccum, utilities.ErrorAt(item.Pos(), "auth_provider 'keepass' '%s': %s", name, err))
 		}
 
 		// Append the result
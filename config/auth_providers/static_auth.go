@@ -7,16 +7,21 @@ import (
 	"github.com/hashicorp/hcl/hcl/ast"
 	"github.com/mitchellh/mapstructure"
 	"github.com/samhug/ndm/config/utilities"
+	"path/filepath"
 )
 
-// StaticAuthConfig represents a auth configuration block within a auth_provider "static" "..." {} block.
+// StaticAuthConfig represents a auth configuration block within a auth_provider "static" "..." {} block. A relative
+// PrivateKeyFile is resolved against the directory of the config file that defines the block when it's loaded.
 type StaticAuthConfig struct {
-	Username   string            `mapstructure:"username,"`
-	Password   string            `mapstructure:"password,"`
-	Attributes map[string]string `mapstructure:"attributes,"`
+	Username       string            `mapstructure:"username,"`
+	Password       string            `mapstructure:"password,"`
+	PrivateKey     string            `mapstructure:"private_key,"`
+	PrivateKeyFile string            `mapstructure:"private_key_file,"`
+	Passphrase     string            `mapstructure:"passphrase,"`
+	Attributes     map[string]string `mapstructure:"attributes,"`
 }
 
-func loadStaticAuthsHcl(list *ast.ObjectList) (map[string]*StaticAuthConfig, error) {
+func loadStaticAuthsHcl(providerName string, configDir string, list *ast.ObjectList) (map[string]*StaticAuthConfig, error) {
 	//fmt.Println("list: %v", list)
 	list = list.Children()
 	if len(list.Items) == 0 {
@@ -39,7 +44,8 @@ func loadStaticAuthsHcl(list *ast.ObjectList) (map[string]*StaticAuthConfig, err
 		// Decode the parse tree into an object map
 		var parsed map[string]interface{}
 		if err := hcl.DecodeObject(&parsed, item.Val); err != nil {
-			return nil, err
+			errorAccum = multierror.Append(errorAccum, utilities.ErrorAt(item.Pos(), "auth_provider '%s': auth '%s': %s", providerName, name, err))
+			continue
 		}
 
 		decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
@@ -54,11 +60,24 @@ func loadStaticAuthsHcl(list *ast.ObjectList) (map[string]*StaticAuthConfig, err
 
 		// Decode the object map into our structure
 		if err := decoder.Decode(parsed); err != nil {
-			errorAccum = multierror.Append(errorAccum, errors.Errorf("auth '%s': %s", name, err))
+			errorAccum = multierror.Append(errorAccum, utilities.ErrorAt(item.Pos(), "auth_provider '%s': auth '%s': %s", providerName, name, err))
 		}
 
-		if err = utilities.CheckForRequiredFields(&metadata, []string{"username", "password"}); err != nil {
-			errorAccum = multierror.Append(errorAccum, errors.Errorf("auth '%s': %s", name, err))
+		if err = utilities.CheckForRequiredFields(&metadata, []string{"username"}); err != nil {
+			errorAccum = multierror.Append(errorAccum, utilities.ErrorAt(item.Pos(), "auth_provider '%s': auth '%s': %s", providerName, name, err))
+		}
+
+		// A private key may be used in place of, or in addition to, a password
+		if result.PrivateKey != "" && result.PrivateKeyFile != "" {
+			errorAccum = multierror.Append(errorAccum, utilities.ErrorAt(item.Pos(), "auth_provider '%s': auth '%s': Only one of 'private_key' and 'private_key_file' may be specified", providerName, name))
+		} else if result.PrivateKey == "" && result.PrivateKeyFile == "" {
+			if err = utilities.CheckForRequiredFields(&metadata, []string{"password"}); err != nil {
+				errorAccum = multierror.Append(errorAccum, utilities.ErrorAt(item.Pos(), "auth_provider '%s': auth '%s': %s", providerName, name, err))
+			}
+		}
+
+		if result.PrivateKeyFile != "" && !filepath.IsAbs(result.PrivateKeyFile) {
+			result.PrivateKeyFile = filepath.Join(configDir, result.PrivateKeyFile)
 		}
 
 		// Append the result
@@ -66,7 +85,7 @@ func loadStaticAuthsHcl(list *ast.ObjectList) (map[string]*StaticAuthConfig, err
 	}
 
 	if errorAccum.ErrorOrNil() != nil {
-		return nil, errorAccum
+		return results, errorAccum
 	}
 
 	return results, nil
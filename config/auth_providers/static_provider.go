@@ -1,8 +1,9 @@
 package auth_providers
 
 import (
-	"github.com/go-errors/errors"
+	"github.com/hashicorp/go-multierror"
 	"github.com/hashicorp/hcl/hcl/ast"
+	"github.com/samhug/ndm/config/utilities"
 )
 
 type StaticAuthProviderConfig struct {
@@ -16,12 +17,14 @@ func (t *StaticAuthProviderConfig) Type() string {
 // Assert interface implementation
 var _ AuthProviderConfig = &StaticAuthProviderConfig{}
 
-func loadStaticAuthProviderConfigHcl(list *ast.ObjectList, providers *map[string]AuthProviderConfig) error {
+func loadStaticAuthProviderConfigHcl(list *ast.ObjectList, configDir string, providers *map[string]AuthProviderConfig) error {
 	list = list.Children()
 	if len(list.Items) == 0 {
 		return nil
 	}
 
+	var errorAccum *multierror.Error
+
 	for _, item := range list.Items {
 		name := item.Keys[0].Token.Value().(string)
 
@@ -29,22 +32,25 @@ func loadStaticAuthProviderConfigHcl(list *ast.ObjectList, providers *map[string
 		if ot, ok := item.Val.(*ast.ObjectType); ok {
 			listVal = ot.List
 		} else {
-			return errors.Errorf("auth_provoder '%s': auth should be an object", name)
+			errorAccum = multierror.Append(errorAccum, utilities.ErrorAt(item.Pos(), "auth_provider '%s': auth should be an object", name))
+			continue
 		}
 
-		auths, err := loadStaticAuthsHcl(listVal.Filter("auth"))
+		// A provider whose auths have errors is still added, so devices that use it don't report it missing
+		auths, err := loadStaticAuthsHcl(name, configDir, listVal.Filter("auth"))
 		if err != nil {
-			return err
+			errorAccum = multierror.Append(errorAccum, err)
 		}
 		provider := &StaticAuthProviderConfig{Auths: auths}
 
 		if _, ok := (*providers)[name]; ok {
-			return errors.Errorf("auth_provoder '%s': auth_provider already exists with that name", name)
+			errorAccum = multierror.Append(errorAccum, utilities.ErrorAt(item.Pos(), "auth_provider '%s': auth_provider already exists with that name", name))
+			continue
 		}
 
 		// Append the result
 		(*providers)[name] = provider
 	}
 
-	return nil
+	return errorAccum.ErrorOrNil()
 }
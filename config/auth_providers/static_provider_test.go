@@ -27,7 +27,7 @@ auth_provider "static" "test" {
 	require.True(t, ok)
 
 	result := make(map[string]AuthProviderConfig)
-	err = loadStaticAuthProviderConfigHcl(list.Filter("auth_provider", "static"), &result)
+	err = loadStaticAuthProviderConfigHcl(list.Filter("auth_provider", "static"), "", &result)
 	require.NoError(t, err)
 
 	expected := map[string]AuthProviderConfig{
@@ -1,93 +1,75 @@
 package config
 
 import (
-	"github.com/go-errors/errors"
+	"github.com/hashicorp/go-multierror"
 	"github.com/hashicorp/hcl/hcl/ast"
 	"github.com/samhug/ndm/config/auth_providers"
 	"github.com/samhug/ndm/config/utilities"
-	"path"
 )
 
 type Config struct {
-	ConfigDir     string
-	ConfigName    string
-	Preferences   *PreferencesConfig
-	AuthProviders map[string]auth_providers.AuthProviderConfig
-	DeviceClasses map[string]*DeviceClassConfig
-	DeviceGroups  map[string]*DeviceGroupConfig
+	ConfigDir      string
+	ConfigName     string
+	Preferences    *PreferencesConfig
+	AuthProviders  map[string]auth_providers.AuthProviderConfig
+	DeviceClasses  map[string]*DeviceClassConfig
+	DeviceGroups   map[string]*DeviceGroupConfig
+	MacroLibraries map[string]*MacroLibraryConfig
+	// Positions records where the blocks above are defined in the configuration files
+	Positions *Positions
 }
 
-func loadIncludes(list *ast.ObjectList, cfg *Config) error {
-	list = list.Children()
-	if len(list.Items) == 0 {
-		return nil
-	}
-
-	for _, item := range list.Items {
-		name := item.Keys[0].Token.Value().(string)
+// loadConfigHcl loads the blocks of a configuration file into cfg. dir is the directory relative paths in the file are
+// resolved against, the directory containing it. A block that fails to load doesn't stop the others loading, the
+// errors are all returned together.
+func loadConfigHcl(list *ast.ObjectList, cfg *Config, dir string, includes *includeSet) error {
+	var errorAccum *multierror.Error
 
-		includePath := path.Join(cfg.ConfigDir, name)
-
-		f, err := utilities.LoadFileHcl(includePath)
-		if err != nil {
-			return errors.Errorf("include '%s': %s", name, err)
-		}
-
-		// Top-level item should be the object list
-		list, ok := f.Node.(*ast.ObjectList)
-		if !ok {
-			return errors.New("error parsing: config doesn't contain a root object")
-		}
-
-		err = loadConfigHcl(list, cfg)
-		if err != nil {
-			return errors.Errorf("include '%s': %s", name, err)
-		}
-	}
-
-	return nil
-}
-
-func loadConfigHcl(list *ast.ObjectList, cfg *Config) error {
 	// Include
 	if o := list.Filter("include"); len(o.Items) > 0 {
-		err := loadIncludes(o, cfg)
-		if err != nil {
-			return err
+		if err := loadIncludes(o, cfg, dir, includes); err != nil {
+			errorAccum = multierror.Append(errorAccum, err)
 		}
 	}
 
 	// Preferences
 	if o := list.Filter("preferences"); len(o.Items) > 0 {
 		if err := loadPreferencesHcl(o, cfg.Preferences); err != nil {
-			return err
+			errorAccum = multierror.Append(errorAccum, err)
 		}
 	}
 
 	if o := list.Filter("auth_provider"); len(o.Items) > 0 {
-		if err := auth_providers.LoadAuthProviderConfigHcl(o, &cfg.AuthProviders); err != nil {
-			return err
+		if err := auth_providers.LoadAuthProviderConfigHcl(o, dir, &cfg.AuthProviders); err != nil {
+			errorAccum = multierror.Append(errorAccum, err)
+		}
+	}
+
+	// Macro Libraries
+	if o := list.Filter("macro_library"); len(o.Items) > 0 {
+		if err := loadMacroLibraryConfigsHcl(o, dir, &cfg.MacroLibraries); err != nil {
+			errorAccum = multierror.Append(errorAccum, err)
 		}
 	}
 
 	// DeviceClasses
 	if o := list.Filter("device_class"); len(o.Items) > 0 {
 		if err := loadDeviceClassConfigsHcl(o, &cfg.DeviceClasses); err != nil {
-			return err
+			errorAccum = multierror.Append(errorAccum, err)
 		}
 	}
 
 	// Devices
 	if o := list.Filter("device"); len(o.Items) > 0 {
 		if err := loadDeviceConfigsHcl(o, &cfg.DeviceGroups[""].Devices, &cfg.DeviceClasses, &cfg.AuthProviders); err != nil {
-			return err
+			errorAccum = multierror.Append(errorAccum, err)
 		}
 	}
 
 	// Device Groups
 	if o := list.Filter("device_group"); len(o.Items) > 0 {
 		if err := loadDeviceGroupConfigsHcl(o, &cfg.DeviceGroups, &cfg.DeviceClasses, &cfg.AuthProviders); err != nil {
-			return err
+			errorAccum = multierror.Append(errorAccum, err)
 		}
 	}
 
@@ -97,6 +79,7 @@ func loadConfigHcl(list *ast.ObjectList, cfg *Config) error {
 		"preferences":   struct{}{},
 		"auth_provider": struct{}{},
 		"device_class":  struct{}{},
+		"macro_library": struct{}{},
 		"device_group":  struct{}{},
 		"device":        struct{}{},
 	}
@@ -110,8 +93,10 @@ func loadConfigHcl(list *ast.ObjectList, cfg *Config) error {
 			continue
 		}
 
-		return errors.Errorf("Unrecognized key '%s'", k)
+		errorAccum = multierror.Append(errorAccum, utilities.ErrorAt(item.Pos(), "Unrecognized key '%s'", k))
 	}
 
-	return nil
+	recordPositions(list, cfg.Positions)
+
+	return errorAccum.ErrorOrNil()
 }
@@ -1,8 +1,14 @@
 package config
 
 import (
+	"fmt"
+	"github.com/hashicorp/hcl/hcl/token"
 	"github.com/samhug/ndm/config/auth_providers"
 	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
 	"testing"
 )
 
@@ -37,6 +43,7 @@ device "classA" "deviceA" {
 	`
 	result, err := LoadString(buf)
 	require.NoError(t, err)
+	result.Positions = nil // Covered by TestConfig_Positions
 
 	expected := &Config{
 		Preferences: &PreferencesConfig{BackupDir: "/backup_dir/path", HostIP: "10.10.10.10"},
@@ -54,10 +61,12 @@ device "classA" "deviceA" {
 		},
 
 		DeviceGroups: map[string]*DeviceGroupConfig{
-			"": {map[string]*DeviceConfig{
+			"": {Devices: map[string]*DeviceConfig{
 				"deviceA": {Name: "deviceA", ClassName: "classA", Address: "127.0.0.1:22", AuthProvider: "basic", AuthPath: "testA"},
 			}},
 		},
+
+		MacroLibraries: map[string]*MacroLibraryConfig{},
 	}
 	require.Equal(t, expected, result)
 }
@@ -85,6 +94,7 @@ device "classA" "deviceA" {
 	`
 	result, err := LoadString(buf)
 	require.NoError(t, err)
+	result.Positions = nil // Covered by TestConfig_Positions
 
 	expected := &Config{
 		Preferences: &PreferencesConfig{BackupDir: "/backup_dir/path", HostIP: "10.10.10.10"},
@@ -102,10 +112,76 @@ device "classA" "deviceA" {
 		},
 
 		DeviceGroups: map[string]*DeviceGroupConfig{
-			"": {map[string]*DeviceConfig{
+			"": {Devices: map[string]*DeviceConfig{
 				"deviceA": {Name: "deviceA", ClassName: "classA", Address: "127.0.0.1:22", AuthProvider: "basic", AuthPath: "testA"},
 			}},
 		},
+
+		MacroLibraries: map[string]*MacroLibraryConfig{},
 	}
 	require.Equal(t, expected, result)
 }
+
+func TestConfig_Positions(t *testing.T) {
+	cfg, err := LoadFile("test_data/positions.hcl")
+	require.NoError(t, err)
+
+	positions := cfg.Positions
+	require.Equal(t, "test_data/positions.hcl:3", lineOf(positions.AuthProviders["basic"]))
+	require.Equal(t, "test_data/positions.hcl:10", lineOf(positions.MacroLibraries["common"]))
+	require.Equal(t, "test_data/positions.hcl:14", lineOf(positions.DeviceClasses["classB"]))
+	require.Equal(t, "test_data/positions.hcl:15", lineOf(positions.BackupTargets["classB"]["running"]))
+	require.Equal(t, "test_data/positions.hcl:20", lineOf(positions.Devices["deviceB"]))
+	require.Equal(t, "test_data/positions.hcl:25", lineOf(positions.DeviceGroups["site-a"]))
+	require.Equal(t, "test_data/positions.hcl:26", lineOf(positions.Devices["site-a/deviceC"]))
+
+	// Blocks from included files are reported with the included file's path
+	require.Equal(t, "test_data/test_include.conf:2", lineOf(positions.DeviceClasses["classA"]))
+	require.Equal(t, "test_data/test_include.conf:6", lineOf(positions.BackupTargets["classA"]["target2"]))
+}
+
+// lineOf formats a position as file:line
+func lineOf(pos token.Pos) string {
+	return fmt.Sprintf("%s:%d", pos.Filename, pos.Line)
+}
+
+func TestConfig_ErrorPositions(t *testing.T) {
+	// Errors in included files are reported at the include block and at their position in the included file
+	_, err := LoadFile("test_data/errors/main.hcl")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "test_data/errors/main.hcl:3:9: include 'devices.hcl': ")
+	require.Contains(t, err.Error(), "test_data/errors/devices.hcl:8:8: device 'deviceA': Invalid tag 'not a tag'")
+
+	// Syntax errors
+	dir, err := ioutil.TempDir("", "ndm-config")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cfgPath := path.Join(dir, "config.hcl")
+	require.NoError(t, ioutil.WriteFile(cfgPath, []byte("device_class \"classA\" {\n\tbackup_target \"running\" {\n}\n"), 0644))
+	_, err = LoadFile(cfgPath)
+	require.Error(t, err)
+	require.Regexp(t, "^"+regexp.QuoteMeta(cfgPath)+`:\d+:\d+: Error parsing: `, err.Error())
+
+	// Unrecognized blocks
+	_, err = LoadString("preferences {\n\tbackup_dir = \"/backups\"\n}\n\ndevice_type \"classA\" {}\n")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "5:1: Unrecognized key 'device_type'")
+}
+
+func TestLoadFileLenient(t *testing.T) {
+	// Errors in included files are returned individually, at their position in the included file
+	cfg, errs := LoadFileLenient("test_data/errors/main.hcl")
+	require.NotNil(t, cfg)
+	require.Len(t, errs, 1)
+	require.Contains(t, errs[0].Error(), "test_data/errors/devices.hcl:8:8: device 'deviceA': Invalid tag 'not a tag'")
+
+	// The blocks without errors are still loaded
+	require.Contains(t, cfg.DeviceClasses, "classA")
+	require.Contains(t, cfg.AuthProviders, "basic")
+
+	// A file that can't be parsed doesn't produce a Config
+	cfg, errs = LoadFileLenient("test_data/errors/missing.hcl")
+	require.Nil(t, cfg)
+	require.Len(t, errs, 1)
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/mitchellh/mapstructure"
 	"github.com/samhug/ndm/config/auth_providers"
 	"github.com/samhug/ndm/config/utilities"
+	"regexp"
 	"strings"
 )
 
@@ -18,6 +19,26 @@ type DeviceConfig struct {
 	Address      string
 	AuthProvider string
 	AuthPath     string
+	HostKey      string
+	Retries      *int
+	RetryBackoff string
+	// Vars override the vars of the device's group and class
+	Vars map[string]string
+	// Tags label the device for selecting it on the command line
+	Tags []string
+}
+
+// tagPattern matches valid tag names
+var tagPattern = regexp.MustCompile(`^[\w.-]+$`)
+
+// validateTags checks that each tag is a valid name
+func validateTags(tags []string) error {
+	for _, tag := range tags {
+		if !tagPattern.MatchString(tag) {
+			return errors.Errorf("Invalid tag '%s', tags may only contain letters, digits, '_', '.' and '-'", tag)
+		}
+	}
+	return nil
 }
 
 // parseDeviceAuthStr parses out the provider name and path given a device auth string of the form "provider:path".
@@ -33,8 +54,12 @@ func parseDeviceAuthStr(auth_str string) (string, string, error) {
 func loadDeviceConfigsHcl(list *ast.ObjectList, deviceCfgs *map[string]*DeviceConfig, deviceClassCfgs *map[string]*DeviceClassConfig, authProviderCfgs *map[string]auth_providers.AuthProviderConfig) error {
 
 	type hclDevice struct {
-		Address string `mapstructure:"address,"`
-		AuthStr string `mapstructure:"auth,"`
+		Address      string   `mapstructure:"address,"`
+		AuthStr      string   `mapstructure:"auth,"`
+		HostKey      string   `mapstructure:"host_key,"`
+		Retries      *int     `mapstructure:"retries,"`
+		RetryBackoff string   `mapstructure:"retry_backoff,"`
+		Tags         []string `mapstructure:"tags,"`
 	}
 
 	list = list.Children()
@@ -46,7 +71,8 @@ func loadDeviceConfigsHcl(list *ast.ObjectList, deviceCfgs *map[string]*DeviceCo
 
 	for _, item := range list.Items {
 		if len(item.Keys) != 2 {
-			return errors.New("device block must specify a class and a name")
+			errorAccum = multierror.Append(errorAccum, utilities.ErrorAt(item.Pos(), "device block must specify a class and a name"))
+			continue
 		}
 
 		className := item.Keys[0].Token.Value().(string)
@@ -58,9 +84,16 @@ func loadDeviceConfigsHcl(list *ast.ObjectList, deviceCfgs *map[string]*DeviceCo
 		// Decode the parse tree into an object map
 		var parsed map[string]interface{}
 		if err := hcl.DecodeObject(&parsed, item.Val); err != nil {
-			return err
+			errorAccum = multierror.Append(errorAccum, utilities.ErrorAt(item.Pos(), "device '%s': %s", name, err))
+			continue
 		}
 
+		vars, err := decodeVars(parsed["vars"])
+		if err != nil {
+			errorAccum = multierror.Append(errorAccum, utilities.ErrorAt(item.Pos(), "device '%s': %s", name, err))
+		}
+		delete(parsed, "vars")
+
 		decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
 			Metadata: &metadata,
 			Result:   &rawResult,
@@ -71,26 +104,37 @@ func loadDeviceConfigsHcl(list *ast.ObjectList, deviceCfgs *map[string]*DeviceCo
 
 		// Decode the object map into our structure
 		if err := decoder.Decode(parsed); err != nil {
-			errorAccum = multierror.Append(errorAccum, err.(*mapstructure.Error).WrappedErrors()...)
+			for _, err := range err.(*mapstructure.Error).WrappedErrors() {
+				errorAccum = multierror.Append(errorAccum, utilities.ErrorAt(item.Pos(), "device '%s': %s", name, err))
+			}
 		}
 
 		if err = utilities.CheckForRequiredFields(&metadata, []string{"address", "auth"}); err != nil {
-			errorAccum = multierror.Append(errorAccum, errors.Errorf("device '%s': %s", name, err))
+			errorAccum = multierror.Append(errorAccum, utilities.ErrorAt(item.Pos(), "device '%s': %s", name, err))
+		}
+
+		if err = validateRetrySettings(rawResult.Retries, rawResult.RetryBackoff); err != nil {
+			errorAccum = multierror.Append(errorAccum, utilities.ErrorAt(item.Pos(), "device '%s': %s", name, err))
+		}
+
+		if err = validateTags(rawResult.Tags); err != nil {
+			errorAccum = multierror.Append(errorAccum, utilities.ErrorAt(item.Pos(), "device '%s': %s", name, err))
 		}
 
 		if _, ok := (*deviceClassCfgs)[className]; !ok {
-			errorAccum = multierror.Append(errorAccum, errors.Errorf("device '%s': device_class '%s' doesn't exist", name, className))
+			errorAccum = multierror.Append(errorAccum, utilities.ErrorAt(item.Pos(), "device '%s': device_class '%s' doesn't exist", name, className))
 		}
 
 		auth_provider, auth_path, err := parseDeviceAuthStr(rawResult.AuthStr)
 		if err != nil {
-			errorAccum = multierror.Append(errorAccum, errors.Errorf("device '%s': %s", name, err))
+			errorAccum = multierror.Append(errorAccum, utilities.ErrorAt(item.Pos(), "device '%s': %s", name, err))
 		} else if _, ok := (*authProviderCfgs)[auth_provider]; !ok {
-			errorAccum = multierror.Append(errorAccum, errors.Errorf("device '%s': auth_provider '%s' doesn't exist", name, auth_provider))
+			errorAccum = multierror.Append(errorAccum, utilities.ErrorAt(item.Pos(), "device '%s': auth_provider '%s' doesn't exist", name, auth_provider))
 		}
 
 		if _, ok := (*deviceCfgs)[name]; ok {
-			return errors.Errorf("device '%s': device already exists with that name", name)
+			errorAccum = multierror.Append(errorAccum, utilities.ErrorAt(item.Pos(), "device '%s': device already exists with that name", name))
+			continue
 		}
 
 		// Append the result
@@ -100,6 +144,11 @@ func loadDeviceConfigsHcl(list *ast.ObjectList, deviceCfgs *map[string]*DeviceCo
 			ClassName:    className,
 			AuthProvider: auth_provider,
 			AuthPath:     auth_path,
+			HostKey:      rawResult.HostKey,
+			Retries:      rawResult.Retries,
+			RetryBackoff: rawResult.RetryBackoff,
+			Vars:         vars,
+			Tags:         rawResult.Tags,
 		}
 	}
 
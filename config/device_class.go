@@ -5,25 +5,110 @@ import (
 	"github.com/hashicorp/go-multierror"
 	"github.com/hashicorp/hcl"
 	"github.com/hashicorp/hcl/hcl/ast"
+	"github.com/hashicorp/hcl/hcl/token"
 	"github.com/mitchellh/mapstructure"
-	"github.com/samuelhug/ndm/config/utilities"
+	"github.com/samhug/ndm/config/utilities"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
 )
 
 // BackupTargetConfig represents a target configuration block
 type BackupTargetConfig struct {
-	Macro string `mapstructure:"macro,"`
+	Macro         string `mapstructure:"macro,"`
+	Mode          string `mapstructure:"mode,"`
+	Fetch         string `mapstructure:"fetch,"`
+	ExpectTimeout string `mapstructure:"expect_timeout,"`
 }
 
+// Supported values for the backup_target 'mode' field
+const (
+	// BackupModeTFTP: The macro triggers an upload of the config to the built-in TFTP server (the default)
+	BackupModeTFTP = "tftp"
+	// BackupModeCapture: The macro captures the config from the session output
+	BackupModeCapture = "capture"
+	// BackupModeFetch: The config file is downloaded directly, implied by the 'fetch' field
+	BackupModeFetch = "fetch"
+)
+
+// Supported protocols for the backup_target 'fetch' field
+const (
+	FetchSFTP = "sftp"
+	FetchSCP  = "scp"
+)
+
+// ParseFetchStr parses out the protocol and remote path given a fetch string of the form "protocol:path".
+func ParseFetchStr(fetch_str string) (string, string, error) {
+	parts := strings.SplitN(fetch_str, ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return "", "", errors.Errorf("Invalid fetch string (%s). Fetch strings must be of the format \"protocol:path\"", fetch_str)
+	}
+
+	switch parts[0] {
+	case FetchSFTP, FetchSCP:
+	default:
+		return "", "", errors.Errorf("Unsupported fetch protocol '%s'", parts[0])
+	}
+
+	return parts[0], parts[1], nil
+}
+
+// validateExpectTimeout checks an 'expect_timeout' field, which must be a positive duration if it's set
+func validateExpectTimeout(timeout string) error {
+	if timeout == "" {
+		return nil
+	}
+
+	d, err := time.ParseDuration(timeout)
+	if err != nil {
+		return errors.Errorf("Invalid expect_timeout: %s", err)
+	}
+	if d <= 0 {
+		return errors.New("expect_timeout must be positive")
+	}
+
+	return nil
+}
+
+// PagerConfig represents a device_class's pager block, which describes the prompt a device shows when its output
+// fills the screen and the keys to send to continue
+type PagerConfig struct {
+	// Pattern is the pager prompt, empty if the block only sets DisableCommand
+	Pattern string `mapstructure:"pattern,"`
+	Regex   bool   `mapstructure:"regex,"`
+	Send    string `mapstructure:"send,"`
+	// DisableCommand turns paging off for the session, it's sent by the disablePaging macro function
+	DisableCommand string `mapstructure:"disable_command,"`
+}
+
+// DefaultPagerSend is sent in response to pager prompts if the pager block doesn't specify what to send
+const DefaultPagerSend = " "
+
 type DeviceClassConfig struct {
+	// Extends names the device_class this class inherits from, empty if it doesn't extend another class
+	Extends       string
 	BackupTargets map[string]*BackupTargetConfig
+	Retries       *int
+	RetryBackoff  string
+	ExpectTimeout string
+	Pager         *PagerConfig
+	// Libraries names the macro_library blocks loaded before the class's macros, in order
+	Libraries []string
+	// Vars are exposed to the class's macros as ctx.vars
+	Vars map[string]string
 }
 
+// loadDeviceClassConfigsHcl loads device_class blocks into deviceClassCfgs. A class whose backup_targets or pager have
+// errors is still added with the targets that loaded and no pager, so devices that use it don't report it missing.
 func loadDeviceClassConfigsHcl(list *ast.ObjectList, deviceClassCfgs *map[string]*DeviceClassConfig) error {
 	list = list.Children()
 	if len(list.Items) == 0 {
 		return nil
 	}
 
+	var errorAccum *multierror.Error
+
 	for _, item := range list.Items {
 		name := item.Keys[0].Token.Value().(string)
 
@@ -31,27 +116,221 @@ func loadDeviceClassConfigsHcl(list *ast.ObjectList, deviceClassCfgs *map[string
 		if ot, ok := item.Val.(*ast.ObjectType); ok {
 			listVal = ot.List
 		} else {
-			return errors.Errorf("device_class '%s': backup_target should be an object", name)
+			errorAccum = multierror.Append(errorAccum, utilities.ErrorAt(item.Pos(), "device_class '%s': backup_target should be an object", name))
+			continue
+		}
+
+		type hclDeviceClass struct {
+			Extends       string   `mapstructure:"extends,"`
+			Retries       *int     `mapstructure:"retries,"`
+			RetryBackoff  string   `mapstructure:"retry_backoff,"`
+			ExpectTimeout string   `mapstructure:"expect_timeout,"`
+			Libraries     []string `mapstructure:"libraries,"`
+		}
+
+		// Decode the class's own attributes, the backup_target blocks are handled below
+		var parsed map[string]interface{}
+		if err := hcl.DecodeObject(&parsed, item.Val); err != nil {
+			errorAccum = multierror.Append(errorAccum, utilities.ErrorAt(item.Pos(), "device_class '%s': %s", name, err))
+			continue
 		}
+		vars, err := decodeVars(parsed["vars"])
+		if err != nil {
+			errorAccum = multierror.Append(errorAccum, utilities.ErrorAt(item.Pos(), "device_class '%s': %s", name, err))
+			continue
+		}
+		delete(parsed, "backup_target")
+		delete(parsed, "pager")
+		delete(parsed, "vars")
 
-		backupTargets, err := loadBackupTargetConfigHcl(listVal.Filter("backup_target"))
+		var rawResult hclDeviceClass
+		decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+			Result:      &rawResult,
+			ErrorUnused: true,
+		})
 		if err != nil {
-			return err
+			return errors.New("Failed constructing Decoder")
+		}
+		if err := decoder.Decode(parsed); err != nil {
+			errorAccum = multierror.Append(errorAccum, utilities.ErrorAt(item.Pos(), "device_class '%s': %s", name, err))
+			continue
+		}
+		if err := validateRetrySettings(rawResult.Retries, rawResult.RetryBackoff); err != nil {
+			errorAccum = multierror.Append(errorAccum, utilities.ErrorAt(item.Pos(), "device_class '%s': %s", name, err))
+			continue
+		}
+		if err := validateExpectTimeout(rawResult.ExpectTimeout); err != nil {
+			errorAccum = multierror.Append(errorAccum, utilities.ErrorAt(item.Pos(), "device_class '%s': %s", name, err))
+			continue
+		}
+
+		backupTargets, err := loadBackupTargetConfigHcl(name, listVal.Filter("backup_target"))
+		if err != nil {
+			errorAccum = multierror.Append(errorAccum, err)
+		}
+		pager, err := loadPagerConfigHcl(name, listVal.Filter("pager"))
+		if err != nil {
+			errorAccum = multierror.Append(errorAccum, err)
+		}
+		device_class := &DeviceClassConfig{
+			Extends:       rawResult.Extends,
+			BackupTargets: backupTargets,
+			Retries:       rawResult.Retries,
+			RetryBackoff:  rawResult.RetryBackoff,
+			ExpectTimeout: rawResult.ExpectTimeout,
+			Pager:         pager,
+			Libraries:     rawResult.Libraries,
+			Vars:          vars,
 		}
-		device_class := &DeviceClassConfig{BackupTargets: backupTargets}
 
 		if _, ok := (*deviceClassCfgs)[name]; ok {
-			return errors.Errorf("device_class '%s': device_class already exists with that name", name)
+			errorAccum = multierror.Append(errorAccum, utilities.ErrorAt(item.Pos(), "device_class '%s': device_class already exists with that name", name))
+			continue
 		}
 
 		// Append the result
 		(*deviceClassCfgs)[name] = device_class
 	}
 
+	if errorAccum.ErrorOrNil() != nil {
+		return errors.Wrap(errorAccum, 0)
+	}
+
+	return nil
+}
+
+// resolveDeviceClassExtends merges each device_class that extends another with the class it extends. A class inherits
+// the backup_targets of its parent, replacing any it defines with the same name, and its vars, overriding any it sets
+// itself. Its other settings are inherited unless the class sets them.
+func resolveDeviceClassExtends(deviceClassCfgs map[string]*DeviceClassConfig, positions map[string]token.Pos) error {
+	resolved := make(map[string]bool, len(deviceClassCfgs))
+
+	var resolve func(name string, chain []string) error
+	resolve = func(name string, chain []string) error {
+		if resolved[name] {
+			return nil
+		}
+
+		class := deviceClassCfgs[name]
+		if class.Extends == "" {
+			resolved[name] = true
+			return nil
+		}
+
+		chain = append(chain, name)
+		for _, seen := range chain[:len(chain)-1] {
+			if seen == name {
+				return utilities.ErrorAt(positions[name], "device_class '%s': extends cycle %s", name, strings.Join(chain, " -> "))
+			}
+		}
+
+		parent, ok := deviceClassCfgs[class.Extends]
+		if !ok {
+			return utilities.ErrorAt(positions[name], "device_class '%s': extends device_class '%s', which doesn't exist", name, class.Extends)
+		}
+		if err := resolve(class.Extends, chain); err != nil {
+			return err
+		}
+
+		targets := make(map[string]*BackupTargetConfig, len(parent.BackupTargets)+len(class.BackupTargets))
+		for targetName, target := range parent.BackupTargets {
+			targets[targetName] = target
+		}
+		for targetName, target := range class.BackupTargets {
+			targets[targetName] = target
+		}
+		class.BackupTargets = targets
+
+		if class.Retries == nil {
+			class.Retries = parent.Retries
+		}
+		if class.RetryBackoff == "" {
+			class.RetryBackoff = parent.RetryBackoff
+		}
+		if class.ExpectTimeout == "" {
+			class.ExpectTimeout = parent.ExpectTimeout
+		}
+		if class.Pager == nil {
+			class.Pager = parent.Pager
+		}
+		if class.Libraries == nil {
+			class.Libraries = parent.Libraries
+		}
+		class.Vars = MergeVars(parent.Vars, class.Vars)
+
+		resolved[name] = true
+		return nil
+	}
+
+	// Resolve in name order so the error reported for a broken config is deterministic
+	names := make([]string, 0, len(deviceClassCfgs))
+	for name := range deviceClassCfgs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errorAccum *multierror.Error
+	for _, name := range names {
+		if err := resolve(name, nil); err != nil {
+			errorAccum = multierror.Append(errorAccum, err)
+		}
+	}
+
+	if errorAccum.ErrorOrNil() != nil {
+		return errors.Wrap(errorAccum, 0)
+	}
+
 	return nil
 }
 
-func loadBackupTargetConfigHcl(list *ast.ObjectList) (map[string]*BackupTargetConfig, error) {
+func loadPagerConfigHcl(className string, list *ast.ObjectList) (*PagerConfig, error) {
+	if len(list.Items) == 0 {
+		return nil, nil
+	}
+	item := list.Items[0]
+	if len(list.Items) > 1 {
+		return nil, utilities.ErrorAt(list.Items[1].Pos(), "device_class '%s': only one pager block may be specified", className)
+	}
+
+	var parsed map[string]interface{}
+	if err := hcl.DecodeObject(&parsed, item.Val); err != nil {
+		return nil, utilities.ErrorAt(item.Pos(), "device_class '%s': pager: %s", className, err)
+	}
+
+	var result PagerConfig
+	var metadata mapstructure.Metadata
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		Metadata:    &metadata,
+		Result:      &result,
+		ErrorUnused: true,
+	})
+	if err != nil {
+		return nil, errors.New("Failed constructing Decoder")
+	}
+	if err := decoder.Decode(parsed); err != nil {
+		return nil, utilities.ErrorAt(item.Pos(), "device_class '%s': pager: %s", className, err)
+	}
+
+	if result.DisableCommand == "" {
+		if err := utilities.CheckForRequiredFields(&metadata, []string{"pattern"}); err != nil {
+			return nil, utilities.ErrorAt(item.Pos(), "device_class '%s': pager: %s", className, err)
+		}
+	}
+	if result.Regex {
+		if _, err := regexp.Compile(result.Pattern); err != nil {
+			return nil, utilities.ErrorAt(item.Pos(), "device_class '%s': pager: Invalid regular expression: %s", className, err)
+		}
+	}
+	if result.Send == "" {
+		result.Send = DefaultPagerSend
+	}
+
+	return &result, nil
+}
+
+// loadBackupTargetConfigHcl loads a device_class's backup_target blocks. The targets without errors are returned even
+// if others have errors.
+func loadBackupTargetConfigHcl(className string, list *ast.ObjectList) (map[string]*BackupTargetConfig, error) {
 	list = list.Children()
 	if len(list.Items) == 0 {
 		return nil, nil
@@ -71,9 +350,13 @@ func loadBackupTargetConfigHcl(list *ast.ObjectList) (map[string]*BackupTargetCo
 		// Decode the parse tree into an object map
 		var parsed map[string]interface{}
 		if err := hcl.DecodeObject(&parsed, item.Val); err != nil {
-			return nil, err
+			errorAccum = multierror.Append(errorAccum, utilities.ErrorAt(item.Pos(), "device_class '%s': backup_target '%s': %s", className, name, err))
+			continue
 		}
 
+		// Errors found in this target, it's only added to the results if there are none
+		var targetErrs *multierror.Error
+
 		decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
 			Metadata: &metadata,
 			Result:   &result,
@@ -84,11 +367,36 @@ func loadBackupTargetConfigHcl(list *ast.ObjectList) (map[string]*BackupTargetCo
 
 		// Decode the object map into our structure
 		if err := decoder.Decode(parsed); err != nil {
-			errorAccum = multierror.Append(errorAccum, errors.Errorf("backup_target '%s': %s", name, err))
+			targetErrs = multierror.Append(targetErrs, utilities.ErrorAt(item.Pos(), "device_class '%s': backup_target '%s': %s", className, name, err))
+		}
+
+		if result.Fetch != "" {
+			// Fetched configs are downloaded directly, so there is no macro to run
+			if _, _, err := ParseFetchStr(result.Fetch); err != nil {
+				targetErrs = multierror.Append(targetErrs, utilities.ErrorAt(item.Pos(), "device_class '%s': backup_target '%s': %s", className, name, err))
+			}
+			if result.Macro != "" || result.Mode != "" || result.ExpectTimeout != "" {
+				targetErrs = multierror.Append(targetErrs, utilities.ErrorAt(item.Pos(), "device_class '%s': backup_target '%s': fetch can't be combined with macro, mode or expect_timeout", className, name))
+			}
+		} else if err = utilities.CheckForRequiredFields(&metadata, []string{"macro"}); err != nil {
+			targetErrs = multierror.Append(targetErrs, utilities.ErrorAt(item.Pos(), "device_class '%s': backup_target '%s': %s", className, name, err))
+		} else if pos, err := checkScript(result.Macro, attributeValue(item, "macro"), item.Pos()); err != nil {
+			targetErrs = multierror.Append(targetErrs, utilities.ErrorAt(pos, "device_class '%s': backup_target '%s': Invalid macro: %s", className, name, err))
+		}
+
+		if err := validateExpectTimeout(result.ExpectTimeout); err != nil {
+			targetErrs = multierror.Append(targetErrs, utilities.ErrorAt(item.Pos(), "device_class '%s': backup_target '%s': %s", className, name, err))
+		}
+
+		switch result.Mode {
+		case "", BackupModeTFTP, BackupModeCapture:
+		default:
+			targetErrs = multierror.Append(targetErrs, utilities.ErrorAt(item.Pos(), "device_class '%s': backup_target '%s': Unsupported mode '%s'", className, name, result.Mode))
 		}
 
-		if err = utilities.CheckForRequiredFields(&metadata, []string{"macro"}); err != nil {
-			errorAccum = multierror.Append(errorAccum, errors.Errorf("backup_target '%s': %s", name, err))
+		if targetErrs != nil {
+			errorAccum = multierror.Append(errorAccum, targetErrs.Errors...)
+			continue
 		}
 
 		// Append the result
@@ -96,7 +404,7 @@ func loadBackupTargetConfigHcl(list *ast.ObjectList) (map[string]*BackupTargetCo
 	}
 
 	if errorAccum.ErrorOrNil() != nil {
-		return nil, errors.Wrap(errorAccum, 0)
+		return results, errors.Wrap(errorAccum, 0)
 	}
 
 	return results, nil
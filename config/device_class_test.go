@@ -46,3 +46,359 @@ device_class "D_CLASS_B" {
 	}
 	require.Equal(t, expected, results)
 }
+
+func TestDeviceClass_Mode(t *testing.T) {
+
+	config_str := `
+device_class "D_CLASS_A" {
+	backup_target "TARGET_1" {
+		macro = "MACRO_PLACEHOLDER_1"
+		mode = "capture"
+	}
+}
+	`
+	c, err := utilities.LoadStringHcl(config_str)
+	require.NoError(t, err)
+
+	list, ok := utilities.GetObjectList(c)
+	require.True(t, ok)
+
+	results := map[string]*DeviceClassConfig{}
+
+	err = loadDeviceClassConfigsHcl(list.Filter("device_class"), &results)
+	require.NoError(t, err)
+
+	expected := map[string]*DeviceClassConfig{
+		"D_CLASS_A": {BackupTargets: map[string]*BackupTargetConfig{
+			"TARGET_1": {Macro: "MACRO_PLACEHOLDER_1", Mode: BackupModeCapture},
+		}},
+	}
+	require.Equal(t, expected, results)
+
+	c, err = utilities.LoadStringHcl(`device_class "D" { backup_target "T" { macro = "M" mode = "carrier_pigeon" } }`)
+	require.NoError(t, err)
+
+	list, ok = utilities.GetObjectList(c)
+	require.True(t, ok)
+
+	err = loadDeviceClassConfigsHcl(list.Filter("device_class"), &map[string]*DeviceClassConfig{})
+	require.Error(t, err)
+}
+
+func TestDeviceClass_Fetch(t *testing.T) {
+
+	config_str := `
+device_class "edgerouter" {
+	backup_target "config" {
+		fetch = "sftp:/config/config.boot"
+	}
+}
+	`
+	c, err := utilities.LoadStringHcl(config_str)
+	require.NoError(t, err)
+
+	list, ok := utilities.GetObjectList(c)
+	require.True(t, ok)
+
+	results := map[string]*DeviceClassConfig{}
+
+	err = loadDeviceClassConfigsHcl(list.Filter("device_class"), &results)
+	require.NoError(t, err)
+
+	expected := map[string]*DeviceClassConfig{
+		"edgerouter": {BackupTargets: map[string]*BackupTargetConfig{
+			"config": {Fetch: "sftp:/config/config.boot"},
+		}},
+	}
+	require.Equal(t, expected, results)
+
+	for _, invalid := range []string{
+		`device_class "D" { backup_target "T" { fetch = "ftp:/config.boot" } }`,
+		`device_class "D" { backup_target "T" { fetch = "/config.boot" } }`,
+		`device_class "D" { backup_target "T" { fetch = "scp:/config.boot" macro = "M" } }`,
+	} {
+		c, err = utilities.LoadStringHcl(invalid)
+		require.NoError(t, err)
+
+		list, ok = utilities.GetObjectList(c)
+		require.True(t, ok)
+
+		err = loadDeviceClassConfigsHcl(list.Filter("device_class"), &map[string]*DeviceClassConfig{})
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestParseFetchStr(t *testing.T) {
+	protocol, path, err := ParseFetchStr("scp:/config/config.boot")
+	require.NoError(t, err)
+	require.Equal(t, FetchSCP, protocol)
+	require.Equal(t, "/config/config.boot", path)
+
+	_, _, err = ParseFetchStr("sftp:")
+	require.Error(t, err)
+}
+
+func TestDeviceClass_Retries(t *testing.T) {
+
+	config_str := `
+device_class "D_CLASS_A" {
+	retries = 2
+	retry_backoff = "1m"
+
+	backup_target "TARGET_1" {
+		macro = "MACRO_PLACEHOLDER_1"
+	}
+}
+	`
+	c, err := utilities.LoadStringHcl(config_str)
+	require.NoError(t, err)
+
+	list, ok := utilities.GetObjectList(c)
+	require.True(t, ok)
+
+	results := map[string]*DeviceClassConfig{}
+
+	err = loadDeviceClassConfigsHcl(list.Filter("device_class"), &results)
+	require.NoError(t, err)
+
+	retries := 2
+	expected := map[string]*DeviceClassConfig{
+		"D_CLASS_A": {
+			BackupTargets: map[string]*BackupTargetConfig{
+				"TARGET_1": {Macro: "MACRO_PLACEHOLDER_1"},
+			},
+			Retries:      &retries,
+			RetryBackoff: "1m",
+		},
+	}
+	require.Equal(t, expected, results)
+
+	for _, invalid := range []string{
+		`device_class "D" { retries = -1 backup_target "T" { macro = "M" } }`,
+		`device_class "D" { retry_backoff = "soon" backup_target "T" { macro = "M" } }`,
+		`device_class "D" { retrys = 2 backup_target "T" { macro = "M" } }`,
+	} {
+		c, err = utilities.LoadStringHcl(invalid)
+		require.NoError(t, err)
+
+		list, ok = utilities.GetObjectList(c)
+		require.True(t, ok)
+
+		err = loadDeviceClassConfigsHcl(list.Filter("device_class"), &map[string]*DeviceClassConfig{})
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestDeviceClass_ExpectTimeout(t *testing.T) {
+
+	config_str := `
+device_class "D_CLASS_A" {
+	expect_timeout = "30s"
+
+	backup_target "TARGET_1" {
+		macro = "MACRO_PLACEHOLDER_1"
+		expect_timeout = "2m"
+	}
+}
+	`
+	c, err := utilities.LoadStringHcl(config_str)
+	require.NoError(t, err)
+
+	list, ok := utilities.GetObjectList(c)
+	require.True(t, ok)
+
+	results := map[string]*DeviceClassConfig{}
+
+	err = loadDeviceClassConfigsHcl(list.Filter("device_class"), &results)
+	require.NoError(t, err)
+
+	expected := map[string]*DeviceClassConfig{
+		"D_CLASS_A": {
+			BackupTargets: map[string]*BackupTargetConfig{
+				"TARGET_1": {Macro: "MACRO_PLACEHOLDER_1", ExpectTimeout: "2m"},
+			},
+			ExpectTimeout: "30s",
+		},
+	}
+	require.Equal(t, expected, results)
+
+	for _, invalid := range []string{
+		`device_class "D" { expect_timeout = "0s" backup_target "T" { macro = "M" } }`,
+		`device_class "D" { backup_target "T" { macro = "M" expect_timeout = "30" } }`,
+		`device_class "D" { backup_target "T" { fetch = "sftp:/config.boot" expect_timeout = "30s" } }`,
+	} {
+		c, err = utilities.LoadStringHcl(invalid)
+		require.NoError(t, err)
+
+		list, ok = utilities.GetObjectList(c)
+		require.True(t, ok)
+
+		err = loadDeviceClassConfigsHcl(list.Filter("device_class"), &map[string]*DeviceClassConfig{})
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestDeviceClass_Pager(t *testing.T) {
+
+	config_str := `
+device_class "hp_switch" {
+	pager {
+		pattern = "-- MORE --"
+	}
+
+	backup_target "TARGET_1" {
+		macro = "MACRO_PLACEHOLDER_1"
+	}
+}
+
+device_class "cisco_ios" {
+	pager {
+		pattern = "\\s*--More--\\s*"
+		regex = true
+		send = "\r"
+		disable_command = "terminal length 0"
+	}
+
+	backup_target "TARGET_1" {
+		macro = "MACRO_PLACEHOLDER_1"
+	}
+}
+
+device_class "juniper" {
+	pager {
+		disable_command = "set cli screen-length 0"
+	}
+
+	backup_target "TARGET_1" {
+		macro = "MACRO_PLACEHOLDER_1"
+	}
+}
+	`
+	c, err := utilities.LoadStringHcl(config_str)
+	require.NoError(t, err)
+
+	list, ok := utilities.GetObjectList(c)
+	require.True(t, ok)
+
+	results := map[string]*DeviceClassConfig{}
+
+	err = loadDeviceClassConfigsHcl(list.Filter("device_class"), &results)
+	require.NoError(t, err)
+
+	require.Equal(t, &PagerConfig{Pattern: "-- MORE --", Send: DefaultPagerSend}, results["hp_switch"].Pager)
+	require.Equal(t, &PagerConfig{Pattern: `\s*--More--\s*`, Regex: true, Send: "\r", DisableCommand: "terminal length 0"}, results["cisco_ios"].Pager)
+	require.Equal(t, &PagerConfig{Send: DefaultPagerSend, DisableCommand: "set cli screen-length 0"}, results["juniper"].Pager)
+
+	for _, invalid := range []string{
+		`device_class "D" { pager { send = " " } backup_target "T" { macro = "M" } }`,
+		`device_class "D" { pager { pattern = "(" regex = true } backup_target "T" { macro = "M" } }`,
+		`device_class "D" { pager { pattern = "--More--" keys = " " } backup_target "T" { macro = "M" } }`,
+		`device_class "D" { pager { pattern = "a" } pager { pattern = "b" } backup_target "T" { macro = "M" } }`,
+	} {
+		c, err = utilities.LoadStringHcl(invalid)
+		require.NoError(t, err)
+
+		list, ok = utilities.GetObjectList(c)
+		require.True(t, ok)
+
+		loaded := map[string]*DeviceClassConfig{}
+		err = loadDeviceClassConfigsHcl(list.Filter("device_class"), &loaded)
+		require.Error(t, err, invalid)
+
+		// The class is still added, without a pager, so devices using it don't report it missing
+		require.Contains(t, loaded, "D", invalid)
+		require.Nil(t, loaded["D"].Pager, invalid)
+	}
+}
+
+func TestDeviceClass_Extends(t *testing.T) {
+	cfg, err := LoadString(`
+device_class "cisco_2901" {
+	extends = "cisco_isr"
+	vars {
+		prompt = "router#"
+		retries = 2
+	}
+	backup_target "running_config" {
+		macro = "OVERRIDDEN"
+	}
+}
+
+device_class "cisco_isr" {
+	extends = "cisco_base"
+	expect_timeout = "30s"
+	backup_target "startup_config" {
+		macro = "STARTUP"
+	}
+	backup_target "running_config" {
+		macro = "RUNNING"
+	}
+}
+
+device_class "cisco_base" {
+	retries = 3
+	expect_timeout = "10s"
+	libraries = ["cisco_cli"]
+	vars {
+		prompt = "#"
+		copy_command = "copy"
+	}
+}
+	`)
+	require.NoError(t, err)
+
+	retries := 3
+	expected := &DeviceClassConfig{
+		Extends: "cisco_isr",
+		BackupTargets: map[string]*BackupTargetConfig{
+			"startup_config": {Macro: "STARTUP"},
+			"running_config": {Macro: "OVERRIDDEN"},
+		},
+		Retries:       &retries,
+		ExpectTimeout: "30s",
+		Libraries:     []string{"cisco_cli"},
+		Vars:          map[string]string{"prompt": "router#", "copy_command": "copy", "retries": "2"},
+	}
+	require.Equal(t, expected, cfg.DeviceClasses["cisco_2901"])
+
+	// The parent classes are unchanged by their children
+	require.Equal(t, "RUNNING", cfg.DeviceClasses["cisco_isr"].BackupTargets["running_config"].Macro)
+	require.Equal(t, "#", cfg.DeviceClasses["cisco_isr"].Vars["prompt"])
+}
+
+func TestDeviceClass_ExtendsInvalid(t *testing.T) {
+	_, err := LoadString(`device_class "a" { extends = "missing" }`)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "device_class 'a': extends device_class 'missing', which doesn't exist")
+
+	_, err = LoadString(`
+device_class "a" { extends = "b" }
+device_class "b" { extends = "c" }
+device_class "c" { extends = "a" }
+	`)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "extends cycle a -> b -> c -> a")
+}
+
+func TestDeviceClassConfig_MacroErrorPositions(t *testing.T) {
+	buf := `
+device_class "cisco_ios" {
+    backup_target "running_config" {
+        macro = <<-MACRO
+            expect("#")
+            sendLine("show running-config"
+            expect("#")
+        MACRO
+    }
+    backup_target "startup_config" {
+        macro = "sendLine('show startup-config'"
+    }
+}
+`
+	_, err := LoadString(buf)
+	require.Error(t, err)
+
+	// Errors in heredocs are reported at their line in the config, with the heredoc's indentation added to the column
+	require.Regexp(t, `7:13: device_class 'cisco_ios': backup_target 'running_config': Invalid macro: `, err.Error())
+	require.Regexp(t, `11:\d+: device_class 'cisco_ios': backup_target 'startup_config': Invalid macro: `, err.Error())
+}
@@ -2,12 +2,21 @@ package config
 
 import (
 	"github.com/go-errors/errors"
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/hcl"
 	"github.com/hashicorp/hcl/hcl/ast"
+	"github.com/mitchellh/mapstructure"
 	"github.com/samhug/ndm/config/auth_providers"
+	"github.com/samhug/ndm/config/utilities"
 )
 
 type DeviceGroupConfig struct {
 	Devices map[string]*DeviceConfig
+	// MaxParallel limits how many of the group's devices are processed concurrently, zero means no limit
+	MaxParallel int
+	// Vars and Tags are inherited by the group's devices
+	Vars map[string]string
+	Tags []string
 }
 
 func loadDeviceGroupConfigsHcl(list *ast.ObjectList, deviceGroupCfgs *map[string]*DeviceGroupConfig, deviceClassCfgs *map[string]*DeviceClassConfig, authProviderCfgs *map[string]auth_providers.AuthProviderConfig) error {
@@ -16,6 +25,8 @@ func loadDeviceGroupConfigsHcl(list *ast.ObjectList, deviceGroupCfgs *map[string
 		return nil
 	}
 
+	var errorAccum *multierror.Error
+
 	for _, item := range list.Items {
 		name := item.Keys[0].Token.Value().(string)
 
@@ -23,24 +34,76 @@ func loadDeviceGroupConfigsHcl(list *ast.ObjectList, deviceGroupCfgs *map[string
 		if ot, ok := item.Val.(*ast.ObjectType); ok {
 			listVal = ot.List
 		} else {
-			return errors.Errorf("device_group '%s': device should be an object", name)
+			errorAccum = multierror.Append(errorAccum, utilities.ErrorAt(item.Pos(), "device_group '%s': device should be an object", name))
+			continue
+		}
+
+		type hclDeviceGroup struct {
+			MaxParallel int      `mapstructure:"max_parallel,"`
+			Tags        []string `mapstructure:"tags,"`
+		}
+
+		// Decode the group's own attributes, the device blocks are handled below
+		var parsed map[string]interface{}
+		if err := hcl.DecodeObject(&parsed, item.Val); err != nil {
+			errorAccum = multierror.Append(errorAccum, utilities.ErrorAt(item.Pos(), "device_group '%s': %s", name, err))
+			continue
+		}
+		vars, err := decodeVars(parsed["vars"])
+		if err != nil {
+			errorAccum = multierror.Append(errorAccum, utilities.ErrorAt(item.Pos(), "device_group '%s': %s", name, err))
+			continue
+		}
+		delete(parsed, "device")
+		delete(parsed, "vars")
+
+		var rawResult hclDeviceGroup
+		decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+			Result:      &rawResult,
+			ErrorUnused: true,
+		})
+		if err != nil {
+			return errors.New("Failed constructing Decoder")
+		}
+		if err := decoder.Decode(parsed); err != nil {
+			errorAccum = multierror.Append(errorAccum, utilities.ErrorAt(item.Pos(), "device_group '%s': %s", name, err))
+			continue
+		}
+		if rawResult.MaxParallel < 0 {
+			errorAccum = multierror.Append(errorAccum, utilities.ErrorAt(item.Pos(), "device_group '%s': max_parallel can't be negative", name))
+			continue
+		}
+		if err := validateTags(rawResult.Tags); err != nil {
+			errorAccum = multierror.Append(errorAccum, utilities.ErrorAt(item.Pos(), "device_group '%s': %s", name, err))
+			continue
 		}
 
 		childDeviceCfgs := make(map[string]*DeviceConfig)
 
-		err := loadDeviceConfigsHcl(listVal.Filter("device"), &childDeviceCfgs, deviceClassCfgs, authProviderCfgs)
+		// Devices with errors are still added, so the group is too
+		err = loadDeviceConfigsHcl(listVal.Filter("device"), &childDeviceCfgs, deviceClassCfgs, authProviderCfgs)
 		if err != nil {
-			return errors.Errorf("device_group '%s': %s", name, err)
+			errorAccum = multierror.Append(errorAccum, utilities.WrapAt(item.Pos(), err, "device_group '%s'", name))
+		}
+		device_group := &DeviceGroupConfig{
+			Devices:     childDeviceCfgs,
+			MaxParallel: rawResult.MaxParallel,
+			Vars:        vars,
+			Tags:        rawResult.Tags,
 		}
-		device_group := &DeviceGroupConfig{Devices: childDeviceCfgs}
 
 		if _, ok := (*deviceGroupCfgs)[name]; ok {
-			return errors.Errorf("device_group '%s': device_group already exists with that name", name)
+			errorAccum = multierror.Append(errorAccum, utilities.ErrorAt(item.Pos(), "device_group '%s': device_group already exists with that name", name))
+			continue
 		}
 
 		// Append the result
 		(*deviceGroupCfgs)[name] = device_group
 	}
 
+	if errorAccum.ErrorOrNil() != nil {
+		return errors.Wrap(errorAccum, 0)
+	}
+
 	return nil
 }
@@ -0,0 +1,112 @@
+package config
+
+import (
+	"github.com/samhug/ndm/config/auth_providers"
+	"github.com/samhug/ndm/config/utilities"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestDeviceGroupConfig_Basic(t *testing.T) {
+
+	config_str := `
+device_group "site-a" {
+	max_parallel = 2
+
+	device "deviceClassA" "deviceA" {
+		address = "10.10.10.10:22"
+		auth = "providerA:auth1"
+	}
+}
+
+device_group "site-b" {
+	device "deviceClassA" "deviceB" {
+		address = "10.10.20.10:22"
+		auth = "providerA:auth1"
+	}
+}
+	`
+	c, err := utilities.LoadStringHcl(config_str)
+	require.NoError(t, err)
+
+	list, ok := utilities.GetObjectList(c)
+	require.True(t, ok)
+
+	providers := map[string]auth_providers.AuthProviderConfig{
+		"providerA": &auth_providers.StaticAuthProviderConfig{},
+	}
+	deviceClasses := map[string]*DeviceClassConfig{
+		"deviceClassA": {},
+	}
+
+	results := map[string]*DeviceGroupConfig{}
+
+	err = loadDeviceGroupConfigsHcl(list.Filter("device_group"), &results, &deviceClasses, &providers)
+	require.NoError(t, err)
+
+	expected := map[string]*DeviceGroupConfig{
+		"site-a": {MaxParallel: 2, Devices: map[string]*DeviceConfig{
+			"deviceA": {Name: "deviceA", ClassName: "deviceClassA", AuthProvider: "providerA", AuthPath: "auth1", Address: "10.10.10.10:22"},
+		}},
+		"site-b": {Devices: map[string]*DeviceConfig{
+			"deviceB": {Name: "deviceB", ClassName: "deviceClassA", AuthProvider: "providerA", AuthPath: "auth1", Address: "10.10.20.10:22"},
+		}},
+	}
+	require.Equal(t, expected, results)
+
+	for _, invalid := range []string{
+		`device_group "g" { max_parallel = -1 }`,
+		`device_group "g" { max_paralel = 2 }`,
+	} {
+		c, err = utilities.LoadStringHcl(invalid)
+		require.NoError(t, err)
+
+		list, ok = utilities.GetObjectList(c)
+		require.True(t, ok)
+
+		err = loadDeviceGroupConfigsHcl(list.Filter("device_group"), &map[string]*DeviceGroupConfig{}, &deviceClasses, &providers)
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestDeviceGroupConfig_VarsAndTags(t *testing.T) {
+	c, err := utilities.LoadStringHcl(`
+device_group "site-a" {
+	tags = ["datacenter"]
+	vars {
+		vrf = "mgmt"
+	}
+
+	device "deviceClassA" "deviceA" {
+		address = "10.10.10.10:22"
+		auth = "providerA:auth1"
+		tags = ["core", "cisco"]
+	}
+}
+	`)
+	require.NoError(t, err)
+
+	list, ok := utilities.GetObjectList(c)
+	require.True(t, ok)
+
+	providers := map[string]auth_providers.AuthProviderConfig{
+		"providerA": &auth_providers.StaticAuthProviderConfig{},
+	}
+	deviceClasses := map[string]*DeviceClassConfig{"deviceClassA": {}}
+
+	results := map[string]*DeviceGroupConfig{}
+	require.NoError(t, loadDeviceGroupConfigsHcl(list.Filter("device_group"), &results, &deviceClasses, &providers))
+
+	group := results["site-a"]
+	require.Equal(t, []string{"datacenter"}, group.Tags)
+	require.Equal(t, map[string]string{"vrf": "mgmt"}, group.Vars)
+	require.Equal(t, []string{"core", "cisco"}, group.Devices["deviceA"].Tags)
+
+	// Tags are restricted so they can be used in device selections
+	c, err = utilities.LoadStringHcl(`device_group "site-a" { tags = ["two words"] }`)
+	require.NoError(t, err)
+	list, _ = utilities.GetObjectList(c)
+	err = loadDeviceGroupConfigsHcl(list.Filter("device_group"), &map[string]*DeviceGroupConfig{}, &deviceClasses, &providers)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "Invalid tag 'two words'")
+}
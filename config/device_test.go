@@ -52,3 +52,108 @@ device "deviceClassA" "deviceA" {
 	}
 	require.Equal(t, expected, results)
 }
+
+func TestDeviceConfig_HostKey(t *testing.T) {
+
+	config_str := `
+device "deviceClassA" "deviceA" {
+	address = "10.10.10.10:22"
+	auth = "providerA:auth1"
+	host_key = "SHA256:nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8"
+}
+	`
+	c, err := utilities.LoadStringHcl(config_str)
+	require.NoError(t, err)
+
+	list, ok := utilities.GetObjectList(c)
+	require.True(t, ok)
+
+	providers := map[string]auth_providers.AuthProviderConfig{
+		"providerA": &auth_providers.StaticAuthProviderConfig{},
+	}
+	deviceClasses := map[string]*DeviceClassConfig{
+		"deviceClassA": {},
+	}
+
+	results := map[string]*DeviceConfig{}
+
+	err = loadDeviceConfigsHcl(list.Filter("device"), &results, &deviceClasses, &providers)
+	require.NoError(t, err)
+
+	expected := map[string]*DeviceConfig{
+		"deviceA": {Name: "deviceA", ClassName: "deviceClassA", AuthProvider: "providerA", AuthPath: "auth1", Address: "10.10.10.10:22",
+			HostKey: "SHA256:nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8"},
+	}
+	require.Equal(t, expected, results)
+}
+
+func TestDeviceConfig_Retries(t *testing.T) {
+
+	config_str := `
+device "deviceClassA" "deviceA" {
+	address = "10.10.10.10:22"
+	auth = "providerA:auth1"
+	retries = 0
+	retry_backoff = "30s"
+}
+	`
+	c, err := utilities.LoadStringHcl(config_str)
+	require.NoError(t, err)
+
+	list, ok := utilities.GetObjectList(c)
+	require.True(t, ok)
+
+	providers := map[string]auth_providers.AuthProviderConfig{
+		"providerA": &auth_providers.StaticAuthProviderConfig{},
+	}
+	deviceClasses := map[string]*DeviceClassConfig{
+		"deviceClassA": {},
+	}
+
+	results := map[string]*DeviceConfig{}
+
+	err = loadDeviceConfigsHcl(list.Filter("device"), &results, &deviceClasses, &providers)
+	require.NoError(t, err)
+
+	// An explicit zero is kept so it can override the device_class
+	retries := 0
+	expected := map[string]*DeviceConfig{
+		"deviceA": {Name: "deviceA", ClassName: "deviceClassA", AuthProvider: "providerA", AuthPath: "auth1", Address: "10.10.10.10:22",
+			Retries: &retries, RetryBackoff: "30s"},
+	}
+	require.Equal(t, expected, results)
+
+	c, err = utilities.LoadStringHcl(`device "deviceClassA" "deviceA" { address = "a" auth = "providerA:auth1" retry_backoff = "-5s" }`)
+	require.NoError(t, err)
+
+	list, ok = utilities.GetObjectList(c)
+	require.True(t, ok)
+
+	err = loadDeviceConfigsHcl(list.Filter("device"), &map[string]*DeviceConfig{}, &deviceClasses, &providers)
+	require.Error(t, err)
+}
+
+func TestDeviceConfig_Vars(t *testing.T) {
+	c, err := utilities.LoadStringHcl(`
+device "deviceClassA" "deviceA" {
+	address = "10.10.10.10:22"
+	auth = "providerA:auth1"
+	vars {
+		prompt = "core-sw-01#"
+	}
+}
+	`)
+	require.NoError(t, err)
+
+	list, ok := utilities.GetObjectList(c)
+	require.True(t, ok)
+
+	providers := map[string]auth_providers.AuthProviderConfig{
+		"providerA": &auth_providers.StaticAuthProviderConfig{},
+	}
+	deviceClasses := map[string]*DeviceClassConfig{"deviceClassA": {}}
+
+	results := map[string]*DeviceConfig{}
+	require.NoError(t, loadDeviceConfigsHcl(list.Filter("device"), &results, &deviceClasses, &providers))
+	require.Equal(t, map[string]string{"prompt": "core-sw-01#"}, results["deviceA"].Vars)
+}
@@ -0,0 +1,171 @@
+package config
+
+import (
+	"github.com/go-errors/errors"
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/hcl/hcl/ast"
+	"github.com/samhug/ndm/config/utilities"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// includeDirPattern selects the files loaded when an include block names a directory
+const includeDirPattern = "*.hcl"
+
+// includeSet tracks the files loaded while reading a configuration, so each file is only loaded once however many
+// times it's included, and files that include each other are detected
+type includeSet struct {
+	loaded map[string]bool
+	// stack holds the paths of the files currently being loaded, outermost first
+	stack []string
+}
+
+func newIncludeSet() *includeSet {
+	return &includeSet{loaded: make(map[string]bool)}
+}
+
+// cycle returns the chain of includes that leads back to filePath if it's already being loaded, nil otherwise
+func (s *includeSet) cycle(filePath string) []string {
+	key := includeKey(filePath)
+	for i, loading := range s.stack {
+		if includeKey(loading) == key {
+			return append(append([]string{}, s.stack[i:]...), filePath)
+		}
+	}
+	return nil
+}
+
+// including reports whether filePath is the file currently being loaded, the one whose include blocks are being read
+func (s *includeSet) including(filePath string) bool {
+	return len(s.stack) > 0 && includeKey(s.stack[len(s.stack)-1]) == includeKey(filePath)
+}
+
+// enter records that filePath is being loaded
+func (s *includeSet) enter(filePath string) {
+	s.loaded[includeKey(filePath)] = true
+	s.stack = append(s.stack, filePath)
+}
+
+// leave records that the most recently entered file has finished loading
+func (s *includeSet) leave() {
+	s.stack = s.stack[:len(s.stack)-1]
+}
+
+// includeKey identifies a file regardless of the path it was included by
+func includeKey(filePath string) string {
+	if abs, err := filepath.Abs(filePath); err == nil {
+		return abs
+	}
+	return filepath.Clean(filePath)
+}
+
+// loadIncludes loads the files named by include blocks into cfg. Paths are relative to dir, the directory of the file
+// containing the blocks, and may be globs or directories. Files that have already been loaded, and globs or directories
+// matching the file containing the blocks, are skipped. An include that fails to load doesn't stop the others loading,
+// the errors are all returned together.
+func loadIncludes(list *ast.ObjectList, cfg *Config, dir string, includes *includeSet) error {
+	list = list.Children()
+	if len(list.Items) == 0 {
+		return nil
+	}
+
+	var errorAccum *multierror.Error
+
+	for _, item := range list.Items {
+		name := item.Keys[0].Token.Value().(string)
+
+		filePaths, matched, err := resolveInclude(dir, name)
+		if err != nil {
+			errorAccum = multierror.Append(errorAccum, utilities.ErrorAt(item.Pos(), "include '%s': %s", name, err))
+			continue
+		}
+
+		for _, filePath := range filePaths {
+			if chain := includes.cycle(filePath); chain != nil {
+				// A glob or directory may match the file that includes it, which is skipped like any loaded file.
+				// Matching a file further up the chain is still a cycle.
+				if matched && includes.including(filePath) {
+					continue
+				}
+				errorAccum = multierror.Append(errorAccum, utilities.ErrorAt(item.Pos(), "include '%s': include cycle %s", name, strings.Join(chain, " -> ")))
+				continue
+			}
+			if includes.loaded[includeKey(filePath)] {
+				continue
+			}
+
+			f, err := utilities.LoadFileHcl(filePath)
+			if err != nil {
+				errorAccum = multierror.Append(errorAccum, utilities.WrapAt(item.Pos(), err, "include '%s'", name))
+				continue
+			}
+
+			// Top-level item should be the object list
+			fileList, ok := f.Node.(*ast.ObjectList)
+			if !ok {
+				errorAccum = multierror.Append(errorAccum, utilities.ErrorAt(item.Pos(), "include '%s': error parsing %s: config doesn't contain a root object", name, filePath))
+				continue
+			}
+
+			includes.enter(filePath)
+			err = loadConfigHcl(fileList, cfg, filepath.Dir(filePath), includes)
+			includes.leave()
+			if err != nil {
+				errorAccum = multierror.Append(errorAccum, utilities.WrapAt(item.Pos(), err, "include '%s'", name))
+			}
+		}
+	}
+
+	return errorAccum.ErrorOrNil()
+}
+
+// resolveInclude returns the files an include block names, in order. name is resolved relative to dir, and may be a
+// file, a glob, or a directory whose *.hcl files are included. matched reports whether the files were matched by a
+// glob or directory rather than named directly.
+func resolveInclude(dir string, name string) (filePaths []string, matched bool, err error) {
+	pattern := name
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(dir, pattern)
+	}
+
+	if info, err := os.Stat(pattern); err == nil && info.IsDir() {
+		filePaths, err := globFiles(filepath.Join(pattern, includeDirPattern))
+		if err != nil {
+			return nil, false, err
+		}
+		if len(filePaths) == 0 {
+			return nil, false, errors.Errorf("directory '%s' doesn't contain any %s files", pattern, includeDirPattern)
+		}
+		return filePaths, true, nil
+	}
+
+	if !strings.ContainsAny(name, `*?[`) {
+		return []string{pattern}, false, nil
+	}
+
+	filePaths, err = globFiles(pattern)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(filePaths) == 0 {
+		return nil, false, errors.Errorf("no files match '%s'", pattern)
+	}
+	return filePaths, true, nil
+}
+
+// globFiles returns the files, not directories, matching pattern in lexical order
+func globFiles(pattern string) ([]string, error) {
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, errors.Errorf("Invalid pattern '%s': %s", pattern, err)
+	}
+
+	filePaths := make([]string, 0, len(matches))
+	for _, match := range matches {
+		if info, err := os.Stat(match); err == nil && !info.IsDir() {
+			filePaths = append(filePaths, match)
+		}
+	}
+	return filePaths, nil
+}
@@ -0,0 +1,63 @@
+package config
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestInclude_GlobsAndDirectories(t *testing.T) {
+	cfg, err := LoadFile("test_data/includes/main.hcl")
+	require.NoError(t, err)
+
+	require.Contains(t, cfg.DeviceClasses, "cisco")
+	require.Contains(t, cfg.DeviceGroups["site-a"].Devices, "router")
+	require.Contains(t, cfg.DeviceGroups["site-b"].Devices, "router")
+
+	// shared/common.hcl is included three times but only loaded once, and the library file it names is resolved
+	// relative to it
+	require.Contains(t, cfg.AuthProviders, "basic")
+	require.Contains(t, cfg.MacroLibraries["common"].Source, "function enable()")
+	require.Equal(t, "test_data/includes/shared/common.hcl", cfg.Positions.MacroLibraries["common"].Filename)
+	require.Equal(t, "test_data/includes/sites/site-b.hcl", cfg.Positions.Devices["site-b/router"].Filename)
+}
+
+func TestInclude_Self(t *testing.T) {
+	// Globs and directories that match the including file skip it, main.hcl includes itself and other.hcl, which
+	// includes itself
+	cfg, err := LoadFile("test_data/includes/self/main.hcl")
+	require.NoError(t, err)
+	require.Contains(t, cfg.DeviceClasses, "other")
+}
+
+func TestInclude_Cycle(t *testing.T) {
+	_, err := LoadFile("test_data/includes/cycle/a.hcl")
+	require.Error(t, err)
+	require.Contains(t, err.Error(),
+		"include cycle test_data/includes/cycle/a.hcl -> test_data/includes/cycle/b.hcl -> test_data/includes/cycle/a.hcl")
+}
+
+func TestInclude_GlobCycle(t *testing.T) {
+	// A glob matching a file further up the chain of includes is a cycle, only the including file itself is skipped
+	_, err := LoadFile("test_data/includes/cycle_glob/a.hcl")
+	require.Error(t, err)
+	require.Contains(t, err.Error(),
+		"include cycle test_data/includes/cycle_glob/a.hcl -> test_data/includes/cycle_glob/sub/b.hcl -> test_data/includes/cycle_glob/a.hcl")
+}
+
+func TestInclude_Invalid(t *testing.T) {
+	for _, invalid := range []string{
+		`include "test_data/includes/nonexistent.hcl" {}`,
+		`include "test_data/includes/*.nothing" {}`,
+		`include "test_data/includes/shared/[" {}`,
+		`include "test_data/includes/cycle/../shared/*.js" {}`,
+		`include "test_data/macro_library.js" {}`,
+	} {
+		_, err := LoadString(invalid)
+		require.Error(t, err, invalid)
+	}
+
+	// Directories without any .hcl files
+	_, err := LoadString(`include "test_data/includes/docs" {}`)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "doesn't contain any *.hcl files")
+}
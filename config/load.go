@@ -2,6 +2,7 @@ package config
 
 import (
 	"errors"
+	"github.com/hashicorp/go-multierror"
 	"github.com/hashicorp/hcl/hcl/ast"
 	"github.com/samhug/ndm/config/auth_providers"
 	"github.com/samhug/ndm/config/utilities"
@@ -18,11 +19,31 @@ func getConfigDefaults() *Config {
 		DeviceGroups: map[string]*DeviceGroupConfig{
 			"": {Devices: map[string]*DeviceConfig{}},
 		},
+		MacroLibraries: map[string]*MacroLibraryConfig{},
+		Positions:      newPositions(),
 	}
 }
 
 // LoadFile reads the contents of a file and parses it into a Config object
 func LoadFile(filePath string) (*Config, error) {
+	cfg, err := loadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// LoadFileLenient reads the contents of a file and parses as much of it as it can into a Config object. Blocks with
+// errors are left out of the Config, and every error is returned individually. The Config is nil if the file can't
+// be parsed at all.
+func LoadFileLenient(filePath string) (*Config, []error) {
+	cfg, err := loadFile(filePath)
+	return cfg, utilities.SplitErrors(err)
+}
+
+// loadFile loads the configuration in a file. If some blocks fail to load the Config holding the others is returned
+// along with the errors.
+func loadFile(filePath string) (*Config, error) {
 	f, err := utilities.LoadFileHcl(filePath)
 	if err != nil {
 		return nil, err
@@ -39,14 +60,23 @@ func LoadFile(filePath string) (*Config, error) {
 		return nil, errors.New("error parsing: config doesn't contain a root object")
 	}
 
-	if err = loadConfigHcl(list, cfg); err != nil {
-		return nil, err
+	var errorAccum *multierror.Error
+
+	includes := newIncludeSet()
+	includes.enter(filePath)
+	if err = loadConfigHcl(list, cfg, fileDir, includes); err != nil {
+		errorAccum = multierror.Append(errorAccum, err)
 	}
 
-	return cfg, nil
+	if err = resolveDeviceClassExtends(cfg.DeviceClasses, cfg.Positions.DeviceClasses); err != nil {
+		errorAccum = multierror.Append(errorAccum, err)
+	}
+
+	return cfg, errorAccum.ErrorOrNil()
 }
 
-// LoadString reads the contents of cfg_str and parses it into a Config object
+// LoadString reads the contents of cfg_str and parses it into a Config object. Included files are resolved relative to
+// the working directory.
 func LoadString(cfg_str string) (*Config, error) {
 	f, err := utilities.LoadStringHcl(cfg_str)
 	if err != nil {
@@ -61,7 +91,11 @@ func LoadString(cfg_str string) (*Config, error) {
 		return nil, errors.New("error parsing: config doesn't contain a root object")
 	}
 
-	if err = loadConfigHcl(list, cfg); err != nil {
+	if err = loadConfigHcl(list, cfg, "", newIncludeSet()); err != nil {
+		return nil, err
+	}
+
+	if err = resolveDeviceClassExtends(cfg.DeviceClasses, cfg.Positions.DeviceClasses); err != nil {
 		return nil, err
 	}
 
@@ -0,0 +1,103 @@
+package config
+
+import (
+	"github.com/go-errors/errors"
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/hcl"
+	"github.com/hashicorp/hcl/hcl/ast"
+	"github.com/mitchellh/mapstructure"
+	"github.com/samhug/ndm/config/utilities"
+	"io/ioutil"
+	"path/filepath"
+)
+
+// MacroLibraryConfig represents a macro_library block, JavaScript that's loaded into the VM before the macros of the
+// device classes that use it. The script is given inline as source, or read from file relative to the config file
+// that defines it, which may be an included file.
+type MacroLibraryConfig struct {
+	Source string `mapstructure:"source,"`
+	File   string `mapstructure:"file,"`
+}
+
+// libraryFilePath resolves a macro_library's file relative to configDir, the directory of the config file that defines
+// the library, unless it's absolute
+func libraryFilePath(configDir string, file string) string {
+	if filepath.IsAbs(file) {
+		return file
+	}
+	return filepath.Join(configDir, file)
+}
+
+// loadMacroLibraryConfigsHcl loads macro_library blocks into libraryCfgs. Library files are read immediately, so
+// Source always holds the library's script.
+func loadMacroLibraryConfigsHcl(list *ast.ObjectList, configDir string, libraryCfgs *map[string]*MacroLibraryConfig) error {
+	list = list.Children()
+	if len(list.Items) == 0 {
+		return nil
+	}
+
+	var errorAccum *multierror.Error
+
+	for _, item := range list.Items {
+		name := item.Keys[0].Token.Value().(string)
+
+		var parsed map[string]interface{}
+		if err := hcl.DecodeObject(&parsed, item.Val); err != nil {
+			errorAccum = multierror.Append(errorAccum, utilities.ErrorAt(item.Pos(), "macro_library '%s': %s", name, err))
+			continue
+		}
+
+		var result MacroLibraryConfig
+		decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+			Result:      &result,
+			ErrorUnused: true,
+		})
+		if err != nil {
+			return errors.New("Failed constructing Decoder")
+		}
+		if err := decoder.Decode(parsed); err != nil {
+			errorAccum = multierror.Append(errorAccum, utilities.ErrorAt(item.Pos(), "macro_library '%s': %s", name, err))
+			continue
+		}
+
+		switch {
+		case result.Source != "" && result.File != "":
+			errorAccum = multierror.Append(errorAccum, utilities.ErrorAt(item.Pos(), "macro_library '%s': source and file can't both be specified", name))
+			continue
+		case result.File != "":
+			filePath := libraryFilePath(configDir, result.File)
+			source, err := ioutil.ReadFile(filePath)
+			if err != nil {
+				errorAccum = multierror.Append(errorAccum, utilities.ErrorAt(item.Pos(), "macro_library '%s': Unable to read '%s': %s", name, filePath, err))
+				continue
+			}
+			result.Source = string(source)
+		case result.Source == "":
+			errorAccum = multierror.Append(errorAccum, utilities.ErrorAt(item.Pos(), "macro_library '%s': either source or file must be specified", name))
+			continue
+		}
+
+		if result.File != "" {
+			if pos, err := checkScriptFile(result.Source, libraryFilePath(configDir, result.File)); err != nil {
+				errorAccum = multierror.Append(errorAccum, utilities.ErrorAt(pos, "macro_library '%s': Invalid script: %s", name, err))
+				continue
+			}
+		} else if pos, err := checkScript(result.Source, attributeValue(item, "source"), item.Pos()); err != nil {
+			errorAccum = multierror.Append(errorAccum, utilities.ErrorAt(pos, "macro_library '%s': Invalid script: %s", name, err))
+			continue
+		}
+
+		if _, ok := (*libraryCfgs)[name]; ok {
+			errorAccum = multierror.Append(errorAccum, utilities.ErrorAt(item.Pos(), "macro_library '%s': macro_library already exists with that name", name))
+			continue
+		}
+
+		(*libraryCfgs)[name] = &result
+	}
+
+	if errorAccum.ErrorOrNil() != nil {
+		return errors.Wrap(errorAccum, 0)
+	}
+
+	return nil
+}
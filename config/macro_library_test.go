@@ -0,0 +1,132 @@
+package config
+
+import (
+	"fmt"
+	"github.com/samhug/ndm/config/utilities"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func loadTestMacroLibraries(t *testing.T, config_str string) (map[string]*MacroLibraryConfig, error) {
+	c, err := utilities.LoadStringHcl(config_str)
+	require.NoError(t, err)
+
+	list, ok := utilities.GetObjectList(c)
+	require.True(t, ok)
+
+	results := map[string]*MacroLibraryConfig{}
+	err = loadMacroLibraryConfigsHcl(list.Filter("macro_library"), "test_data", &results)
+	return results, err
+}
+
+func TestMacroLibrary(t *testing.T) {
+	results, err := loadTestMacroLibraries(t, `
+macro_library "inline" {
+	source = "function a() {}"
+}
+macro_library "from_file" {
+	file = "macro_library.js"
+}
+	`)
+	require.NoError(t, err)
+
+	source, err := ioutil.ReadFile("test_data/macro_library.js")
+	require.NoError(t, err)
+
+	expected := map[string]*MacroLibraryConfig{
+		"inline":    {Source: "function a() {}"},
+		"from_file": {Source: string(source), File: "macro_library.js"},
+	}
+	require.Equal(t, expected, results)
+}
+
+func TestMacroLibrary_IncludedFile(t *testing.T) {
+	// The library's file is resolved relative to the included file that defines it, not the main config file
+	cfg, err := LoadFile("test_data/includes/library_main.hcl")
+	require.NoError(t, err)
+
+	source, err := ioutil.ReadFile("test_data/includes/libraries/scripts/cli.js")
+	require.NoError(t, err)
+	require.Equal(t, &MacroLibraryConfig{Source: string(source), File: "scripts/cli.js"}, cfg.MacroLibraries["cli"])
+	require.Equal(t, []string{"cli"}, cfg.DeviceClasses["cisco"].Libraries)
+
+	// Absolute paths are used as they are
+	abs, err := filepath.Abs("test_data/macro_library.js")
+	require.NoError(t, err)
+	results, err := loadTestMacroLibraries(t, fmt.Sprintf(`macro_library "abs" { file = %q }`, abs))
+	require.NoError(t, err)
+	require.Contains(t, results, "abs")
+}
+
+func TestMacroLibrary_Invalid(t *testing.T) {
+	for _, invalid := range []string{
+		`macro_library "empty" {}`,
+		`macro_library "both" { source = "function a() {}" file = "macro_library.js" }`,
+		`macro_library "missing" { file = "nonexistent.js" }`,
+		`macro_library "unknown" { source = "function a() {}" sauce = "b" }`,
+		`macro_library "dup" { source = "a" } macro_library "dup" { source = "b" }`,
+	} {
+		_, err := loadTestMacroLibraries(t, invalid)
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestMacroLibrary_ErrorsAccumulate(t *testing.T) {
+	// A block that can't be decoded doesn't stop the libraries after it loading, or their errors being reported
+	results, err := loadTestMacroLibraries(t, `
+macro_library "undecodable" {
+	source = 1
+	source { a = 2 }
+}
+macro_library "missing" {
+	file = "nonexistent.js"
+}
+macro_library "ok" {
+	source = "function a() {}"
+}
+	`)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "macro_library 'undecodable'")
+	require.Contains(t, err.Error(), "macro_library 'missing'")
+	require.Equal(t, map[string]*MacroLibraryConfig{"ok": {Source: "function a() {}"}}, results)
+}
+
+func TestMacroLibrary_ScriptErrorPositions(t *testing.T) {
+	_, err := loadTestMacroLibraries(t, `
+macro_library "from_file" {
+	file = "broken_library.js"
+}
+macro_library "inline" {
+	source = <<-JS
+		function a() {
+			return (
+		}
+	JS
+}
+	`)
+	require.Error(t, err)
+
+	require.Contains(t, err.Error(), "test_data/broken_library.js:4:1: macro_library 'from_file': Invalid script: ")
+	require.Contains(t, err.Error(), "9:3: macro_library 'inline': Invalid script: ")
+}
+
+func TestDeviceClass_Libraries(t *testing.T) {
+	c, err := utilities.LoadStringHcl(`
+device_class "D_CLASS_A" {
+	libraries = ["common", "cisco"]
+	backup_target "TARGET_1" {
+		macro = "MACRO_PLACEHOLDER_1"
+	}
+}
+	`)
+	require.NoError(t, err)
+
+	list, ok := utilities.GetObjectList(c)
+	require.True(t, ok)
+
+	results := map[string]*DeviceClassConfig{}
+	require.NoError(t, loadDeviceClassConfigsHcl(list.Filter("device_class"), &results))
+	require.Equal(t, []string{"common", "cisco"}, results["D_CLASS_A"].Libraries)
+}
@@ -0,0 +1,81 @@
+package config
+
+import (
+	"github.com/hashicorp/hcl/hcl/ast"
+	"github.com/hashicorp/hcl/hcl/token"
+	"path"
+)
+
+// Positions records where the configuration's blocks are defined, so problems with them can be reported as
+// file:line:column. Blocks loaded from a string have no filename.
+type Positions struct {
+	AuthProviders  map[string]token.Pos
+	MacroLibraries map[string]token.Pos
+	DeviceClasses  map[string]token.Pos
+	// BackupTargets is keyed by device_class name, then backup_target name
+	BackupTargets map[string]map[string]token.Pos
+	DeviceGroups  map[string]token.Pos
+	// Devices is keyed by the device's full name, its group name and device name joined with a '/'
+	Devices map[string]token.Pos
+}
+
+func newPositions() *Positions {
+	return &Positions{
+		AuthProviders:  map[string]token.Pos{},
+		MacroLibraries: map[string]token.Pos{},
+		DeviceClasses:  map[string]token.Pos{},
+		BackupTargets:  map[string]map[string]token.Pos{},
+		DeviceGroups:   map[string]token.Pos{},
+		Devices:        map[string]token.Pos{},
+	}
+}
+
+// recordPositions records the positions of the blocks in list
+func recordPositions(list *ast.ObjectList, positions *Positions) {
+	for _, item := range list.Filter("auth_provider").Children().Items {
+		positions.AuthProviders[itemName(item, 1)] = item.Pos()
+	}
+
+	for _, item := range list.Filter("macro_library").Children().Items {
+		positions.MacroLibraries[itemName(item, 0)] = item.Pos()
+	}
+
+	for _, item := range list.Filter("device_class").Children().Items {
+		className := itemName(item, 0)
+		positions.DeviceClasses[className] = item.Pos()
+
+		targets := map[string]token.Pos{}
+		if ot, ok := item.Val.(*ast.ObjectType); ok {
+			for _, target := range ot.List.Filter("backup_target").Children().Items {
+				targets[itemName(target, 0)] = target.Pos()
+			}
+		}
+		positions.BackupTargets[className] = targets
+	}
+
+	recordDevicePositions(list.Filter("device"), "", positions)
+
+	for _, item := range list.Filter("device_group").Children().Items {
+		groupName := itemName(item, 0)
+		positions.DeviceGroups[groupName] = item.Pos()
+
+		if ot, ok := item.Val.(*ast.ObjectType); ok {
+			recordDevicePositions(ot.List.Filter("device"), groupName, positions)
+		}
+	}
+}
+
+func recordDevicePositions(list *ast.ObjectList, groupName string, positions *Positions) {
+	for _, item := range list.Children().Items {
+		positions.Devices[path.Join(groupName, itemName(item, 1))] = item.Pos()
+	}
+}
+
+// itemName returns the value of the i-th key of item, or an empty string if it doesn't have one
+func itemName(item *ast.ObjectItem, i int) string {
+	if len(item.Keys) <= i {
+		return ""
+	}
+	name, _ := item.Keys[i].Token.Value().(string)
+	return name
+}
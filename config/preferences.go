@@ -11,18 +11,39 @@ import (
 
 // PreferencesConfig represents a preferences configuration block
 type PreferencesConfig struct {
-	BackupDir string `mapstructure:"backup_dir,"`
-	HostIP    string `mapstructure:"host_ip,"`
+	BackupDir     string `mapstructure:"backup_dir,"`
+	HostIP        string `mapstructure:"host_ip,"`
+	History       string `mapstructure:"history,"`
+	HistoryAuthor string `mapstructure:"history_author,"`
+	KnownHosts    string `mapstructure:"known_hosts,"`
+	// HostKeyPolicy is one of the HostKeyPolicy values, empty if it isn't set
+	HostKeyPolicy string `mapstructure:"host_key_policy,"`
+	MaxParallel   int    `mapstructure:"max_parallel,"`
+	Retries       int    `mapstructure:"retries,"`
+	RetryBackoff  string `mapstructure:"retry_backoff,"`
 }
 
+// Supported values for the preferences 'history' field
+const (
+	HistoryNone = ""
+	HistoryGit  = "git"
+)
+
+// Supported values for the preferences 'host_key_policy' field, they match the policies understood by
+// auth.NewHostKeyVerifier
+const (
+	HostKeyPolicyStrict   = "strict"
+	HostKeyPolicyTOFU     = "tofu"
+	HostKeyPolicyInsecure = "insecure"
+)
+
 // loadPreferencesHcl
 func loadPreferencesHcl(list *ast.ObjectList, preferencesCfg *PreferencesConfig) error {
 	if len(list.Items) == 0 {
 		return nil
 	}
 	if len(list.Items) > 1 {
-		return errors.New(
-			"only one 'preferences' block may be specified")
+		return utilities.ErrorAt(list.Items[1].Pos(), "only one 'preferences' block may be specified")
 	}
 
 	var errorAccum *multierror.Error
@@ -39,15 +60,39 @@ func loadPreferencesHcl(list *ast.ObjectList, preferencesCfg *PreferencesConfig)
 	item := list.Items[0]
 	var parsed map[string]interface{}
 	if err := hcl.DecodeObject(&parsed, item.Val); err != nil {
-		return err
+		return utilities.ErrorAt(item.Pos(), "preferences: %s", err)
 	}
 
 	if err := decoder.Decode(parsed); err != nil {
-		errorAccum = multierror.Append(errorAccum, errors.Errorf("preferences: %s", err))
+		errorAccum = multierror.Append(errorAccum, utilities.ErrorAt(item.Pos(), "preferences: %s", err))
 	}
 
 	if err = utilities.CheckForRequiredFields(&metadata, []string{"backup_dir"}); err != nil {
-		errorAccum = multierror.Append(errorAccum, errors.Errorf("preferences: %s", err))
+		errorAccum = multierror.Append(errorAccum, utilities.ErrorAt(item.Pos(), "preferences: %s", err))
+	}
+
+	switch preferencesCfg.History {
+	case HistoryNone, HistoryGit:
+	default:
+		errorAccum = multierror.Append(errorAccum, utilities.ErrorAt(item.Pos(), "preferences: Unsupported history mode '%s'", preferencesCfg.History))
+	}
+
+	switch preferencesCfg.HostKeyPolicy {
+	case "", HostKeyPolicyStrict, HostKeyPolicyInsecure:
+	case HostKeyPolicyTOFU:
+		if preferencesCfg.KnownHosts == "" {
+			errorAccum = multierror.Append(errorAccum, utilities.ErrorAt(item.Pos(), "preferences: host_key_policy 'tofu' requires a known_hosts file"))
+		}
+	default:
+		errorAccum = multierror.Append(errorAccum, utilities.ErrorAt(item.Pos(), "preferences: Unsupported host_key_policy '%s'", preferencesCfg.HostKeyPolicy))
+	}
+
+	if err := validateRetrySettings(&preferencesCfg.Retries, preferencesCfg.RetryBackoff); err != nil {
+		errorAccum = multierror.Append(errorAccum, utilities.ErrorAt(item.Pos(), "preferences: %s", err))
+	}
+
+	if preferencesCfg.MaxParallel < 0 {
+		errorAccum = multierror.Append(errorAccum, utilities.ErrorAt(item.Pos(), "preferences: max_parallel can't be negative"))
 	}
 
 	if errorAccum.ErrorOrNil() != nil {
@@ -56,8 +101,15 @@ func loadPreferencesHcl(list *ast.ObjectList, preferencesCfg *PreferencesConfig)
 
 	// Check for invalid keys
 	validKeys := map[string]struct{}{
-		"backup_dir": struct{}{},
-		"host_ip":    struct{}{},
+		"backup_dir":      struct{}{},
+		"host_ip":         struct{}{},
+		"history":         struct{}{},
+		"history_author":  struct{}{},
+		"known_hosts":     struct{}{},
+		"host_key_policy": struct{}{},
+		"max_parallel":    struct{}{},
+		"retries":         struct{}{},
+		"retry_backoff":   struct{}{},
 	}
 	for _, item := range list.Items {
 		if len(item.Keys) == 0 {
@@ -69,7 +121,7 @@ func loadPreferencesHcl(list *ast.ObjectList, preferencesCfg *PreferencesConfig)
 			continue
 		}
 
-		return errors.Errorf("preferences: Unrecognized key '%s'", k)
+		return utilities.ErrorAt(item.Pos(), "preferences: Unrecognized key '%s'", k)
 	}
 
 	return nil
@@ -51,3 +51,109 @@ preferences {
 	require.Equal(t, &PreferencesConfig{BackupDir: "./router-configs/", HostIP: ""}, result)
 
 }
+
+func TestPreferences_History(t *testing.T) {
+
+	config_str := `
+preferences {
+	backup_dir = "./router-configs/"
+	history = "git"
+	history_author = "Backup Bot <backup@example.com>"
+}
+	`
+	c, err := utilities.LoadStringHcl(config_str)
+	require.NoError(t, err)
+
+	list, ok := utilities.GetObjectList(c)
+	require.True(t, ok)
+
+	result := &PreferencesConfig{}
+
+	err = loadPreferencesHcl(list.Filter("preferences"), result)
+	require.NoError(t, err)
+
+	require.Equal(t, &PreferencesConfig{BackupDir: "./router-configs/", History: HistoryGit, HistoryAuthor: "Backup Bot <backup@example.com>"}, result)
+
+	c, err = utilities.LoadStringHcl(`preferences { backup_dir = "./" history = "svn" }`)
+	require.NoError(t, err)
+
+	list, ok = utilities.GetObjectList(c)
+	require.True(t, ok)
+
+	err = loadPreferencesHcl(list.Filter("preferences"), &PreferencesConfig{})
+	require.Error(t, err)
+}
+
+func TestPreferences_HostKeys(t *testing.T) {
+
+	config_str := `
+preferences {
+	backup_dir = "./router-configs/"
+	known_hosts = "./known_hosts"
+	host_key_policy = "tofu"
+}
+	`
+	c, err := utilities.LoadStringHcl(config_str)
+	require.NoError(t, err)
+
+	list, ok := utilities.GetObjectList(c)
+	require.True(t, ok)
+
+	result := &PreferencesConfig{}
+
+	err = loadPreferencesHcl(list.Filter("preferences"), result)
+	require.NoError(t, err)
+
+	require.Equal(t, &PreferencesConfig{BackupDir: "./router-configs/", KnownHosts: "./known_hosts", HostKeyPolicy: HostKeyPolicyTOFU}, result)
+
+	for _, invalid := range []string{
+		`preferences { backup_dir = "./" host_key_policy = "tofu" }`,
+		`preferences { backup_dir = "./" host_key_policy = "paranoid" }`,
+	} {
+		c, err = utilities.LoadStringHcl(invalid)
+		require.NoError(t, err)
+
+		list, ok = utilities.GetObjectList(c)
+		require.True(t, ok)
+
+		err = loadPreferencesHcl(list.Filter("preferences"), &PreferencesConfig{})
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestPreferences_Retries(t *testing.T) {
+
+	config_str := `
+preferences {
+	backup_dir = "./router-configs/"
+	retries = 3
+	retry_backoff = "15s"
+}
+	`
+	c, err := utilities.LoadStringHcl(config_str)
+	require.NoError(t, err)
+
+	list, ok := utilities.GetObjectList(c)
+	require.True(t, ok)
+
+	result := &PreferencesConfig{}
+
+	err = loadPreferencesHcl(list.Filter("preferences"), result)
+	require.NoError(t, err)
+
+	require.Equal(t, &PreferencesConfig{BackupDir: "./router-configs/", Retries: 3, RetryBackoff: "15s"}, result)
+
+	for _, invalid := range []string{
+		`preferences { backup_dir = "./" retries = -1 }`,
+		`preferences { backup_dir = "./" retry_backoff = "15" }`,
+	} {
+		c, err = utilities.LoadStringHcl(invalid)
+		require.NoError(t, err)
+
+		list, ok = utilities.GetObjectList(c)
+		require.True(t, ok)
+
+		err = loadPreferencesHcl(list.Filter("preferences"), &PreferencesConfig{})
+		require.Error(t, err, invalid)
+	}
+}
@@ -0,0 +1,26 @@
+package config
+
+import (
+	"github.com/go-errors/errors"
+	"time"
+)
+
+// validateRetrySettings checks the 'retries' and 'retry_backoff' fields shared by the preferences, device_class and
+// device blocks
+func validateRetrySettings(retries *int, retryBackoff string) error {
+	if retries != nil && *retries < 0 {
+		return errors.New("retries can't be negative")
+	}
+
+	if retryBackoff != "" {
+		backoff, err := time.ParseDuration(retryBackoff)
+		if err != nil {
+			return errors.Errorf("Invalid retry_backoff: %s", err)
+		}
+		if backoff < 0 {
+			return errors.New("retry_backoff can't be negative")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"github.com/go-errors/errors"
+	"github.com/hashicorp/hcl/hcl/ast"
+	"github.com/hashicorp/hcl/hcl/token"
+	"github.com/robertkrimen/otto/parser"
+	"strings"
+)
+
+// attributeValue returns the literal value of an attribute of the block item, nil if it isn't set
+func attributeValue(item *ast.ObjectItem, key string) *ast.LiteralType {
+	ot, ok := item.Val.(*ast.ObjectType)
+	if !ok {
+		return nil
+	}
+	for _, attr := range ot.List.Filter(key).Items {
+		if lit, ok := attr.Val.(*ast.LiteralType); ok {
+			return lit
+		}
+	}
+	return nil
+}
+
+// checkScript parses the JavaScript src without running it. A syntax error is returned along with its position in
+// the configuration, mapped through the string or heredoc lit that src was read from. If lit is nil the position is
+// fallback.
+func checkScript(src string, lit *ast.LiteralType, fallback token.Pos) (token.Pos, error) {
+	line, column, err := parseScript(src)
+	if err == nil {
+		return token.Pos{}, nil
+	}
+	if lit == nil {
+		return fallback, errors.Errorf("Line %d:%d %s", line, column, err)
+	}
+
+	pos := lit.Token.Pos
+	if lit.Token.Type == token.HEREDOC {
+		// The script starts on the line after the heredoc marker. Indented heredocs have their indentation removed,
+		// so the difference in length between the raw and script lines is added to the column.
+		pos.Line += line
+		pos.Column = column
+		rawLines := strings.Split(lit.Token.Text, "\n")
+		srcLines := strings.Split(src, "\n")
+		if line < len(rawLines) && line <= len(srcLines) {
+			pos.Column += len(rawLines[line]) - len(srcLines[line-1])
+		}
+	} else if line == 1 {
+		// Skip the opening quote
+		pos.Column += column
+	}
+
+	return pos, err
+}
+
+// checkScriptFile parses the JavaScript src, read from filename, without running it. A syntax error is returned along
+// with its position in the file.
+func checkScriptFile(src string, filename string) (token.Pos, error) {
+	line, column, err := parseScript(src)
+	if err != nil {
+		return token.Pos{Filename: filename, Line: line, Column: column}, err
+	}
+	return token.Pos{}, nil
+}
+
+// parseScript parses the JavaScript src, returning the line and column of the first syntax error and its description
+func parseScript(src string) (int, int, error) {
+	_, err := parser.ParseFile(nil, "", src, 0)
+	if err == nil {
+		return 0, 0, nil
+	}
+
+	var first *parser.Error
+	switch err := err.(type) {
+	case parser.ErrorList:
+		if len(err) > 0 {
+			first = err[0]
+		}
+	case *parser.Error:
+		first = err
+	}
+	if first == nil {
+		return 1, 1, err
+	}
+
+	return first.Position.Line, first.Position.Column, errors.New(first.Message)
+}
@@ -0,0 +1,12 @@
+auth_provider "static" "basic" {
+	auth "testA" {
+		username = "john.doe"
+		password = "secret"
+	}
+}
+
+device "classA" "deviceA" {
+	address = "127.0.0.1:22"
+	auth = "basic:testA"
+	tags = ["not a tag"]
+}
@@ -0,0 +1,3 @@
+include "../test_include.conf" {}
+
+include "devices.hcl" {}
@@ -0,0 +1,9 @@
+device_class "cisco" {
+	libraries = ["common"]
+
+	backup_target "running" {
+		macro = "enable()"
+	}
+}
+
+include "../shared/common.hcl" {}
@@ -0,0 +1 @@
+include "b.hcl" {}
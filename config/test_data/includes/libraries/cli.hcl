@@ -0,0 +1,3 @@
+macro_library "cli" {
+	file = "scripts/cli.js"
+}
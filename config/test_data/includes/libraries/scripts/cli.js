@@ -0,0 +1,3 @@
+function showRun() {
+	return captureCommand("show running-config", "#")
+}
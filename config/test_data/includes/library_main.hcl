@@ -0,0 +1,10 @@
+include "libraries/cli.hcl" {}
+
+device_class "cisco" {
+	libraries = ["cli"]
+
+	backup_target "running" {
+		mode = "capture"
+		macro = "expect('#'); showRun()"
+	}
+}
@@ -0,0 +1,7 @@
+include "classes" {}
+
+include "sites/*.hcl" {}
+
+preferences {
+	backup_dir = "/backups"
+}
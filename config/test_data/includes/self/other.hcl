@@ -0,0 +1,7 @@
+include "o*.hcl" {}
+
+device_class "other" {
+	backup_target "running" {
+		macro = "sendLine('show run')"
+	}
+}
@@ -0,0 +1,10 @@
+macro_library "common" {
+	file = "common.js"
+}
+
+auth_provider "static" "basic" {
+	auth "admin" {
+		username = "admin"
+		password = "secret"
+	}
+}
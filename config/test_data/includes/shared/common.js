@@ -0,0 +1,3 @@
+function enable() {
+	sendLine("enable")
+}
@@ -0,0 +1,8 @@
+include "../shared/common.hcl" {}
+
+device_group "site-a" {
+	device "cisco" "router" {
+		address = "10.1.0.1:22"
+		auth = "basic:admin"
+	}
+}
@@ -0,0 +1,8 @@
+include "../shared/common.hcl" {}
+
+device_group "site-b" {
+	device "cisco" "router" {
+		address = "10.2.0.1:22"
+		auth = "basic:admin"
+	}
+}
@@ -0,0 +1,4 @@
+function enable() {
+    sendLine("enable")
+    expect("#")
+}
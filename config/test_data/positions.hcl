@@ -0,0 +1,30 @@
+include "test_include.conf" {}
+
+auth_provider "static" "basic" {
+	auth "testA" {
+		username = "john.doe"
+		password = "secret"
+	}
+}
+
+macro_library "common" {
+	source = "function noop() {}"
+}
+
+device_class "classB" {
+	backup_target "running" {
+		macro = "MACRO"
+	}
+}
+
+device "classA" "deviceB" {
+	address = "127.0.0.1:22"
+	auth = "basic:testA"
+}
+
+device_group "site-a" {
+	device "classB" "deviceC" {
+		address = "127.0.0.2:22"
+		auth = "basic:testA"
+	}
+}
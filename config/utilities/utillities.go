@@ -3,8 +3,11 @@ package utilities
 import (
 	"fmt"
 	"github.com/go-errors/errors"
+	"github.com/hashicorp/go-multierror"
 	"github.com/hashicorp/hcl"
 	"github.com/hashicorp/hcl/hcl/ast"
+	"github.com/hashicorp/hcl/hcl/parser"
+	"github.com/hashicorp/hcl/hcl/token"
 	"github.com/mitchellh/mapstructure"
 	"io/ioutil"
 )
@@ -20,13 +23,40 @@ func LoadFileHcl(root string) (*ast.File, error) {
 
 	f, err := LoadStringHcl(string(d))
 	if err != nil {
+		if posErr, ok := err.(*parser.PosError); ok {
+			posErr.Pos.Filename = root
+			return nil, ErrorAt(posErr.Pos, "Error parsing: %s", posErr.Err)
+		}
 		return nil, fmt.Errorf(
 			"Error parsing %s: %s", root, err)
 	}
 
+	setFilename(f, root)
+
 	return f, nil
 }
 
+// setFilename records filename in the positions of every node in f, so they can be reported as file:line:column
+func setFilename(f *ast.File, filename string) {
+	ast.Walk(f, func(n ast.Node) (ast.Node, bool) {
+		switch n := n.(type) {
+		case *ast.ObjectItem:
+			n.Assign.Filename = filename
+		case *ast.ObjectKey:
+			n.Token.Pos.Filename = filename
+		case *ast.LiteralType:
+			n.Token.Pos.Filename = filename
+		case *ast.ListType:
+			n.Lbrack.Filename = filename
+			n.Rbrack.Filename = filename
+		case *ast.ObjectType:
+			n.Lbrace.Filename = filename
+			n.Rbrace.Filename = filename
+		}
+		return n, true
+	})
+}
+
 // LoadFileHcl reads a string into a Configurable object
 func LoadStringHcl(d string) (*ast.File, error) {
 	// Parse it
@@ -59,3 +89,61 @@ func CheckForRequiredFields(metadata *mapstructure.Metadata, requiredFields []st
 
 	return nil
 }
+
+// PositionError is an error at a position in a configuration file, formatted as file:line:column: message
+type PositionError struct {
+	Pos token.Pos
+	Msg string
+	// Err is the error that caused this one, if any. Its message follows Msg.
+	Err error
+}
+
+func (e *PositionError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s: %s", e.Pos, e.Msg, e.Err)
+	}
+	return fmt.Sprintf("%s: %s", e.Pos, e.Msg)
+}
+
+func (e *PositionError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorAt constructs an error whose message is prefixed with pos, formatted as file:line:column
+func ErrorAt(pos token.Pos, format string, a ...interface{}) error {
+	return &PositionError{Pos: pos, Msg: fmt.Sprintf(format, a...)}
+}
+
+// WrapAt constructs an error at pos caused by err, such as an error in a file that an include block at pos loads
+func WrapAt(pos token.Pos, err error, format string, a ...interface{}) error {
+	return &PositionError{Pos: pos, Msg: fmt.Sprintf(format, a...), Err: err}
+}
+
+// SplitErrors returns the individual errors that err combines. Errors caused by errors with a position of their own,
+// such as those in an included file, are replaced by the errors that caused them.
+func SplitErrors(err error) []error {
+	switch e := err.(type) {
+	case nil:
+		return nil
+	case *errors.Error:
+		return SplitErrors(e.Err)
+	case *multierror.Error:
+		var errs []error
+		for _, err := range e.Errors {
+			errs = append(errs, SplitErrors(err)...)
+		}
+		return errs
+	case *PositionError:
+		if e.Err == nil {
+			return []error{e}
+		}
+		causes := SplitErrors(e.Err)
+		for _, cause := range causes {
+			if _, ok := cause.(*PositionError); !ok {
+				return []error{e}
+			}
+		}
+		return causes
+	}
+	return []error{err}
+}
@@ -0,0 +1,41 @@
+package config
+
+import (
+	"github.com/go-errors/errors"
+	"github.com/mitchellh/mapstructure"
+)
+
+// decodeVars decodes the value of a vars block. Numbers and booleans are converted to strings so every var reaches
+// the macros as a string.
+func decodeVars(raw interface{}) (map[string]string, error) {
+	if raw == nil {
+		return nil, nil
+	}
+
+	vars := make(map[string]string)
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		Result:           &vars,
+		WeaklyTypedInput: true, // Needed to merge the block into a map and convert the values
+	})
+	if err != nil {
+		return nil, errors.New("Failed constructing Decoder")
+	}
+	if err := decoder.Decode(raw); err != nil {
+		return nil, errors.Errorf("vars: %s", err)
+	}
+
+	return vars, nil
+}
+
+// MergeVars returns the vars of base overridden by those of override. The result is never nil, so it can be exposed
+// to macros as ctx.vars even if neither sets any vars.
+func MergeVars(base map[string]string, override map[string]string) map[string]string {
+	merged := make(map[string]string, len(base)+len(override))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range override {
+		merged[k] = v
+	}
+	return merged
+}
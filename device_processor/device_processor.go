@@ -1,29 +1,38 @@
 package device_processor
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"github.com/davecgh/go-spew/spew"
 	"github.com/go-errors/errors"
 	"github.com/hashicorp/go-uuid"
 	"github.com/robertkrimen/otto"
-	"github.com/samhug/gexpect"
 	"github.com/samhug/ndm/auth"
+	"github.com/samhug/ndm/config"
 	"github.com/samhug/ndm/devices"
+	"github.com/samhug/ndm/report"
 	"golang.org/x/crypto/ssh"
 	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	"os"
 	"path"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
-// NewDeviceProcessor: Initializes a new DeviceProcessor object
-func NewDeviceProcessor(device *devices.Device, authProviders *auth.ProviderPool, backupDir string) *DeviceProcessor {
+// NewDeviceProcessor: Initializes a new DeviceProcessor object. If hostKeys is nil the device's host key isn't verified.
+// Failed backup targets are retried according to retry.
+func NewDeviceProcessor(device *devices.Device, authProviders *auth.ProviderPool, hostKeys *auth.HostKeyVerifier, retry devices.RetryPolicy, backupDir string) *DeviceProcessor {
 	return &DeviceProcessor{
 		device:        device,
 		authProviders: authProviders,
+		hostKeys:      hostKeys,
+		retry:         retry,
 		configDir:     backupDir,
 	}
 }
@@ -31,6 +40,8 @@ func NewDeviceProcessor(device *devices.Device, authProviders *auth.ProviderPool
 type vmCtx struct {
 	TFTPHost     string
 	TFTPFilename string
+	// Vars holds the vars of the device and its class
+	Vars map[string]string `json:"vars"`
 }
 
 func (ctx *vmCtx) Serialize() (string, error) {
@@ -43,17 +54,55 @@ func (ctx *vmCtx) Serialize() (string, error) {
 
 type DeviceProcessor struct {
 	authProviders *auth.ProviderPool
+	hostKeys      *auth.HostKeyVerifier
+	retry         devices.RetryPolicy
 	device        *devices.Device
 	configDir     string
+	transcriptDir string
 	vm            *otto.Otto
 }
 
+// SetTranscriptDir: Enables recording a transcript of each backup target's session under dir, for debugging macros
+func (t *DeviceProcessor) SetTranscriptDir(dir string) {
+	t.transcriptDir = dir
+}
+
+// permanentError is a failure that retrying won't fix, such as a host key that doesn't match or credentials the device
+// rejects
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+// isPermanent reports whether err is a failure that retrying won't fix
+func isPermanent(err error) bool {
+	_, ok := err.(*permanentError)
+	return ok
+}
+
+// connect opens an SSH connection to the device. Host key and authentication failures are returned as a
+// *permanentError.
 func (t *DeviceProcessor) connect() (*ssh.Client, error) {
 
 	sshClientConfig, err := t.device.Auth.GetSSHClientConfig()
 	if err != nil {
-		return nil, errors.Errorf("Failed to construct SSHClientConfig from Auth(%s): %s",
-			t.device.AuthPath, err)
+		return nil, &permanentError{errors.Errorf("Failed to construct SSHClientConfig from Auth(%s): %s",
+			t.device.AuthPath, err)}
+	}
+
+	// ssh.Dial only returns the text of a host key callback's error, so note whether it was the host key that failed
+	var hostKeyErr error
+	if t.hostKeys != nil {
+		sshClientConfig.HostKeyAlgorithms = t.hostKeys.HostKeyAlgorithms(t.device.HostKey, t.device.Address)
+
+		callback := t.hostKeys.Callback(t.device.HostKey)
+		sshClientConfig.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			hostKeyErr = callback(hostname, remote, key)
+			return hostKeyErr
+		}
 	}
 
 	// Enable the use of this insecure cypher so we can interact with crappy legacy devices
@@ -61,6 +110,9 @@ func (t *DeviceProcessor) connect() (*ssh.Client, error) {
 
 	client, err := ssh.Dial("tcp", t.device.Address, sshClientConfig)
 	if err != nil {
+		if hostKeyErr != nil || strings.Contains(err.Error(), "ssh: unable to authenticate") {
+			return nil, &permanentError{err}
+		}
 		return nil, err
 	}
 
@@ -101,10 +153,7 @@ func (t *DeviceProcessor) startShell(client *ssh.Client) (*ssh.Session, io.Write
 	return session, stdIn, stdOut, nil
 }
 
-func (t *DeviceProcessor) initVM(stdIn io.WriteCloser, stdOut io.Reader, ctx vmCtx) (*otto.Otto, error) {
-
-	expect := gexpect.NewExpectIO(stdOut, stdIn)
-	expect.Capture()
+func (t *DeviceProcessor) initVM(expect *expectSession, ctx vmCtx, captured *bytes.Buffer, expectTimeout time.Duration, tr *transcript) (*otto.Otto, error) {
 
 	vm := otto.New()
 
@@ -115,10 +164,42 @@ func (t *DeviceProcessor) initVM(stdIn io.WriteCloser, stdOut io.Reader, ctx vmC
 	})
 
 	// Initialize the Expect library
-	if err = ottoExpect(vm, expect); err != nil {
+	if err = ottoExpect(vm, expect, captured, expectTimeout); err != nil {
 		return nil, errors.Errorf("Failed to initialize the expect library: %s", err)
 	}
 
+	// function disablePaging(prompt string, options object) {}
+	// Sends the class's pager disable_command and waits for prompt, so shared macros can turn paging off on any
+	// device. Does nothing if the class doesn't set one. options are the same as for expect.
+	err = vm.Set("disablePaging", func(call otto.FunctionCall) otto.Value {
+		pager := t.device.Class.Pager
+		if pager == nil || pager.DisableCommand == "" {
+			return otto.Value{}
+		}
+
+		opts, err := parseExpectOptions(call.Argument(1), expectOptions{timeout: expectTimeout})
+		if err != nil {
+			panic(vm.MakeCustomError("ExpectError", err.Error()))
+		}
+
+		prompt, err := newMatcher(call.Argument(0).String(), opts.regex)
+		if err != nil {
+			panic(vm.MakeCustomError("ExpectError", err.Error()))
+		}
+
+		if err := expect.sendLine(pager.DisableCommand); err != nil {
+			panic(vm.MakeCustomError("ExpectError", err.Error()))
+		}
+		if _, err := expect.expect(prompt, opts.timeout); err != nil {
+			panic(vm.MakeCustomError("ExpectError", err.Error()))
+		}
+
+		return otto.Value{}
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	err = vm.Set("getAuthAttr", func(call otto.FunctionCall) otto.Value {
 		attrName := call.Argument(0).String()
 
@@ -127,6 +208,9 @@ func (t *DeviceProcessor) initVM(stdIn io.WriteCloser, stdOut io.Reader, ctx vmC
 			return vm.MakeCustomError("AttrError", fmt.Sprintf("Unable to find auth attribute '%s': %s", attrName, err))
 		}
 
+		// Auth attributes are usually secrets, keep them out of the transcript
+		tr.addSecret(val)
+
 		ottoVal, err := vm.ToValue(val)
 		if err != nil {
 			return vm.MakeCustomError("TypeError", "Unable to convert AuthAttr to a string")
@@ -154,9 +238,15 @@ func (t *DeviceProcessor) initVM(stdIn io.WriteCloser, stdOut io.Reader, ctx vmC
 	return vm, nil
 }
 
-func (t *DeviceProcessor) saveFile(backupTarget *devices.DeviceClassTarget, file *ReceivedFile) error {
+// configPath returns the path a target's config is saved to, relative to the backup directory
+func (t *DeviceProcessor) configPath(targetName string) string {
+	return path.Join(t.device.Name, fmt.Sprintf("%s.conf", targetName))
+}
+
+func (t *DeviceProcessor) saveFile(backupTarget *devices.DeviceClassTarget, data []byte) error {
 
-	dirPath := path.Join(t.configDir, t.device.Name)
+	dstPath := path.Join(t.configDir, t.configPath(backupTarget.Name))
+	dirPath := path.Dir(dstPath)
 
 	// Create the parent directory structure if needed
 	if _, err := os.Stat(dirPath); os.IsNotExist(err) {
@@ -165,91 +255,240 @@ func (t *DeviceProcessor) saveFile(backupTarget *devices.DeviceClassTarget, file
 		}
 	}
 
-	dstPath := path.Join(dirPath, fmt.Sprintf("%s.conf", backupTarget.Name))
-
-	if err := ioutil.WriteFile(dstPath, file.Data.Bytes(), 0644); err != nil {
+	if err := ioutil.WriteFile(dstPath, data, 0644); err != nil {
 		return errors.Errorf("Unable to write to file '%s': %s", dstPath, err)
 	}
 
 	return nil
 }
 
-func (t *DeviceProcessor) Process(reciever *TFTPReceiver) error {
-	for target_name, _ := range t.device.Class.Targets {
-		log.Printf("Processing backup target '%s':'%s'", t.device.Name, target_name)
+// Process backs up each of the device's targets and returns their results, in target name order. Targets that fail
+// are retried according to the retry policy. Only the failed target is retried, a failure doesn't stop the device's
+// other targets from being backed up.
+func (t *DeviceProcessor) Process(reciever *TFTPReceiver) []report.TargetResult {
+	targetNames := make([]string, 0, len(t.device.Class.Targets))
+	for target_name := range t.device.Class.Targets {
+		targetNames = append(targetNames, target_name)
+	}
+	sort.Strings(targetNames)
 
-		if err := t.ProcessTarget(target_name, reciever); err != nil {
-			return errors.Errorf("target %s: %s", target_name, err)
-		}
+	results := make([]report.TargetResult, 0, len(targetNames))
+	for _, target_name := range targetNames {
+		results = append(results, t.processTargetWithRetries(target_name, reciever))
 	}
 
-	return nil
+	return results
 }
 
-func (t *DeviceProcessor) ProcessTarget(target_name string, reciever *TFTPReceiver) error {
+func (t *DeviceProcessor) processTargetWithRetries(target_name string, reciever *TFTPReceiver) report.TargetResult {
+	result := report.TargetResult{
+		Device: t.device.Name,
+		Target: target_name,
+		Status: report.StatusFailed,
+	}
+	started := time.Now()
+
+	var tr *transcript
+	if t.transcriptDir != "" {
+		var err error
+		if tr, err = openTranscript(t.transcriptDir, t.device.Name, target_name); err != nil {
+			log.Printf("Unable to record a transcript for backup target '%s':'%s': %s\n", t.device.Name, target_name, err)
+		} else {
+			defer tr.Close()
+		}
+	}
+
+	attempts := t.retry.Attempts()
 
-	backupTarget := t.device.Class.Targets[target_name]
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			delay := t.retry.Delay(attempt - 1)
+			log.Printf("Retrying backup target '%s':'%s' in %s\n", t.device.Name, target_name, delay)
+			time.Sleep(delay)
+		}
 
-	// Generate a unique filename to use during the TFTP upload
-	filename, err := uuid.GenerateUUID()
+		log.Printf("Processing backup target '%s':'%s' (attempt %d of %d)", t.device.Name, target_name, attempt, attempts)
+
+		tr.note("device '%s' target '%s' attempt %d of %d", t.device.Name, target_name, attempt, attempts)
+
+		result.Attempts = attempt
+		if result.Bytes, err = t.processTarget(target_name, reciever, tr); err == nil {
+			tr.note("completed, %d bytes", result.Bytes)
+			result.Status = report.StatusSuccess
+			result.Path = t.configPath(target_name)
+			break
+		}
+
+		tr.note("failed: %s", err)
+		log.Printf("Backup target '%s':'%s' failed (attempt %d of %d): %s\n", t.device.Name, target_name, attempt, attempts, err)
+
+		if isPermanent(err) {
+			if attempt < attempts {
+				log.Printf("Not retrying backup target '%s':'%s', the failure isn't one that retrying can fix\n", t.device.Name, target_name)
+			}
+			break
+		}
+	}
+
+	result.Duration = time.Since(started)
 	if err != nil {
-		return errors.Errorf("Failed to generate UUID: %s", err)
+		result.Error = err.Error()
 	}
 
-	// Create channel to recieve the file on
-	recvChan := make(chan ReceivedFile, 3)
+	return result
+}
+
+// processTarget backs up a single target and returns the size of the saved config. The session is recorded to tr.
+func (t *DeviceProcessor) processTarget(target_name string, reciever *TFTPReceiver, tr *transcript) (int, error) {
 
-	// Register the filename and channel with the TFTP receiver
-	reciever.ExpectFile(filename, recvChan)
+	backupTarget := t.device.Class.Targets[target_name]
 
 	// Connect to the device
 	client, err := t.connect()
 	if err != nil {
-		return errors.Errorf("Unable to connect: %s", err)
+		if isPermanent(err) {
+			return 0, &permanentError{errors.Errorf("Unable to connect: %s", err)}
+		}
+		return 0, errors.Errorf("Unable to connect: %s", err)
+	}
+	defer client.Close()
+
+	var data []byte
+
+	if backupTarget.Mode == config.BackupModeFetch {
+		tr.note("fetching '%s' via %s", backupTarget.FetchPath, backupTarget.FetchProtocol)
+		data, err = fetchFile(client, backupTarget.FetchProtocol, backupTarget.FetchPath)
+		if err != nil {
+			return 0, errors.Errorf("Unable to fetch '%s' via %s: %s", backupTarget.FetchPath, backupTarget.FetchProtocol, err)
+		}
+	} else {
+		data, err = t.runMacro(client, backupTarget, reciever, tr)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// Save the retrieved config
+	if err = t.saveFile(backupTarget, data); err != nil {
+		return 0, err
+	}
+
+	log.Printf("Completed backup target: '%s':'%s'\n", t.device.Name, backupTarget.Name)
+
+	return len(data), nil
+}
+
+// runMacro runs the target's macro in a shell session and returns the config it uploaded or captured
+func (t *DeviceProcessor) runMacro(client *ssh.Client, backupTarget *devices.DeviceClassTarget, reciever *TFTPReceiver, tr *transcript) ([]byte, error) {
+
+	ctx := vmCtx{Vars: t.device.MacroVars()}
+	var recvChan <-chan ReceivedFile
+
+	if backupTarget.UsesTFTP() {
+		if reciever == nil {
+			return nil, errors.New("No TFTP receiver is running")
+		}
+		if err := reciever.Err(); err != nil {
+			return nil, errors.Errorf("TFTP server unavailable: %s", err)
+		}
+
+		// Generate a unique filename to use during the TFTP upload
+		filename, err := uuid.GenerateUUID()
+		if err != nil {
+			return nil, errors.Errorf("Failed to generate UUID: %s", err)
+		}
+
+		// Register the filename with the TFTP receiver, the upload is delivered on recvChan
+		recvChan = reciever.ExpectFile(filename, t.device.Name)
+		defer reciever.CancelFile(filename)
+
+		ctx.TFTPHost = reciever.PublicAddr
+		ctx.TFTPFilename = filename
 	}
 
 	session, stdIn, stdOut, err := t.startShell(client)
 	if err != nil {
-		return errors.Errorf("Failed to start shell: %s", err)
+		return nil, errors.Errorf("Failed to start shell: %s", err)
 	}
 	defer session.Close()
 
-	ctx := vmCtx{
-		TFTPHost:     reciever.PublicAddr,
-		TFTPFilename: filename,
-	}
-
-	vm, err := t.initVM(stdIn, stdOut, ctx)
+	captured, err := t.runMacroSession(stdIn, stdOut, backupTarget, ctx, tr)
 	if err != nil {
-		return errors.Errorf("Failed to init JavaScript VM: %s", err)
+		return nil, err
 	}
 
-	if _, err := vm.Run(backupTarget.Macro); err != nil {
-		return errors.Errorf("JavaScript VM Runtime Error: %s", err)
+	if !backupTarget.UsesTFTP() {
+		if len(captured) == 0 {
+			return nil, errors.New("The macro didn't capture any output, use captureCommand() to capture the config")
+		}
+
+		return captured, nil
 	}
 
 	var recvdFile ReceivedFile
 
-	// Wait for a maximum of 60 seconds for the file on the receive channel
+	// Wait for the file to arrive on the receive channel
 	select {
-	case err = <-reciever.GetErrorChannel():
-		log.Fatalln("TFTP Receiver error:", err)
 	case recvdFile = <-recvChan:
-	case <-time.After(60 * time.Second):
-		return errors.Errorf("Timed out waiting to receive file over TFTP")
+	case <-time.After(tftpReceiveTimeout):
+		return nil, errors.Errorf("Timed out waiting to receive file '%s' over TFTP", ctx.TFTPFilename)
 	}
 
-	// Save the received file
-	if err = t.saveFile(backupTarget, &recvdFile); err != nil {
-		return err
+	if recvdFile.Err != nil {
+		return nil, recvdFile.Err
 	}
 
-	log.Printf("Completed backup target: '%s':'%s'\n", t.device.Name, backupTarget.Name)
+	return recvdFile.Data.Bytes(), nil
+}
 
-	return nil
+// runMacroSession runs the target's macro against the input and output of a shell session and returns the output
+// captured by captureCommand
+func (t *DeviceProcessor) runMacroSession(stdIn io.Writer, stdOut io.Reader, backupTarget *devices.DeviceClassTarget, ctx vmCtx, tr *transcript) ([]byte, error) {
+
+	if ctxRaw, err := ctx.Serialize(); err == nil {
+		tr.note("%s%s", transcriptCtxPrefix, ctxRaw)
+	}
+
+	expectTimeout := t.device.Class.TargetExpectTimeout(backupTarget)
+	if expectTimeout == 0 {
+		expectTimeout = defaultExpectTimeout
+	}
+
+	expect := newExpectSession(tr.reader(stdOut), tr.writer(stdIn))
+
+	if pager := t.device.Class.Pager; pager != nil && pager.Pattern != "" {
+		prompt, err := newMatcher(pager.Pattern, pager.Regex)
+		if err != nil {
+			return nil, errors.Errorf("Invalid pager pattern: %s", err)
+		}
+		expect.setPager(prompt, pager.Send)
+	}
+
+	var captured bytes.Buffer
+
+	vm, err := t.initVM(expect, ctx, &captured, expectTimeout, tr)
+	if err != nil {
+		return nil, errors.Errorf("Failed to init JavaScript VM: %s", err)
+	}
+
+	// Define the functions of the class's macro libraries before the macro runs
+	for _, library := range t.device.Class.Libraries {
+		if _, err := vm.Run(library.Script); err != nil {
+			return nil, errors.Errorf("JavaScript VM Runtime Error in macro library '%s': %s", library.Name, err)
+		}
+	}
+
+	if _, err := vm.Run(backupTarget.Macro); err != nil {
+		return nil, errors.Errorf("JavaScript VM Runtime Error: %s", err)
+	}
+
+	return captured.Bytes(), nil
 }
 
-func ottoExpect(vm *otto.Otto, expect *gexpect.ExpectIO) error {
+// ottoExpect registers the expect library with vm. expectTimeout is the timeout used by expect calls that don't
+// specify their own.
+func ottoExpect(vm *otto.Otto, expect *expectSession, captured *bytes.Buffer, expectTimeout time.Duration) error {
 
 	if err := vm.Set("dbgDump", func(call otto.FunctionCall) otto.Value {
 
@@ -268,24 +507,109 @@ func ottoExpect(vm *otto.Otto, expect *gexpect.ExpectIO) error {
 		return err
 	}
 
-	// function expect(val string) string {}
+	// function expect(pattern string, options object) object {}
+	// Waits for pattern to appear in the output. options may be a timeout in seconds or an object with 'timeout' and
+	// 'regex' properties. Returns an object holding the matched text ('match'), the regex capture groups ('groups')
+	// and the output consumed before the match ('before').
 	if err := vm.Set("expect", func(call otto.FunctionCall) otto.Value {
 
-		// TODO: Make timeout configurable
-		err := expect.ExpectTimeout(call.Argument(0).String(), 15*time.Second)
+		opts, err := parseExpectOptions(call.Argument(1), expectOptions{timeout: expectTimeout})
+		if err != nil {
+			panic(vm.MakeCustomError("ExpectError", err.Error()))
+		}
+
+		m, err := newMatcher(call.Argument(0).String(), opts.regex)
+		if err != nil {
+			panic(vm.MakeCustomError("ExpectError", err.Error()))
+		}
+
+		result, err := expect.expect(m, opts.timeout)
+		if err != nil {
+			panic(vm.MakeCustomError("ExpectError", err.Error()))
+		}
+
+		v, err := expectMatchToValue(call.Otto, result)
+		if err != nil {
+			panic(err.Error())
+		}
+
+		return v.Value()
+	}); err != nil {
+		return err
+	}
+
+	// function expectAny(patterns array, options object) object {}
+	// Waits for any of patterns to appear in the output, for handling prompts that vary between devices. Patterns are
+	// strings or objects with 'pattern', 'name' and 'regex' properties. options are the same as for expect. Returns the
+	// same object as expect with the 'index' and 'name' of the pattern that matched first.
+	if err := vm.Set("expectAny", func(call otto.FunctionCall) otto.Value {
+
+		opts, err := parseExpectOptions(call.Argument(1), expectOptions{timeout: expectTimeout})
 		if err != nil {
-			//fmt.Printf(">>> ExpectCollected >>>:\n%v<<< ExpectCollected <<<\n", spew.Sdump(expect.Collect()))
 			panic(vm.MakeCustomError("ExpectError", err.Error()))
 		}
 
+		matchers, names, err := parseExpectPatterns(call.Argument(0), opts.regex)
+		if err != nil {
+			panic(vm.MakeCustomError("ExpectError", err.Error()))
+		}
+
+		result, err := expect.expectAny(matchers, opts.timeout)
+		if err != nil {
+			panic(vm.MakeCustomError("ExpectError", err.Error()))
+		}
+
+		v, err := expectMatchToValue(call.Otto, result)
+		if err != nil {
+			panic(err.Error())
+		}
+		if err := v.Set("name", names[result.Index]); err != nil {
+			panic(err.Error())
+		}
+
+		return v.Value()
+	}); err != nil {
+		return err
+	}
+
+	// function startCapture() {}
+	// Starts collecting the output consumed by expect, expectAny and readLine
+	if err := vm.Set("startCapture", func(call otto.FunctionCall) otto.Value {
+		expect.startCapture()
 		return otto.Value{}
 	}); err != nil {
 		return err
 	}
 
+	// function stopCapture() string {}
+	// Stops collecting output and returns what was collected since startCapture
+	if err := vm.Set("stopCapture", func(call otto.FunctionCall) otto.Value {
+
+		output, err := expect.stopCapture()
+		if err != nil {
+			panic(vm.MakeCustomError("ExpectError", err.Error()))
+		}
+
+		v, err := call.Otto.ToValue(output)
+		if err != nil {
+			panic(err.Error())
+		}
+
+		return v
+	}); err != nil {
+		return err
+	}
+
+	// function readLine(options) string {}
+	// Returns the next line of output. options may give a timeout, as for expect.
 	if err := vm.Set("readLine", func(call otto.FunctionCall) otto.Value {
 
-		line, err := expect.ReadLine()
+		opts, err := parseExpectOptions(call.Argument(0), expectOptions{timeout: expectTimeout})
+		if err != nil {
+			panic(vm.MakeCustomError("ExpectError", err.Error()))
+		}
+
+		line, err := expect.readLine(opts.timeout)
 		if err != nil {
 			panic(vm.MakeCustomError("ExpectError", err.Error()))
 		}
@@ -302,7 +626,7 @@ func ottoExpect(vm *otto.Otto, expect *gexpect.ExpectIO) error {
 
 	if err := vm.Set("sendLine", func(call otto.FunctionCall) otto.Value {
 
-		err := expect.SendLine(call.Argument(0).String())
+		err := expect.sendLine(call.Argument(0).String())
 		if err != nil {
 			panic(vm.MakeCustomError("ExpectError", err.Error()))
 		}
@@ -312,5 +636,214 @@ func ottoExpect(vm *otto.Otto, expect *gexpect.ExpectIO) error {
 		return err
 	}
 
+	// function captureCommand(command string, prompt string, options object) string {}
+	// Sends command and waits for prompt. The command's output is returned and, for capture mode targets, becomes
+	// part of the saved config. options are the same as for expect, including the default timeout.
+	if err := vm.Set("captureCommand", func(call otto.FunctionCall) otto.Value {
+		command := call.Argument(0).String()
+
+		opts, err := parseExpectOptions(call.Argument(2), expectOptions{timeout: expectTimeout})
+		if err != nil {
+			panic(vm.MakeCustomError("ExpectError", err.Error()))
+		}
+
+		prompt, err := newMatcher(call.Argument(1).String(), opts.regex)
+		if err != nil {
+			panic(vm.MakeCustomError("ExpectError", err.Error()))
+		}
+
+		if err := expect.sendLine(command); err != nil {
+			panic(vm.MakeCustomError("ExpectError", err.Error()))
+		}
+
+		m, err := expect.expect(prompt, opts.timeout)
+		if err != nil {
+			panic(vm.MakeCustomError("ExpectError", err.Error()))
+		}
+
+		output := cleanCommandOutput(command, m.Before)
+		captured.WriteString(output)
+
+		v, err := call.Otto.ToValue(output)
+		if err != nil {
+			panic(err.Error())
+		}
+
+		return v
+	}); err != nil {
+		return err
+	}
+
 	return nil
 }
+
+// expectOptions are the settings accepted by the expect macro functions
+type expectOptions struct {
+	// timeout is how long to wait for a match, it's always positive
+	timeout time.Duration
+	// regex causes patterns to be treated as regular expressions
+	regex bool
+}
+
+// parseExpectOptions reads the options argument of an expect call. The argument may be omitted, a timeout in
+// seconds, or an object with 'timeout' and 'regex' properties. Settings that aren't given are taken from defaults.
+func parseExpectOptions(arg otto.Value, defaults expectOptions) (expectOptions, error) {
+	opts := defaults
+
+	var timeout otto.Value
+	switch {
+	case arg.IsUndefined() || arg.IsNull():
+		return opts, nil
+	case arg.IsNumber():
+		timeout = arg
+	case arg.IsObject():
+		obj := arg.Object()
+
+		var err error
+		if timeout, err = obj.Get("timeout"); err != nil {
+			return opts, err
+		}
+
+		regex, err := obj.Get("regex")
+		if err != nil {
+			return opts, err
+		}
+		if regex.IsDefined() {
+			if opts.regex, err = regex.ToBoolean(); err != nil {
+				return opts, errors.Errorf("Invalid regex option: %s", err)
+			}
+		}
+	default:
+		return opts, errors.Errorf("Invalid expect options '%s', expected a timeout in seconds or an object", arg)
+	}
+
+	if timeout.IsDefined() {
+		secs, err := timeout.ToFloat()
+		if err != nil || !timeout.IsNumber() || secs <= 0 {
+			return opts, errors.Errorf("Invalid timeout '%s', expected a positive number of seconds", timeout)
+		}
+		opts.timeout = time.Duration(secs * float64(time.Second))
+	}
+
+	return opts, nil
+}
+
+// expectMatchToValue converts the result of an expect into a JavaScript object
+func expectMatchToValue(vm *otto.Otto, m *expectMatch) (*otto.Object, error) {
+	groups, err := vm.Object(`[]`)
+	if err != nil {
+		return nil, err
+	}
+	for _, group := range m.Groups {
+		if _, err := groups.Call("push", group); err != nil {
+			return nil, err
+		}
+	}
+
+	result, err := vm.Object(`({})`)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range map[string]interface{}{
+		"before": m.Before,
+		"match":  m.Match,
+		"groups": groups,
+		"index":  m.Index,
+	} {
+		if err := result.Set(key, value); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// parseExpectPatterns reads the patterns argument of expectAny, an array whose elements are either pattern strings or
+// objects with 'pattern', 'name' and 'regex' properties. String patterns are treated as regular expressions if regex
+// is set. The names of the patterns are returned alongside their matchers, unnamed patterns have an empty name.
+func parseExpectPatterns(arg otto.Value, regex bool) ([]expectMatcher, []string, error) {
+	if arg.Class() != "Array" {
+		return nil, nil, errors.Errorf("Invalid patterns '%s', expected an array", arg)
+	}
+	list := arg.Object()
+
+	lengthVal, err := list.Get("length")
+	if err != nil {
+		return nil, nil, err
+	}
+	length, err := lengthVal.ToInteger()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	matchers := make([]expectMatcher, 0, length)
+	names := make([]string, 0, length)
+
+	for i := int64(0); i < length; i++ {
+		item, err := list.Get(strconv.FormatInt(i, 10))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		pattern, name, patternRegex := item, "", regex
+		if item.IsObject() {
+			obj := item.Object()
+			if pattern, err = obj.Get("pattern"); err != nil {
+				return nil, nil, err
+			}
+			if nameVal, err := obj.Get("name"); err == nil && nameVal.IsDefined() {
+				name = nameVal.String()
+			}
+			if regexVal, err := obj.Get("regex"); err == nil && regexVal.IsDefined() {
+				if patternRegex, err = regexVal.ToBoolean(); err != nil {
+					return nil, nil, errors.Errorf("Invalid regex option: %s", err)
+				}
+			}
+		}
+		if !pattern.IsString() {
+			return nil, nil, errors.Errorf("Invalid pattern %d: expected a string", i)
+		}
+
+		m, err := newMatcher(pattern.String(), patternRegex)
+		if err != nil {
+			return nil, nil, errors.Errorf("Invalid pattern %d: %s", i, err)
+		}
+
+		matchers = append(matchers, m)
+		names = append(names, name)
+	}
+
+	return matchers, names, nil
+}
+
+// tftpReceiveTimeout is how long to wait for a device to upload its config once the macro has completed
+const tftpReceiveTimeout = 60 * time.Second
+
+// cleanCommandOutput strips the echoed command and the trailing prompt line from the raw output of a command
+func cleanCommandOutput(command string, output string) string {
+	output = strings.Replace(output, "\r\n", "\n", -1)
+	output = strings.Replace(output, "\r", "", -1)
+
+	lines := strings.Split(output, "\n")
+
+	// The remainder of the line the command was entered on
+	if first := strings.TrimSpace(lines[0]); first == "" || strings.HasSuffix(first, strings.TrimSpace(command)) {
+		lines = lines[1:]
+	}
+
+	// The text preceding the prompt on its line
+	if len(lines) > 0 {
+		lines = lines[:len(lines)-1]
+	}
+
+	if len(lines) == 0 {
+		return ""
+	}
+
+	output = strings.Join(lines, "\n")
+	if !strings.HasSuffix(output, "\n") {
+		output += "\n"
+	}
+
+	return output
+}
@@ -0,0 +1,354 @@
+package device_processor
+
+import (
+	"github.com/samhug/ndm/auth"
+	"github.com/samhug/ndm/config"
+	"github.com/samhug/ndm/devices"
+	"github.com/samhug/ndm/internal/device_simulator"
+	"github.com/samhug/ndm/report"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testRunningConfig = "hostname router\n!\ninterface GigabitEthernet0/0\n ip address 192.0.2.1 255.255.255.0\n!\nend\n"
+const testStartupConfig = "hostname router\n!\nend\n"
+
+func startTestSimulator(t *testing.T, device device_simulator.Device) *device_simulator.Simulator {
+	if device.Hostname == "" {
+		device.Hostname = "router"
+	}
+	device.Username = "admin"
+	device.Password = "secret"
+
+	sim, err := device_simulator.NewSimulator(device)
+	require.NoError(t, err)
+	require.NoError(t, sim.Start())
+	return sim
+}
+
+// newTestDevice builds a device of class that connects to sim
+func newTestDevice(t *testing.T, sim *device_simulator.Simulator, class *devices.DeviceClass, password string, attributes map[string]string) *devices.Device {
+	provider := auth.NewStaticProvider()
+	require.NoError(t, provider.AddAuth("sim", "admin", password, attributes))
+	deviceAuth, err := provider.Lookup("sim")
+	require.NoError(t, err)
+
+	return &devices.Device{
+		Name:    "site-a/router",
+		Group:   "site-a",
+		Class:   class,
+		Address: sim.Addr(),
+		Auth:    deviceAuth,
+	}
+}
+
+func loadTestDeviceClass(t *testing.T, cfgStr string, name string) *devices.DeviceClass {
+	cfg, err := config.LoadString(cfgStr)
+	require.NoError(t, err)
+
+	classes, err := devices.LoadDeviceClasses(cfg.DeviceClasses, cfg.MacroLibraries)
+	require.NoError(t, err)
+
+	return classes[name]
+}
+
+func readBackup(t *testing.T, backupDir string, target string) string {
+	data, err := ioutil.ReadFile(path.Join(backupDir, "site-a/router", target+".conf"))
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestDeviceProcessor_ProcessTFTP(t *testing.T) {
+	reciever := startTestReceiver(t)
+	defer reciever.Stop()
+	// Devices upload to the receiver's port rather than the standard TFTP port
+	reciever.PublicAddr = reciever.Addr().String()
+
+	sim := startTestSimulator(t, device_simulator.Device{
+		Files: map[string]string{"startup-config": testStartupConfig, "running-config": testRunningConfig},
+	})
+	defer sim.Stop()
+
+	backupDir, err := ioutil.TempDir("", "ndm-backup")
+	require.NoError(t, err)
+	defer os.RemoveAll(backupDir)
+
+	device := newTestDevice(t, sim, loadExampleDeviceClasses(t)["cisco_isr"], "secret", nil)
+	results := NewDeviceProcessor(device, nil, nil, devices.RetryPolicy{}, backupDir).Process(reciever)
+
+	require.Len(t, results, 2)
+	for _, result := range results {
+		require.Equal(t, report.StatusSuccess, result.Status, result.Error)
+		require.Equal(t, 1, result.Attempts)
+	}
+	require.Equal(t, "running_config", results[0].Target)
+	require.Equal(t, len(testRunningConfig), results[0].Bytes)
+
+	require.Equal(t, testRunningConfig, readBackup(t, backupDir, "running_config"))
+	require.Equal(t, testStartupConfig, readBackup(t, backupDir, "startup_config"))
+
+	history := sim.History()
+	require.Len(t, history, 2)
+	require.True(t, strings.HasPrefix(history[0], "copy running-config tftp://"+reciever.PublicAddr+"/"), history[0])
+}
+
+func TestDeviceProcessor_ProcessEnable(t *testing.T) {
+	reciever := startTestReceiver(t)
+	defer reciever.Stop()
+	reciever.PublicAddr = reciever.Addr().String()
+
+	sim := startTestSimulator(t, device_simulator.Device{
+		Hostname:       "adtran",
+		EnablePassword: "enable-secret",
+		Files:          map[string]string{"startup-config": testStartupConfig, "running-config": testRunningConfig},
+	})
+	defer sim.Stop()
+
+	backupDir, err := ioutil.TempDir("", "ndm-backup")
+	require.NoError(t, err)
+	defer os.RemoveAll(backupDir)
+
+	device := newTestDevice(t, sim, loadExampleDeviceClasses(t)["adtran"], "secret", map[string]string{"enable_password": "enable-secret"})
+	p := NewDeviceProcessor(device, nil, nil, devices.RetryPolicy{}, backupDir)
+	p.SetTranscriptDir(path.Join(backupDir, "transcripts"))
+
+	for _, result := range p.Process(reciever) {
+		require.Equal(t, report.StatusSuccess, result.Status, result.Error)
+	}
+	require.Equal(t, testRunningConfig, readBackup(t, backupDir, "running_config"))
+
+	// The recorded session replays, without revealing the enable password
+	data, err := ioutil.ReadFile(path.Join(backupDir, "transcripts/site-a/router/running_config.transcript"))
+	require.NoError(t, err)
+	require.NotContains(t, string(data), "enable-secret")
+
+	_, err = ReplayMacro(device, "running_config", strings.NewReader(string(data)))
+	require.NoError(t, err)
+}
+
+func TestDeviceProcessor_ProcessCapture(t *testing.T) {
+	sim := startTestSimulator(t, device_simulator.Device{
+		Commands:   map[string]string{"show running-config": testRunningConfig},
+		PagerLines: 2,
+	})
+	defer sim.Stop()
+
+	backupDir, err := ioutil.TempDir("", "ndm-backup")
+	require.NoError(t, err)
+	defer os.RemoveAll(backupDir)
+
+	class := loadTestDeviceClass(t, `
+device_class "sim" {
+	pager {
+		pattern = " --More-- "
+	}
+	backup_target "running_config" {
+		mode = "capture"
+		macro = <<-MACRO
+			expect("#")
+			captureCommand("show running-config", "#")
+		MACRO
+	}
+}`, "sim")
+
+	device := newTestDevice(t, sim, class, "secret", nil)
+
+	// No TFTP receiver is needed in capture mode
+	results := NewDeviceProcessor(device, nil, nil, devices.RetryPolicy{}, backupDir).Process(nil)
+	require.Len(t, results, 1)
+	require.Equal(t, report.StatusSuccess, results[0].Status, results[0].Error)
+
+	require.Equal(t, testRunningConfig, readBackup(t, backupDir, "running_config"))
+	require.Equal(t, "site-a/router/running_config.conf", results[0].Path)
+}
+
+func TestDeviceProcessor_ProcessDisablePaging(t *testing.T) {
+	sim := startTestSimulator(t, device_simulator.Device{
+		Commands:   map[string]string{"show running-config": testRunningConfig},
+		PagerLines: 2,
+	})
+	defer sim.Stop()
+
+	backupDir, err := ioutil.TempDir("", "ndm-backup")
+	require.NoError(t, err)
+	defer os.RemoveAll(backupDir)
+
+	// Without a pager pattern, the output is only complete if paging was turned off
+	cfg := `
+macro_library "common" {
+	source = <<-SCRIPT
+		function backup() {
+			expect("#")
+			disablePaging("#")
+			captureCommand("show running-config", "#")
+		}
+	SCRIPT
+}
+
+device_class "sim" {
+	libraries = ["common"]
+	pager {
+		disable_command = "terminal length 0"
+	}
+	backup_target "running_config" {
+		mode = "capture"
+		macro = "backup()"
+	}
+}
+
+device_class "sim_no_pager" {
+	libraries = ["common"]
+	expect_timeout = "100ms"
+	backup_target "running_config" {
+		mode = "capture"
+		macro = "backup()"
+	}
+}`
+
+	device := newTestDevice(t, sim, loadTestDeviceClass(t, cfg, "sim"), "secret", nil)
+	results := NewDeviceProcessor(device, nil, nil, devices.RetryPolicy{}, backupDir).Process(nil)
+	require.Len(t, results, 1)
+	require.Equal(t, report.StatusSuccess, results[0].Status, results[0].Error)
+	require.Equal(t, testRunningConfig, readBackup(t, backupDir, "running_config"))
+	require.Equal(t, []string{"terminal length 0", "show running-config"}, sim.History())
+
+	// disablePaging does nothing for classes without a disable_command, so the capture stops at the pager prompt
+	device = newTestDevice(t, sim, loadTestDeviceClass(t, cfg, "sim_no_pager"), "secret", nil)
+	results = NewDeviceProcessor(device, nil, nil, devices.RetryPolicy{}, backupDir).Process(nil)
+	require.Len(t, results, 1)
+	require.Equal(t, report.StatusFailed, results[0].Status)
+	require.Equal(t, []string{"terminal length 0", "show running-config", "show running-config"}, sim.History())
+}
+
+func TestDeviceProcessor_ProcessFailure(t *testing.T) {
+	sim := startTestSimulator(t, device_simulator.Device{
+		Commands: map[string]string{"show running-config": testRunningConfig},
+	})
+	defer sim.Stop()
+
+	backupDir, err := ioutil.TempDir("", "ndm-backup")
+	require.NoError(t, err)
+	defer os.RemoveAll(backupDir)
+
+	class := loadTestDeviceClass(t, `
+device_class "sim" {
+	backup_target "running_config" {
+		mode = "capture"
+		expect_timeout = "1s"
+		macro = <<-MACRO
+			expect("#")
+			captureCommand("show startup-config", "#")
+			expect("never printed")
+		MACRO
+	}
+}`, "sim")
+
+	hostKeys, err := auth.NewHostKeyVerifier(auth.HostKeyPolicyStrict, "")
+	require.NoError(t, err)
+
+	// Wrong credentials aren't retried
+	device := newTestDevice(t, sim, class, "wrong", nil)
+	results := NewDeviceProcessor(device, nil, nil, devices.RetryPolicy{Retries: 1}, backupDir).Process(nil)
+	require.Equal(t, report.StatusFailed, results[0].Status)
+	require.Contains(t, results[0].Error, "Unable to connect")
+	require.Equal(t, 1, results[0].Attempts)
+
+	// Nor is a host key that doesn't match the pinned fingerprint
+	device = newTestDevice(t, sim, class, "secret", nil)
+	device.HostKey = "SHA256:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"
+	results = NewDeviceProcessor(device, nil, hostKeys, devices.RetryPolicy{Retries: 1}, backupDir).Process(nil)
+	require.Equal(t, report.StatusFailed, results[0].Status)
+	require.Contains(t, results[0].Error, "HOST KEY MISMATCH")
+	require.Equal(t, 1, results[0].Attempts)
+
+	// The right host key, but the macro times out waiting for output the device never prints, which is retried
+	device.HostKey = ssh.FingerprintSHA256(sim.HostKey())
+	results = NewDeviceProcessor(device, nil, hostKeys, devices.RetryPolicy{Retries: 1, Backoff: time.Millisecond}, backupDir).Process(nil)
+	require.Equal(t, report.StatusFailed, results[0].Status)
+	require.Contains(t, results[0].Error, "never printed")
+	require.Equal(t, 2, results[0].Attempts)
+
+	require.Equal(t, []string{"show startup-config", "show startup-config"}, sim.History())
+}
+
+func TestDeviceProcessor_ProcessVars(t *testing.T) {
+	sim := startTestSimulator(t, device_simulator.Device{
+		Hostname: "core-sw-01",
+		Commands: map[string]string{"show running-config": testRunningConfig, "show startup-config": testStartupConfig},
+	})
+	defer sim.Stop()
+
+	backupDir, err := ioutil.TempDir("", "ndm-backup")
+	require.NoError(t, err)
+	defer os.RemoveAll(backupDir)
+
+	class := loadTestDeviceClass(t, `
+device_class "base" {
+	vars {
+		prompt = "#"
+		command = "show running-config"
+	}
+	backup_target "config" {
+		mode = "capture"
+		macro = <<-MACRO
+			expect(ctx.vars.prompt)
+			captureCommand(ctx.vars.command, ctx.vars.prompt)
+		MACRO
+	}
+}
+
+device_class "sim" {
+	extends = "base"
+	vars {
+		command = "show startup-config"
+	}
+}`, "sim")
+
+	device := newTestDevice(t, sim, class, "secret", nil)
+	device.Vars = map[string]string{"prompt": "core-sw-01#"}
+
+	results := NewDeviceProcessor(device, nil, nil, devices.RetryPolicy{}, backupDir).Process(nil)
+	require.Len(t, results, 1)
+	require.Equal(t, report.StatusSuccess, results[0].Status, results[0].Error)
+
+	require.Equal(t, testStartupConfig, readBackup(t, backupDir, "config"))
+	require.Equal(t, []string{"show startup-config"}, sim.History())
+}
+
+func TestDeviceProcessor_ProcessDeviceVars(t *testing.T) {
+	sim := startTestSimulator(t, device_simulator.Device{
+		Commands: map[string]string{"show running-config vrf mgmt": testRunningConfig},
+	})
+	defer sim.Stop()
+
+	backupDir, err := ioutil.TempDir("", "ndm-backup")
+	require.NoError(t, err)
+	defer os.RemoveAll(backupDir)
+
+	class := loadTestDeviceClass(t, `
+device_class "sim" {
+	backup_target "running_config" {
+		mode = "capture"
+		macro = <<-MACRO
+			expect("#")
+			if (device.HasTag("vrf-aware")) {
+				captureCommand("show running-config vrf " + device.Vars.vrf, "#")
+			}
+		MACRO
+	}
+}`, "sim")
+
+	device := newTestDevice(t, sim, class, "secret", nil)
+	device.Vars = map[string]string{"vrf": "mgmt"}
+	device.Tags = []string{"core", "vrf-aware"}
+
+	results := NewDeviceProcessor(device, nil, nil, devices.RetryPolicy{}, backupDir).Process(nil)
+	require.Equal(t, report.StatusSuccess, results[0].Status, results[0].Error)
+	require.Equal(t, testRunningConfig, readBackup(t, backupDir, "running_config"))
+}
@@ -0,0 +1,329 @@
+package device_processor
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/go-errors/errors"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultExpectTimeout is how long expect waits for a pattern to appear in the session output
+const defaultExpectTimeout = 15 * time.Second
+
+// expectMatcher locates a pattern within session output
+type expectMatcher interface {
+	// find returns the index pairs identifying the match and its sub-matches, or nil if there is no match
+	find(data []byte) []int
+	String() string
+}
+
+// literalMatcher matches an exact string
+type literalMatcher string
+
+func (m literalMatcher) find(data []byte) []int {
+	i := bytes.Index(data, []byte(m))
+	if i < 0 {
+		return nil
+	}
+	return []int{i, i + len(m)}
+}
+
+func (m literalMatcher) String() string {
+	return fmt.Sprintf("'%s'", string(m))
+}
+
+// regexMatcher matches a regular expression
+type regexMatcher struct {
+	re *regexp.Regexp
+}
+
+func (m regexMatcher) find(data []byte) []int {
+	return m.re.FindSubmatchIndex(data)
+}
+
+func (m regexMatcher) String() string {
+	return fmt.Sprintf("/%s/", m.re)
+}
+
+// newMatcher constructs a matcher for pattern, which is treated as a regular expression if regex is set
+func newMatcher(pattern string, regex bool) (expectMatcher, error) {
+	if !regex {
+		return literalMatcher(pattern), nil
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, errors.Errorf("Invalid regular expression: %s", err)
+	}
+	return regexMatcher{re}, nil
+}
+
+// expectMatch is the result of a successful expect
+type expectMatch struct {
+	// Index identifies which of the matchers passed to expectAny matched
+	Index int
+	// Before is the output consumed prior to the match
+	Before string
+	// Match is the matched text
+	Match string
+	// Groups holds the text of each sub-match
+	Groups []string
+}
+
+// expectSession reads the output of a session in the background and matches patterns against it.
+//
+// It replaces gexpect's ExpectIO, which can't support the macro API safely. ExpectIO reads on the caller's goroutine,
+// so its timeouts leave the read running in another goroutine: it goes on consuming output after the timeout, output
+// that the next expect never sees, and races with that expect for the same buffer. A single reader goroutine that
+// owns the output is also what answering pager prompts needs, as they have to be answered while the macro is between
+// expects, and it makes expectAny, capturing consumed output and reporting the pending output on a timeout simple.
+type expectSession struct {
+	w      io.Writer
+	wmutex sync.Mutex
+	mutex  sync.Mutex
+	buf    bytes.Buffer
+	err    error
+	notify chan struct{}
+	// capture collects the output consumed while capturing is set
+	capture   bytes.Buffer
+	capturing bool
+	// pager answers the device's pager prompts, see setPager
+	pager *pagerHandler
+}
+
+// pagerHandler describes how to get past a device's pager prompt
+type pagerHandler struct {
+	prompt expectMatcher
+	keys   string
+	// erasing is set after a prompt has been answered, until the device has cleared it from the screen
+	erasing bool
+}
+
+// pagerErase matches the control sequences devices use to clear a pager prompt: backspaces over the prompt, ANSI
+// erase and cursor movement sequences and carriage returns
+var pagerErase = regexp.MustCompile(`^(?:\x08+ *\x08*|\x1b\[[0-9;?]*[A-Za-z]|\r)+`)
+
+func newExpectSession(r io.Reader, w io.Writer) *expectSession {
+	e := &expectSession{
+		w:      w,
+		notify: make(chan struct{}, 1),
+	}
+	go e.readLoop(r)
+	return e
+}
+
+func (e *expectSession) readLoop(r io.Reader) {
+	chunk := make([]byte, 4096)
+	for {
+		n, err := r.Read(chunk)
+
+		e.mutex.Lock()
+		e.buf.Write(e.stripPagerErase(chunk[:n]))
+		if err != nil {
+			e.err = err
+		}
+		keys := e.handlePager()
+		e.mutex.Unlock()
+
+		if keys != "" {
+			e.send(keys)
+		}
+
+		// Wake up anyone waiting on new output
+		select {
+		case e.notify <- struct{}{}:
+		default:
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// setPager makes the session answer pager prompts matching prompt by sending keys. The prompts, and the sequences
+// the device uses to erase them, are removed from the output so they don't interfere with matching or end up in
+// captured output.
+func (e *expectSession) setPager(prompt expectMatcher, keys string) {
+	e.mutex.Lock()
+	e.pager = &pagerHandler{prompt: prompt, keys: keys}
+	keys = e.handlePager()
+	e.mutex.Unlock()
+
+	if keys != "" {
+		e.send(keys)
+	}
+}
+
+// handlePager removes any pager prompts from the buffered output and returns the keys to send to answer them. It
+// must be called with the mutex held.
+func (e *expectSession) handlePager() string {
+	if e.pager == nil {
+		return ""
+	}
+
+	keys := ""
+	for {
+		data := e.buf.Bytes()
+		loc := e.pager.prompt.find(data)
+		if loc == nil || loc[0] == loc[1] {
+			return keys
+		}
+
+		remaining := append(append([]byte{}, data[:loc[0]]...), data[loc[1]:]...)
+		e.buf.Reset()
+		e.buf.Write(remaining)
+
+		e.pager.erasing = true
+		keys += e.pager.keys
+	}
+}
+
+// stripPagerErase removes the sequences clearing an answered pager prompt from the start of newly received output.
+// It must be called with the mutex held.
+func (e *expectSession) stripPagerErase(data []byte) []byte {
+	if e.pager == nil || !e.pager.erasing || len(data) == 0 {
+		return data
+	}
+
+	e.pager.erasing = false
+	if loc := pagerErase.FindIndex(data); loc != nil {
+		return data[loc[1]:]
+	}
+	return data
+}
+
+// expect waits for m to match the session output, consuming the output up to the end of the match. The timeout must
+// be positive, a session never waits indefinitely.
+func (e *expectSession) expect(m expectMatcher, timeout time.Duration) (*expectMatch, error) {
+	return e.expectAny([]expectMatcher{m}, timeout)
+}
+
+// expectAny waits for any of matchers to match the session output. The match that starts earliest in the output wins,
+// ties go to the matcher listed first.
+func (e *expectSession) expectAny(matchers []expectMatcher, timeout time.Duration) (*expectMatch, error) {
+	if len(matchers) == 0 {
+		return nil, errors.New("No patterns to expect")
+	}
+
+	if timeout <= 0 {
+		return nil, errors.Errorf("Invalid timeout %v waiting for %s", timeout, describeMatchers(matchers))
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		e.mutex.Lock()
+		data := e.buf.Bytes()
+		if index, loc := findFirst(matchers, data); loc != nil {
+			result := &expectMatch{
+				Index:  index,
+				Before: string(data[:loc[0]]),
+				Match:  string(data[loc[0]:loc[1]]),
+			}
+			for i := 2; i+1 < len(loc); i += 2 {
+				group := ""
+				if loc[i] >= 0 {
+					group = string(data[loc[i]:loc[i+1]])
+				}
+				result.Groups = append(result.Groups, group)
+			}
+			if e.capturing {
+				e.capture.Write(data[:loc[1]])
+			}
+			e.buf.Next(loc[1])
+			e.mutex.Unlock()
+			return result, nil
+		}
+		err := e.err
+		pending := string(data)
+		e.mutex.Unlock()
+
+		if err != nil {
+			return nil, errors.Errorf("Session ended while waiting for %s: %s\nOutput:\n%s", describeMatchers(matchers), err, pending)
+		}
+
+		select {
+		case <-e.notify:
+		case <-timer.C:
+			return nil, errors.Errorf("Timed out after %v waiting for %s.\nOutput:\n%s", timeout, describeMatchers(matchers), pending)
+		}
+	}
+}
+
+// findFirst returns the index and location of the earliest match of any of matchers in data
+func findFirst(matchers []expectMatcher, data []byte) (int, []int) {
+	first, firstLoc := -1, []int(nil)
+	for i, m := range matchers {
+		if loc := m.find(data); loc != nil && (firstLoc == nil || loc[0] < firstLoc[0]) {
+			first, firstLoc = i, loc
+		}
+	}
+	return first, firstLoc
+}
+
+func describeMatchers(matchers []expectMatcher) string {
+	if len(matchers) == 1 {
+		return matchers[0].String()
+	}
+
+	descriptions := make([]string, len(matchers))
+	for i, m := range matchers {
+		descriptions[i] = m.String()
+	}
+	return "any of " + strings.Join(descriptions, ", ")
+}
+
+// startCapture begins collecting the output consumed by expect and readLine, discarding anything collected by an
+// earlier capture that wasn't stopped
+func (e *expectSession) startCapture() {
+	e.mutex.Lock()
+	e.capture.Reset()
+	e.capturing = true
+	e.mutex.Unlock()
+}
+
+// stopCapture ends the capture and returns the output collected since startCapture
+func (e *expectSession) stopCapture() (string, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if !e.capturing {
+		return "", errors.New("stopCapture called without startCapture")
+	}
+
+	output := e.capture.String()
+	e.capture.Reset()
+	e.capturing = false
+
+	return output, nil
+}
+
+// readLine consumes and returns the next line of output, excluding the newline. It waits up to timeout for the line to
+// arrive, as expect does.
+func (e *expectSession) readLine(timeout time.Duration) (string, error) {
+	m, err := e.expect(literalMatcher("\n"), timeout)
+	if err != nil {
+		return "", err
+	}
+	return m.Before, nil
+}
+
+// send writes s to the session
+func (e *expectSession) send(s string) error {
+	e.wmutex.Lock()
+	defer e.wmutex.Unlock()
+
+	_, err := io.WriteString(e.w, s)
+	return err
+}
+
+// sendLine writes s to the session followed by a line break
+func (e *expectSession) sendLine(s string) error {
+	return e.send(s + "\r\n")
+}
@@ -0,0 +1,356 @@
+package device_processor
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/robertkrimen/otto"
+	"github.com/stretchr/testify/require"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestExpectSession_Expect(t *testing.T) {
+	r, w := io.Pipe()
+	var sent bytes.Buffer
+
+	e := newExpectSession(r, &sent)
+
+	go func() {
+		io.WriteString(w, "Welcome\r\nrouter>")
+		io.WriteString(w, " enable\r\nPassword: ")
+	}()
+
+	m, err := e.expect(literalMatcher(">"), time.Second)
+	require.NoError(t, err)
+	require.Equal(t, "Welcome\r\nrouter", m.Before)
+	require.Equal(t, ">", m.Match)
+
+	require.NoError(t, e.sendLine("enable"))
+	require.Equal(t, "enable\r\n", sent.String())
+
+	m, err = e.expect(literalMatcher("Password:"), time.Second)
+	require.NoError(t, err)
+	require.Equal(t, " enable\r\n", m.Before)
+
+	// Nothing more is coming
+	_, err = e.expect(literalMatcher("#"), 50*time.Millisecond)
+	require.Error(t, err)
+
+	w.Close()
+
+	_, err = e.expect(literalMatcher("#"), time.Second)
+	require.Error(t, err)
+}
+
+func TestExpectSession_ExpectAfterTimeout(t *testing.T) {
+	r, w := io.Pipe()
+	e := newExpectSession(r, &bytes.Buffer{})
+
+	// A timed out expect doesn't go on consuming output, whatever arrives afterwards is there for the next expect
+	_, err := e.expect(literalMatcher("#"), 50*time.Millisecond)
+	require.Error(t, err)
+
+	go io.WriteString(w, "router#")
+
+	m, err := e.expect(literalMatcher("router"), time.Second)
+	require.NoError(t, err)
+	require.Equal(t, "router", m.Match)
+
+	m, err = e.expect(literalMatcher("#"), time.Second)
+	require.NoError(t, err)
+	require.Equal(t, "", m.Before)
+}
+
+func TestExpectSession_ReadLine(t *testing.T) {
+	r, w := io.Pipe()
+	e := newExpectSession(r, &bytes.Buffer{})
+
+	go io.WriteString(w, "line one\nline two\n")
+
+	line, err := e.readLine(time.Second)
+	require.NoError(t, err)
+	require.Equal(t, "line one", line)
+
+	line, err = e.readLine(time.Second)
+	require.NoError(t, err)
+	require.Equal(t, "line two", line)
+
+	// A partial line times out
+	go io.WriteString(w, "no newline")
+	_, err = e.readLine(50 * time.Millisecond)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "Timed out")
+}
+
+func TestExpectSession_Regex(t *testing.T) {
+	r, w := io.Pipe()
+	e := newExpectSession(r, &bytes.Buffer{})
+
+	go io.WriteString(w, "Last login: never\r\ncore-sw-01(config)# ")
+
+	m, err := newMatcher(`([\w-]+)\((\w+)\)#`, true)
+	require.NoError(t, err)
+
+	result, err := e.expect(m, time.Second)
+	require.NoError(t, err)
+	require.Equal(t, "Last login: never\r\n", result.Before)
+	require.Equal(t, "core-sw-01(config)#", result.Match)
+	require.Equal(t, []string{"core-sw-01", "config"}, result.Groups)
+
+	_, err = newMatcher(`(unclosed`, true)
+	require.Error(t, err)
+}
+
+// runExpectMacro runs script in a VM with the expect library attached to a session that outputs output
+func runExpectMacro(t *testing.T, output string, expectTimeout time.Duration, script string) (otto.Value, error) {
+	r, w := io.Pipe()
+	e := newExpectSession(r, &bytes.Buffer{})
+	go io.WriteString(w, output)
+
+	vm := otto.New()
+	require.NoError(t, ottoExpect(vm, e, &bytes.Buffer{}, expectTimeout))
+
+	return vm.Run(script)
+}
+
+func TestOttoExpect_Options(t *testing.T) {
+	v, err := runExpectMacro(t, "router-7(config)#", time.Second, `
+		var m = expect("(\\S+)\\((\\w+)\\)#", {regex: true, timeout: 5});
+		m.groups[0] + "/" + m.groups[1] + "/" + m.match;
+	`)
+	require.NoError(t, err)
+	require.Equal(t, "router-7/config/router-7(config)#", v.String())
+
+	// The text before the match is returned
+	v, err = runExpectMacro(t, "Building configuration...\r\nR1#", time.Second, `expect("#").before`)
+	require.NoError(t, err)
+	require.Equal(t, "Building configuration...\r\nR1", v.String())
+
+	// Literal patterns aren't treated as regular expressions
+	_, err = runExpectMacro(t, "R1#", 50*time.Millisecond, `expect("R.#")`)
+	require.Error(t, err)
+
+	// A per-call timeout overrides the default
+	started := time.Now()
+	_, err = runExpectMacro(t, "R1>", time.Minute, `expect("#", {timeout: 0.05})`)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "ExpectError")
+	require.True(t, time.Since(started) < 10*time.Second)
+
+	_, err = runExpectMacro(t, "R1>", 50*time.Millisecond, `expect("#", 0.05)`)
+	require.Error(t, err)
+
+	for _, invalid := range []string{
+		`expect("#", {timeout: -1})`,
+		`expect("#", {timeout: 0})`,
+		`expect("#", 0)`,
+		`readLine({timeout: 0})`,
+		`captureCommand("show version", "#", {timeout: 0})`,
+		`expect("#", {timeout: "soon"})`,
+		`expect("#", "soon")`,
+		`expect("(", {regex: true})`,
+	} {
+		_, err = runExpectMacro(t, "R1#", time.Second, invalid)
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestExpectSession_ExpectAny(t *testing.T) {
+	r, w := io.Pipe()
+	e := newExpectSession(r, &bytes.Buffer{})
+
+	go io.WriteString(w, "router>enable\r\nPassword: ")
+
+	// The earliest match in the output wins, regardless of the order of the matchers
+	m, err := e.expectAny([]expectMatcher{literalMatcher("Password:"), literalMatcher("#"), literalMatcher(">")}, time.Second)
+	require.NoError(t, err)
+	require.Equal(t, 2, m.Index)
+	require.Equal(t, "router", m.Before)
+
+	m, err = e.expectAny([]expectMatcher{literalMatcher("#"), literalMatcher("Password:")}, time.Second)
+	require.NoError(t, err)
+	require.Equal(t, 1, m.Index)
+
+	_, err = e.expectAny([]expectMatcher{literalMatcher("#"), literalMatcher(">")}, 50*time.Millisecond)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "any of '#', '>'")
+
+	_, err = e.expectAny(nil, time.Second)
+	require.Error(t, err)
+}
+
+func TestOttoExpect_ExpectAny(t *testing.T) {
+	macro := `
+		var m = expectAny([
+			{name: "password", pattern: "Password:"},
+			{name: "enabled", pattern: "\\S+#", regex: true},
+			"[confirm]"
+		]);
+		m.index + ":" + m.name + ":" + m.match;
+	`
+
+	v, err := runExpectMacro(t, "Password: ", time.Second, macro)
+	require.NoError(t, err)
+	require.Equal(t, "0:password:Password:", v.String())
+
+	v, err = runExpectMacro(t, "core-sw-01#", time.Second, macro)
+	require.NoError(t, err)
+	require.Equal(t, "1:enabled:core-sw-01#", v.String())
+
+	v, err = runExpectMacro(t, "Proceed? [confirm]", time.Second, macro)
+	require.NoError(t, err)
+	require.Equal(t, "2::[confirm]", v.String())
+
+	// Options apply to all of the string patterns
+	v, err = runExpectMacro(t, "R1(config)#", time.Second, `expectAny(["\\(config\\)#", ">"], {regex: true}).index`)
+	require.NoError(t, err)
+	require.Equal(t, "0", v.String())
+
+	_, err = runExpectMacro(t, "R1>", 50*time.Millisecond, `expectAny(["#", "Password:"], 0.05)`)
+	require.Error(t, err)
+
+	for _, invalid := range []string{
+		`expectAny("#")`,
+		`expectAny([])`,
+		`expectAny([{name: "prompt"}])`,
+		`expectAny(["("], {regex: true})`,
+	} {
+		_, err = runExpectMacro(t, "R1#", time.Second, invalid)
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestExpectSession_Capture(t *testing.T) {
+	r, w := io.Pipe()
+	e := newExpectSession(r, &bytes.Buffer{})
+
+	go io.WriteString(w, "R1#show clock\r\n12:00:00 UTC\r\nR1#exit\r\n")
+
+	_, err := e.expect(literalMatcher("#"), time.Second)
+	require.NoError(t, err)
+
+	e.startCapture()
+	_, err = e.expect(literalMatcher("#"), time.Second)
+	require.NoError(t, err)
+
+	output, err := e.stopCapture()
+	require.NoError(t, err)
+	require.Equal(t, "show clock\r\n12:00:00 UTC\r\nR1#", output)
+
+	// Output consumed after the capture stopped isn't collected
+	_, err = e.readLine(time.Second)
+	require.NoError(t, err)
+
+	_, err = e.stopCapture()
+	require.Error(t, err)
+}
+
+func TestOttoExpect_Capture(t *testing.T) {
+	output := "R1#show version\r\nCisco IOS Software, Version 15.4(3)M2\r\nuptime is 5 weeks\r\nR1#"
+
+	v, err := runExpectMacro(t, output, time.Second, `
+		expect("#");
+		startCapture();
+		expect("#");
+		var version = /Version ([\d.()A-Z]+)/.exec(stopCapture())[1];
+		version;
+	`)
+	require.NoError(t, err)
+	require.Equal(t, "15.4(3)M2", v.String())
+
+	_, err = runExpectMacro(t, output, time.Second, `stopCapture()`)
+	require.Error(t, err)
+}
+
+func TestOttoExpect_CaptureCommandTimeout(t *testing.T) {
+	// captureCommand waits as long as expect does unless it's given a timeout
+	started := time.Now()
+	_, err := runExpectMacro(t, "R1>", 50*time.Millisecond, `captureCommand("show version", "#")`)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "Timed out after 50ms")
+	require.True(t, time.Since(started) < 10*time.Second)
+
+	_, err = runExpectMacro(t, "R1>", time.Minute, `captureCommand("show version", "#", {timeout: 0.05})`)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "Timed out after 50ms")
+}
+
+// pagedDevice simulates a device that pages its output, waiting for a keystroke after each pager prompt. The result,
+// nil if the expected keys were pressed, is sent on done.
+func pagedDevice(r io.Reader, w io.WriteCloser, pages []string, prompt string, erase string, done chan<- error) {
+	key := make([]byte, 1)
+	for i, page := range pages {
+		if i > 0 {
+			if _, err := r.Read(key); err != nil {
+				done <- err
+				return
+			}
+			if string(key) != " " {
+				done <- fmt.Errorf("expected a space to continue, got %q", key)
+				return
+			}
+			io.WriteString(w, erase)
+		}
+		io.WriteString(w, page)
+		if i < len(pages)-1 {
+			io.WriteString(w, prompt)
+		}
+	}
+	io.WriteString(w, "\r\nR1#")
+	done <- nil
+}
+
+func TestExpectSession_Pager(t *testing.T) {
+	outR, outW := io.Pipe()
+	inR, inW := io.Pipe()
+
+	e := newExpectSession(outR, inW)
+	e.setPager(literalMatcher(" --More-- "), " ")
+
+	pages := []string{"hostname R1\r\n!", "\r\ninterface Gi0/0\r\n ip address 10.0.0.1 255.255.255.0", "\r\nend"}
+	done := make(chan error, 1)
+	go pagedDevice(inR, outW, pages, " --More-- ", "\x08\x08\x08\x08\x08\x08\x08\x08\x08\x08          \x08\x08\x08\x08\x08\x08\x08\x08\x08\x08", done)
+
+	e.startCapture()
+	_, err := e.expect(literalMatcher("R1#"), time.Second)
+	require.NoError(t, err)
+
+	output, err := e.stopCapture()
+	require.NoError(t, err)
+	require.Equal(t, "hostname R1\r\n!\r\ninterface Gi0/0\r\n ip address 10.0.0.1 255.255.255.0\r\nend\r\nR1#", output)
+	require.NoError(t, <-done)
+}
+
+func TestExpectSession_PagerRegex(t *testing.T) {
+	outR, outW := io.Pipe()
+	inR, inW := io.Pipe()
+
+	e := newExpectSession(outR, inW)
+
+	// Prompts already buffered when the pager is set are answered too
+	m, err := newMatcher(`-- MORE --, next page: Space, next line: Enter, quit: Control-C`, true)
+	require.NoError(t, err)
+
+	pages := []string{"vlan 1\r\n", "   name \"DEFAULT_VLAN\"\r\n"}
+	done := make(chan error, 1)
+	go pagedDevice(inR, outW, pages, "-- MORE --, next page: Space, next line: Enter, quit: Control-C", "\x1b[2K\r", done)
+	time.Sleep(10 * time.Millisecond)
+	e.setPager(m, " ")
+
+	result, err := e.expect(literalMatcher("R1#"), time.Second)
+	require.NoError(t, err)
+	require.Equal(t, "vlan 1\r\n   name \"DEFAULT_VLAN\"\r\n\r\n", result.Before)
+	require.NoError(t, <-done)
+}
+
+func TestCleanCommandOutput(t *testing.T) {
+	raw := " show running-config\r\nBuilding configuration...\r\n\r\nhostname R1\r\nend\r\n\r\nR1"
+	require.Equal(t, "Building configuration...\n\nhostname R1\nend\n", cleanCommandOutput("show running-config", raw))
+
+	// Devices that don't echo the command
+	raw = "\r\nhostname R1\r\nR1"
+	require.Equal(t, "hostname R1\n", cleanCommandOutput("show running-config", raw))
+
+	require.Equal(t, "", cleanCommandOutput("show running-config", " show running-config\r\nR1"))
+}
@@ -0,0 +1,177 @@
+package device_processor
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/go-errors/errors"
+	"github.com/pkg/sftp"
+	"github.com/samhug/ndm/config"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// fetchFile downloads the file at remotePath from the device over an established SSH connection
+func fetchFile(client *ssh.Client, protocol string, remotePath string) ([]byte, error) {
+	switch protocol {
+	case config.FetchSFTP:
+		return fetchSFTP(client, remotePath)
+	case config.FetchSCP:
+		return fetchSCP(client, remotePath)
+	default:
+		return nil, errors.Errorf("Unsupported fetch protocol '%s'", protocol)
+	}
+}
+
+func fetchSFTP(client *ssh.Client, remotePath string) ([]byte, error) {
+	sftpClient, err := sftp.NewClient(client)
+	if err != nil {
+		return nil, errors.Errorf("Failed to start SFTP session: %s", err)
+	}
+	defer sftpClient.Close()
+
+	f, err := sftpClient.Open(remotePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var buf bytes.Buffer
+	if _, err := f.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func fetchSCP(client *ssh.Client, remotePath string) ([]byte, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, errors.Errorf("Failed to create SCP session: %s", err)
+	}
+	defer session.Close()
+
+	stdOut, err := session.StdoutPipe()
+	if err != nil {
+		return nil, errors.Errorf("Failed to attach to Stdout: %s", err)
+	}
+
+	stdIn, err := session.StdinPipe()
+	if err != nil {
+		return nil, errors.Errorf("Failed to attach to Stdin: %s", err)
+	}
+
+	// Run scp in 'from' (source) mode on the device
+	if err = session.Start("scp -f " + shellQuote(remotePath)); err != nil {
+		return nil, errors.Errorf("Failed to start scp: %s", err)
+	}
+
+	data, err := scpReceive(stdOut, stdIn)
+	stdIn.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = session.Wait(); err != nil {
+		return nil, errors.Errorf("scp exited with an error: %s", err)
+	}
+
+	return data, nil
+}
+
+// scpReceive implements the sink side of the SCP protocol for a single file
+func scpReceive(r io.Reader, w io.Writer) ([]byte, error) {
+	reader := bufio.NewReader(r)
+
+	// Signal that we're ready to receive
+	if _, err := w.Write([]byte{0}); err != nil {
+		return nil, err
+	}
+
+	for {
+		line, err := scpReadMessage(reader)
+		if err != nil {
+			return nil, err
+		}
+
+		switch line[0] {
+		case 'T':
+			// File times, acknowledge and wait for the file itself
+			if _, err := w.Write([]byte{0}); err != nil {
+				return nil, err
+			}
+			continue
+		case 'C':
+		default:
+			return nil, errors.Errorf("Unexpected SCP message: %q", line)
+		}
+
+		// C<mode> <length> <filename>
+		fields := strings.SplitN(line[1:], " ", 3)
+		if len(fields) != 3 {
+			return nil, errors.Errorf("Malformed SCP file header: %q", line)
+		}
+		size, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil || size < 0 {
+			return nil, errors.Errorf("Malformed SCP file length: %q", line)
+		}
+
+		if _, err := w.Write([]byte{0}); err != nil {
+			return nil, err
+		}
+
+		data := make([]byte, size)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, errors.Errorf("Failed reading file contents: %s", err)
+		}
+
+		// The contents are followed by a status byte
+		if err := scpReadStatus(reader); err != nil {
+			return nil, err
+		}
+
+		if _, err := w.Write([]byte{0}); err != nil {
+			return nil, err
+		}
+
+		return data, nil
+	}
+}
+
+// scpReadMessage reads a protocol message, converting warnings and errors sent by the remote end into errors
+func scpReadMessage(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return "", errors.Errorf("Failed reading SCP message: %s", err)
+	}
+	line = strings.TrimSuffix(line, "\n")
+
+	if len(line) == 0 {
+		return "", errors.New("Empty SCP message")
+	}
+	if line[0] == 1 || line[0] == 2 {
+		return "", errors.Errorf("scp: %s", line[1:])
+	}
+
+	return line, nil
+}
+
+func scpReadStatus(reader *bufio.Reader) error {
+	b, err := reader.ReadByte()
+	if err != nil {
+		return errors.Errorf("Failed reading SCP status: %s", err)
+	}
+	if b == 0 {
+		return nil
+	}
+
+	msg, _ := reader.ReadString('\n')
+	return errors.Errorf("scp: %s", strings.TrimSpace(msg))
+}
+
+// shellQuote quotes s for use as a single argument in a POSIX shell command
+func shellQuote(s string) string {
+	return fmt.Sprintf("'%s'", strings.Replace(s, "'", `'\''`, -1))
+}
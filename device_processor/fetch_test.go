@@ -0,0 +1,41 @@
+package device_processor
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestSCPReceive(t *testing.T) {
+	remote := "C0644 19 config.boot\nfirewall {\n}\nsystem\x00"
+
+	var sent bytes.Buffer
+	data, err := scpReceive(strings.NewReader(remote), &sent)
+	require.NoError(t, err)
+	require.Equal(t, "firewall {\n}\nsystem", string(data))
+
+	// One acknowledgement to start, one for the header, and one for the contents
+	require.Equal(t, []byte{0, 0, 0}, sent.Bytes())
+}
+
+func TestSCPReceive_Times(t *testing.T) {
+	remote := "T1549000000 0 1549000000 0\nC0600 3 a.conf\nabc\x00"
+
+	data, err := scpReceive(strings.NewReader(remote), &bytes.Buffer{})
+	require.NoError(t, err)
+	require.Equal(t, "abc", string(data))
+}
+
+func TestSCPReceive_RemoteError(t *testing.T) {
+	remote := "\x01scp: /config/config.boot: No such file or directory\n"
+
+	_, err := scpReceive(strings.NewReader(remote), &bytes.Buffer{})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "No such file or directory")
+}
+
+func TestShellQuote(t *testing.T) {
+	require.Equal(t, `'/config/config.boot'`, shellQuote("/config/config.boot"))
+	require.Equal(t, `'/tmp/it'\''s'`, shellQuote("/tmp/it's"))
+}
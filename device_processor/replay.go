@@ -0,0 +1,230 @@
+package device_processor
+
+import (
+	"encoding/json"
+	"github.com/go-errors/errors"
+	"github.com/samhug/ndm/config"
+	"github.com/samhug/ndm/devices"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"strings"
+	"sync"
+)
+
+// ReplayResult describes a macro run against a recorded transcript
+type ReplayResult struct {
+	// Sent is everything the macro sent to the device
+	Sent string
+	// Captured is the output collected by captureCommand
+	Captured []byte
+}
+
+// ReplayMacro runs the macro of one of the device's backup targets against a transcript recorded with
+// --transcript-dir rather than a live device. The output recorded in the transcript is played back to the macro, and
+// everything the macro sends must match the input that was recorded. If the transcript holds several sessions, for
+// example because the target was retried, the last one is replayed. Auth attributes resolve to the mask used in
+// transcripts so they match the recorded input.
+func ReplayMacro(device *devices.Device, targetName string, transcriptData io.Reader) (*ReplayResult, error) {
+	backupTarget, ok := device.Class.Targets[targetName]
+	if !ok {
+		return nil, errors.Errorf("Backup target '%s' doesn't exist", targetName)
+	}
+	if backupTarget.Mode == config.BackupModeFetch {
+		return nil, errors.Errorf("Backup target '%s' fetches its config and has no macro to replay", targetName)
+	}
+
+	entries, err := parseTranscript(transcriptData)
+	if err != nil {
+		return nil, errors.Errorf("Unable to parse transcript: %s", err)
+	}
+
+	entries, ctx, err := lastTranscriptSession(entries)
+	if err != nil {
+		return nil, err
+	}
+
+	// Transcripts recorded before vars were supported don't include them
+	if ctx.Vars == nil {
+		ctx.Vars = device.MacroVars()
+	}
+
+	replayDevice := *device
+	replayDevice.Auth = replayAuth{}
+	p := &DeviceProcessor{device: &replayDevice}
+
+	session := newReplaySession(entries)
+	captured, err := p.runMacroSession(session, session, backupTarget, ctx, nil)
+	session.Close()
+
+	result := &ReplayResult{Sent: session.sentData(), Captured: captured}
+	if err != nil {
+		return result, err
+	}
+
+	if remaining := session.remainingInput(); remaining != "" {
+		return result, errors.Errorf("The macro finished without sending all of the recorded input, %q remains", remaining)
+	}
+
+	return result, nil
+}
+
+// lastTranscriptSession returns the entries of the last session in a transcript, along with the ctx it was run with
+func lastTranscriptSession(entries []transcriptEntry) ([]transcriptEntry, vmCtx, error) {
+	var ctx vmCtx
+
+	start := -1
+	for i, entry := range entries {
+		if entry.Direction == transcriptNote && strings.HasPrefix(entry.Data, transcriptCtxPrefix) {
+			start = i
+		}
+	}
+	if start < 0 {
+		return nil, ctx, errors.New("The transcript doesn't contain a macro session")
+	}
+
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(entries[start].Data, transcriptCtxPrefix)), &ctx); err != nil {
+		return nil, ctx, errors.Errorf("Invalid ctx in transcript: %s", err)
+	}
+
+	return entries[start+1:], ctx, nil
+}
+
+// replayAuth stands in for a device's credentials during a replay
+type replayAuth struct{}
+
+func (replayAuth) GetSSHClientConfig() (*ssh.ClientConfig, error) {
+	return nil, errors.New("Replayed sessions don't connect to a device")
+}
+
+// GetAttribute returns the mask that replaced the attribute's value in the transcript
+func (replayAuth) GetAttribute(attr_name string) (string, error) {
+	return transcriptMask, nil
+}
+
+func newReplaySession(entries []transcriptEntry) *replaySession {
+	s := &replaySession{entries: entries}
+	s.cond = sync.NewCond(&s.mutex)
+	s.sendPos = s.nextEntry(0, transcriptSent)
+	return s
+}
+
+// replaySession plays back the output recorded in a transcript and checks the input written to it against the
+// recorded input. Output recorded after an input is held back until that input has been written.
+type replaySession struct {
+	mutex   sync.Mutex
+	cond    *sync.Cond
+	entries []transcriptEntry
+	// recvPos and recvOffset locate the next output to play back
+	recvPos    int
+	recvOffset int
+	// sendPos and sendOffset locate the next input expected
+	sendPos    int
+	sendOffset int
+	sent       strings.Builder
+	closed     bool
+}
+
+// nextEntry returns the index of the first entry at or after i in the given direction, or len(entries) if there is none
+func (s *replaySession) nextEntry(i int, direction string) int {
+	for ; i < len(s.entries); i++ {
+		if s.entries[i].Direction == direction {
+			return i
+		}
+	}
+	return len(s.entries)
+}
+
+func (s *replaySession) Read(p []byte) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for {
+		if s.closed {
+			return 0, io.EOF
+		}
+
+		s.recvPos = s.nextEntry(s.recvPos, transcriptRecv)
+		if s.recvPos >= len(s.entries) {
+			return 0, io.EOF
+		}
+
+		if s.recvPos < s.sendPos {
+			data := s.entries[s.recvPos].Data[s.recvOffset:]
+			n := copy(p, data)
+			s.recvOffset += n
+			if s.recvOffset == len(s.entries[s.recvPos].Data) {
+				s.recvPos++
+				s.recvOffset = 0
+			}
+			return n, nil
+		}
+
+		// The device is waiting for input
+		s.cond.Wait()
+	}
+}
+
+func (s *replaySession) Write(p []byte) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	defer s.cond.Broadcast()
+
+	s.sent.Write(p)
+
+	data := string(p)
+	for data != "" {
+		if s.sendPos >= len(s.entries) {
+			return 0, errors.Errorf("Replay: the macro sent %q after all of the recorded input", data)
+		}
+
+		expected := s.entries[s.sendPos].Data[s.sendOffset:]
+		n := len(expected)
+		if len(data) < n {
+			n = len(data)
+		}
+		if data[:n] != expected[:n] {
+			return 0, errors.Errorf("Replay: the macro sent %q, the transcript expected %q", data, expected)
+		}
+
+		data = data[n:]
+		s.sendOffset += n
+		if s.sendOffset == len(s.entries[s.sendPos].Data) {
+			s.sendPos = s.nextEntry(s.sendPos+1, transcriptSent)
+			s.sendOffset = 0
+		}
+	}
+
+	return len(p), nil
+}
+
+func (s *replaySession) Close() error {
+	s.mutex.Lock()
+	s.closed = true
+	s.cond.Broadcast()
+	s.mutex.Unlock()
+	return nil
+}
+
+func (s *replaySession) sentData() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.sent.String()
+}
+
+// remainingInput returns the recorded input that hasn't been written yet
+func (s *replaySession) remainingInput() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var remaining []string
+	for i := s.sendPos; i < len(s.entries); i = s.nextEntry(i+1, transcriptSent) {
+		data := s.entries[i].Data
+		if i == s.sendPos {
+			data = data[s.sendOffset:]
+		}
+		remaining = append(remaining, data)
+	}
+	return strings.Join(remaining, "")
+}
+
+var _ io.ReadWriteCloser = &replaySession{}
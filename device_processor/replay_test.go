@@ -0,0 +1,107 @@
+package device_processor
+
+import (
+	"bytes"
+	"github.com/samhug/ndm/config"
+	"github.com/samhug/ndm/devices"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+// loadExampleDeviceClasses loads the device classes shipped with the example configuration
+func loadExampleDeviceClasses(t *testing.T) map[string]*devices.DeviceClass {
+	cfg, err := config.LoadFile("../config.example.hcl")
+	require.NoError(t, err)
+
+	classes, err := devices.LoadDeviceClasses(cfg.DeviceClasses, cfg.MacroLibraries)
+	require.NoError(t, err)
+
+	return classes
+}
+
+// replayTestTranscript replays a transcript from the test_data directory against a device of the given class
+func replayTestTranscript(t *testing.T, class *devices.DeviceClass, target string, transcriptName string) (*ReplayResult, error) {
+	f, err := os.Open(path.Join("test_data", transcriptName))
+	require.NoError(t, err)
+	defer f.Close()
+
+	return ReplayMacro(&devices.Device{Name: "replay", Class: class}, target, f)
+}
+
+func TestReplayMacro_ExampleClasses(t *testing.T) {
+	classes := loadExampleDeviceClasses(t)
+
+	result, err := replayTestTranscript(t, classes["cisco_isr"], "startup_config", "cisco_isr.startup_config.transcript")
+	require.NoError(t, err)
+	require.Equal(t, "copy startup-config tftp://192.168.100.10/2c1e6f0e-5b7b-4f3c-9c1a-6f1f3e0b9d4a\r\n\r\n\r\n", result.Sent)
+
+	// Only the last session of a retried target is replayed, and secrets match their masked values
+	result, err = replayTestTranscript(t, classes["adtran"], "running_config", "adtran.running_config.transcript")
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(result.Sent, "enable\r\n********\r\ncopy running-config tftp\r\n"), result.Sent)
+}
+
+func TestReplayMacro_Mismatch(t *testing.T) {
+	classes := loadExampleDeviceClasses(t)
+
+	// The cisco_isr running_config macro sends a different command to the one recorded
+	result, err := replayTestTranscript(t, classes["cisco_isr"], "running_config", "cisco_isr.startup_config.transcript")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "the transcript expected")
+	require.Contains(t, result.Sent, "copy running-config")
+
+	_, err = replayTestTranscript(t, classes["cisco_isr"], "nonexistent", "cisco_isr.startup_config.transcript")
+	require.Error(t, err)
+}
+
+func TestReplayMacro_ShortSecretInCommand(t *testing.T) {
+	class := &devices.DeviceClass{Targets: map[string]*devices.DeviceClassTarget{}}
+	target, err := devices.NewDeviceClassTarget("running", &config.BackupTargetConfig{
+		Macro: `expect(">"); sendLine("enable " + getAuthAttr("enable_password")); expect("#")`,
+		Mode:  config.BackupModeCapture,
+	})
+	require.NoError(t, err)
+	class.Targets["running"] = target
+
+	// Record a session that sends a short enable password as part of a command
+	var recorded bytes.Buffer
+	tr := newTranscript(nopWriteCloser{&recorded})
+	tr.note("%s{}", transcriptCtxPrefix)
+	tr.addSecret("1")
+	tr.record(transcriptRecv, []byte("R1>"))
+	tr.record(transcriptSent, []byte("enable 1\r\n"))
+	tr.record(transcriptRecv, []byte("R1#"))
+	require.NoError(t, tr.Close())
+	require.NotContains(t, recorded.String(), "enable 1")
+
+	result, err := ReplayMacro(&devices.Device{Name: "replay", Class: class}, "running", &recorded)
+	require.NoError(t, err)
+	require.Equal(t, "enable ********\r\n", result.Sent)
+}
+
+func TestReplayMacro_Incomplete(t *testing.T) {
+	class := &devices.DeviceClass{Targets: map[string]*devices.DeviceClassTarget{}}
+	target, err := devices.NewDeviceClassTarget("short", &config.BackupTargetConfig{Macro: `expect("#")`, Mode: config.BackupModeCapture})
+	require.NoError(t, err)
+	class.Targets["short"] = target
+
+	// The macro stops before sending the recorded input
+	_, err = replayTestTranscript(t, class, "short", "cisco_isr.startup_config.transcript")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "without sending all of the recorded input")
+}
+
+func TestParseTranscript_Invalid(t *testing.T) {
+	for _, invalid := range []string{
+		`2018-03-01T02:00:00.000000Z < unquoted`,
+		`2018-03-01T02:00:00.000000Z ? "data"`,
+		`yesterday < "data"`,
+		`2018-03-01T02:00:00.000000Z`,
+	} {
+		_, err := parseTranscript(strings.NewReader(invalid))
+		require.Error(t, err, invalid)
+	}
+}
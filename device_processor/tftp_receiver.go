@@ -7,104 +7,227 @@ import (
 	"github.com/pkg/errors"
 	"io"
 	"log"
-	"os"
+	"net"
 	"strings"
 	"sync"
 	"time"
 )
 
+// DefaultTFTPListenAddr is the address the TFTP server binds to unless TFTPReceiver.ListenAddr is changed
+const DefaultTFTPListenAddr = ":69"
+
+// tftpReadyProbe is the file the receiver requests from its own server to learn that the server is serving
+const tftpReadyProbe = "ndm-ready-probe"
+
+// ReceivedFile is the outcome of an upload. If the transfer failed Err is set, and is a *TFTPTransferError.
 type ReceivedFile struct {
 	Name string
 	Data bytes.Buffer
+	Err  error
 }
 
-func NewTFTPReceiver(publicAddr string) *TFTPReceiver {
+// TFTPTransferError describes an upload that failed part way through, attributed to the device that was expected to
+// send the file
+type TFTPTransferError struct {
+	Device   string
+	Filename string
+	Err      error
+}
 
-	// Create the error channel
-	errChannel := make(chan error, 3)
+func (e *TFTPTransferError) Error() string {
+	return fmt.Sprintf("TFTP transfer of '%s' from device '%s' failed: %s", e.Filename, e.Device, e.Err)
+}
 
+// tftpHook routes an expected upload to the device waiting for it
+type tftpHook struct {
+	device string
+	ch     chan ReceivedFile
+}
+
+func NewTFTPReceiver(publicAddr string) *TFTPReceiver {
 	return &TFTPReceiver{
 		PublicAddr: publicAddr,
-		recvHooks:  make(map[string]chan ReceivedFile),
+		ListenAddr: DefaultTFTPListenAddr,
+		recvHooks:  make(map[string]*tftpHook),
 		mutex:      &sync.Mutex{},
-		errChannel: errChannel,
 	}
 }
 
 type TFTPReceiver struct {
+	// PublicAddr is the address devices are told to upload to
 	PublicAddr string
+	// ListenAddr is the local address the server binds to
+	ListenAddr string
 	server     *tftp.Server
-	recvHooks  map[string]chan ReceivedFile
+	conn       *net.UDPConn
+	serving    chan struct{}
+	ready      sync.Once
+	recvHooks  map[string]*tftpHook
 	mutex      *sync.Mutex
-	errChannel chan error
+	err        error
 }
 
-func (r *TFTPReceiver) GetErrorChannel() chan error {
-	return r.errChannel
+// Err returns the reason the server isn't running, or nil if it started successfully
+func (r *TFTPReceiver) Err() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.err
 }
 
-func (r *TFTPReceiver) ExpectFile(name string, ch chan ReceivedFile) {
+// Addr returns the local address the server is listening on, or nil if it isn't running
+func (r *TFTPReceiver) Addr() net.Addr {
+	if r.conn == nil {
+		return nil
+	}
+	return r.conn.LocalAddr()
+}
+
+// ExpectFile registers an upload of the named file by device and returns the channel the outcome will be delivered on.
+// The hook is removed once the file has been received, or by calling CancelFile.
+func (r *TFTPReceiver) ExpectFile(name string, device string) <-chan ReceivedFile {
+	ch := make(chan ReceivedFile, 1)
+
 	r.mutex.Lock()
-	r.recvHooks[name] = ch
+	r.recvHooks[name] = &tftpHook{device: device, ch: ch}
 	r.mutex.Unlock()
+
+	return ch
 }
 
-func (r *TFTPReceiver) Run() {
-	// Launch a TFTP server to recieve the incoming file
-	r.server = tftp.NewServer(nil, r.tftpRecvHandler)
-	r.server.SetTimeout(5 * time.Second)
+// CancelFile removes the hook for the named file, any later upload of it is rejected
+func (r *TFTPReceiver) CancelFile(name string) {
+	r.mutex.Lock()
+	delete(r.recvHooks, name)
+	r.mutex.Unlock()
+}
 
+// Run binds to ListenAddr and starts serving in the background. If the server can't be started the error is returned
+// and also recorded, so it's available from Err to the devices that depend on the server.
+func (r *TFTPReceiver) Run() error {
 	log.Println("Starting TFTP Server...")
 
-	go func(server *tftp.Server, errChannel chan error) {
-		err := server.ListenAndServe(":69") // blocks until s.Shutdown() is called
-		if err != nil {
-			errChannel <- errors.Errorf("TFTP Server: %s", err)
-		}
-	}(r.server, r.errChannel)
+	conn, err := r.listen()
+	if err != nil {
+		err = errors.Errorf("TFTP Server: unable to listen on %s: %s", r.ListenAddr, err)
+
+		r.mutex.Lock()
+		r.err = err
+		r.mutex.Unlock()
+
+		return err
+	}
+
+	// Launch a TFTP server to recieve the incoming files
+	r.conn = conn
+	r.server = tftp.NewServer(r.tftpReadHandler, r.tftpRecvHandler)
+	r.server.SetTimeout(5 * time.Second)
+
+	r.serving = make(chan struct{})
+	go r.server.Serve(conn) // blocks until s.Shutdown() is called
+
+	// Shutdown relies on state set up by Serve, which is only certain once a request has been handled. Closing the
+	// connection instead doesn't help, Serve ignores read errors and never returns.
+	if err := r.waitServing(); err != nil {
+		err = errors.Errorf("TFTP Server: not serving on %s: %s", conn.LocalAddr(), err)
+
+		r.mutex.Lock()
+		r.err = err
+		r.mutex.Unlock()
+
+		return err
+	}
+
+	return nil
 }
 
-func (r *TFTPReceiver) Stop() {
-	r.server.Shutdown()
+// waitServing requests tftpReadyProbe from the server and returns once the request has been handled
+func (r *TFTPReceiver) waitServing() error {
+	addr := *r.conn.LocalAddr().(*net.UDPAddr)
+	if addr.IP == nil || addr.IP.IsUnspecified() {
+		addr.IP = net.IPv4(127, 0, 0, 1)
+	}
+
+	client, err := tftp.NewClient(addr.String())
+	if err != nil {
+		return err
+	}
+	client.SetTimeout(time.Second)
+
+	// The probe is always rejected, the error is expected
+	client.Receive(tftpReadyProbe, "octet")
+
+	select {
+	case <-r.serving:
+		return nil
+	default:
+		return errors.New("no response to the ready probe")
+	}
 }
 
-func (r *TFTPReceiver) tftpRecvHandler(filename string, wt io.WriterTo) error {
-	//log.Println("Recieving File on TFTP Server...")
+func (r *TFTPReceiver) listen() (*net.UDPConn, error) {
+	addr, err := net.ResolveUDPAddr("udp", r.ListenAddr)
+	if err != nil {
+		return nil, err
+	}
+	return net.ListenUDP("udp", addr)
+}
 
-	var destChan chan ReceivedFile
-	found := false
+func (r *TFTPReceiver) Stop() {
+	if r.server == nil {
+		return
+	}
 
-	// Ensure that the incoming file is one we're expecting
-	// We only check for a prefix match as some devices require a file extension to be specified.
+	// Shutdown blocks forever if Serve hasn't handled a request yet, in that case the server is left running
+	select {
+	case <-r.serving:
+		r.server.Shutdown()
+	default:
+		log.Println("TFTP Server: unable to stop a server that never started serving")
+	}
+}
+
+// lookupHook finds and removes the hook for an incoming file.
+// We only check for a prefix match as some devices require a file extension to be specified.
+func (r *TFTPReceiver) lookupHook(filename string) (*tftpHook, bool) {
 	r.mutex.Lock()
-	for hookFilename, ch := range r.recvHooks {
+	defer r.mutex.Unlock()
+
+	for hookFilename, hook := range r.recvHooks {
 		if strings.HasPrefix(filename, hookFilename) {
-			destChan = ch
-			found = true
-			break
+			delete(r.recvHooks, hookFilename)
+			return hook, true
 		}
 	}
-	r.mutex.Unlock()
+
+	return nil, false
+}
+
+// tftpReadHandler rejects every read request. The ready probe also signals that Serve is running.
+func (r *TFTPReceiver) tftpReadHandler(filename string, rf io.ReaderFrom) error {
+	if filename == tftpReadyProbe {
+		r.ready.Do(func() { close(r.serving) })
+	}
+	return fmt.Errorf("read requests aren't supported")
+}
+
+func (r *TFTPReceiver) tftpRecvHandler(filename string, wt io.WriterTo) error {
+
+	// Ensure that the incoming file is one we're expecting
+	hook, found := r.lookupHook(filename)
 	if !found {
-		// TODO: This message will never be seen. Need to add logging to github.com/pin/tftp
-		// TODO: See: https://github.com/pin/tftp/blob/master/server.go#L94
+		log.Printf("TFTP Server: rejected unexpected incoming file '%s'\n", filename)
 		return fmt.Errorf("unexpected incoming file (%s)", filename)
 	}
 
-	var buf bytes.Buffer
+	received := ReceivedFile{Name: filename}
 
-	n, err := wt.WriteTo(&buf)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "%v\n", err)
-		return err
+	if _, err := wt.WriteTo(&received.Data); err != nil {
+		received.Err = &TFTPTransferError{Device: hook.device, Filename: filename, Err: err}
+		log.Printf("TFTP Server: %s\n", received.Err)
 	}
 
-	destChan <- ReceivedFile{
-		Name: filename,
-		Data: buf,
-	}
+	// The channel is buffered and only ever receives one file, so this never blocks
+	hook.ch <- received
 
-	_ = n
-	//log.Printf("TFTP: %d bytes received\n", n)
-	return nil
+	return received.Err
 }
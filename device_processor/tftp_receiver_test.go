@@ -0,0 +1,102 @@
+package device_processor
+
+import (
+	"bytes"
+	"github.com/pin/tftp"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func startTestReceiver(t *testing.T) *TFTPReceiver {
+	r := NewTFTPReceiver("127.0.0.1")
+	r.ListenAddr = "127.0.0.1:0"
+	require.NoError(t, r.Run())
+	return r
+}
+
+func sendTestFile(t *testing.T, r *TFTPReceiver, filename string, data string) error {
+	client, err := tftp.NewClient(r.Addr().String())
+	require.NoError(t, err)
+	client.SetTimeout(time.Second)
+
+	rf, err := client.Send(filename, "octet")
+	if err != nil {
+		return err
+	}
+	_, err = rf.ReadFrom(bytes.NewBufferString(data))
+	return err
+}
+
+func TestTFTPReceiver_ExpectFile(t *testing.T) {
+	r := startTestReceiver(t)
+	defer r.Stop()
+
+	ch := r.ExpectFile("0123-abcd", "site-a/router")
+
+	// Devices may append an extension to the filename
+	require.NoError(t, sendTestFile(t, r, "0123-abcd.cfg", "hostname router\n"))
+
+	select {
+	case f := <-ch:
+		require.NoError(t, f.Err)
+		require.Equal(t, "0123-abcd.cfg", f.Name)
+		require.Equal(t, "hostname router\n", f.Data.String())
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the file")
+	}
+
+	// Each expected file is only accepted once
+	require.Error(t, sendTestFile(t, r, "0123-abcd.cfg", "hostname impostor\n"))
+}
+
+func TestTFTPReceiver_Unexpected(t *testing.T) {
+	r := startTestReceiver(t)
+	defer r.Stop()
+
+	require.Error(t, sendTestFile(t, r, "not-expected", "data"))
+
+	// Cancelled files are rejected too
+	r.ExpectFile("0123-abcd", "router")
+	r.CancelFile("0123-abcd")
+	require.Error(t, sendTestFile(t, r, "0123-abcd", "data"))
+}
+
+func TestTFTPReceiver_ListenError(t *testing.T) {
+	r := startTestReceiver(t)
+	defer r.Stop()
+
+	// A second server can't bind to the same address
+	r2 := NewTFTPReceiver("127.0.0.1")
+	r2.ListenAddr = r.Addr().String()
+
+	err := r2.Run()
+	require.Error(t, err)
+	require.Equal(t, err, r2.Err())
+	require.NoError(t, r.Err())
+
+	// The original server is unaffected
+	require.Error(t, sendTestFile(t, r, "not-expected", "data"))
+
+	// Stopping a server that never started is harmless
+	r2.Stop()
+}
+
+func TestTFTPReceiver_StopImmediately(t *testing.T) {
+	// Stopping straight after starting must not block or race with the server starting up
+	for i := 0; i < 20; i++ {
+		r := startTestReceiver(t)
+
+		done := make(chan struct{})
+		go func() {
+			r.Stop()
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out stopping the server")
+		}
+	}
+}
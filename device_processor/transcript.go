@@ -0,0 +1,291 @@
+package device_processor
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/go-errors/errors"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Transcript entry directions
+const (
+	// transcriptRecv marks output received from the device
+	transcriptRecv = "<"
+	// transcriptSent marks input sent to the device
+	transcriptSent = ">"
+	// transcriptNote marks a comment describing what ndm was doing
+	transcriptNote = "#"
+)
+
+// transcriptTimeFormat is the format of the timestamp that starts each transcript line
+const transcriptTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+// transcriptMask replaces secrets in transcripts
+const transcriptMask = "********"
+
+// transcriptCtxPrefix starts the note recording the macro's ctx variable, which allows the session to be replayed
+const transcriptCtxPrefix = "ctx "
+
+// openTranscript creates the transcript file for a device's backup target under dir, replacing any transcript left by
+// an earlier run. Transcripts hold whole sessions, including configs and any secrets that aren't masked, so only the
+// user running ndm can read them.
+func openTranscript(dir string, deviceName string, targetName string) (*transcript, error) {
+	dirPath := path.Join(dir, deviceName)
+	if err := os.MkdirAll(dirPath, 0700); err != nil {
+		return nil, errors.Errorf("Unable to create directory '%s': %s", dirPath, err)
+	}
+
+	filePath := path.Join(dirPath, fmt.Sprintf("%s.transcript", targetName))
+	f, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, errors.Errorf("Unable to create transcript '%s': %s", filePath, err)
+	}
+	// A transcript left by an earlier version of ndm keeps its permissions when it's replaced
+	if err := f.Chmod(0600); err != nil {
+		f.Close()
+		return nil, errors.Errorf("Unable to restrict access to transcript '%s': %s", filePath, err)
+	}
+
+	return newTranscript(f), nil
+}
+
+func newTranscript(w io.WriteCloser) *transcript {
+	return &transcript{w: w, now: time.Now}
+}
+
+// minTranscriptSecretLen is the length of the shortest secret masked wherever it appears. Shorter secrets, such as an
+// enable password of "1" or "en", would mask unrelated output, so they're only masked where they're sent to the device
+// as a word of their own.
+const minTranscriptSecretLen = 4
+
+// transcriptWord matches the words of input sent to a device
+var transcriptWord = regexp.MustCompile(`\S+`)
+
+// transcript records the bytes sent to and received from a device, one timestamped line per read or write. Each line
+// holds the timestamp, the direction and the data as a Go quoted string. A nil transcript records nothing.
+//
+// A secret can be split across reads, so data that ends with the start of a secret is held back until the rest of it
+// arrives, data in the other direction is recorded or the transcript is closed.
+type transcript struct {
+	mutex   sync.Mutex
+	w       io.WriteCloser
+	secrets []string
+	// pending is data in the direction pendingDir that hasn't been written yet
+	pending    []byte
+	pendingDir string
+	now        func() time.Time
+}
+
+// addSecret masks s wherever it appears in data recorded from now on
+func (t *transcript) addSecret(s string) {
+	if t == nil || s == "" {
+		return
+	}
+
+	t.mutex.Lock()
+	t.secrets = append(t.secrets, s)
+	t.mutex.Unlock()
+}
+
+// note records a comment in the transcript
+func (t *transcript) note(format string, args ...interface{}) {
+	t.record(transcriptNote, []byte(fmt.Sprintf(format, args...)))
+}
+
+func (t *transcript) record(direction string, data []byte) {
+	if t == nil {
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if direction != t.pendingDir {
+		t.flush()
+		t.pendingDir = direction
+	}
+
+	if direction == transcriptSent {
+		data = t.maskShortSecrets(data)
+	}
+	t.pending = append(t.pending, data...)
+
+	text := t.maskSecrets(string(t.pending))
+
+	hold := 0
+	if direction != transcriptNote {
+		hold = t.secretPrefixLen(text)
+	}
+	t.pending = []byte(text[len(text)-hold:])
+	if hold < len(text) {
+		t.writeLine(direction, text[:len(text)-hold])
+	}
+}
+
+// secretPrefixLen returns the length of the longest end of text that could be the start of a secret
+func (t *transcript) secretPrefixLen(text string) int {
+	longest := 0
+	for _, secret := range t.secrets {
+		if len(secret) < minTranscriptSecretLen {
+			continue
+		}
+		for n := len(secret) - 1; n > longest; n-- {
+			if n <= len(text) && strings.HasSuffix(text, secret[:n]) {
+				longest = n
+				break
+			}
+		}
+	}
+	return longest
+}
+
+// flush writes the data held back, t.mutex must be held
+func (t *transcript) flush() {
+	if len(t.pending) > 0 {
+		t.writeLine(t.pendingDir, t.maskSecrets(string(t.pending)))
+	}
+	t.pending = nil
+}
+
+// maskSecrets replaces the secrets that are long enough to mask wherever they appear in text
+func (t *transcript) maskSecrets(text string) string {
+	for _, secret := range t.secrets {
+		if len(secret) >= minTranscriptSecretLen {
+			text = strings.Replace(text, secret, transcriptMask, -1)
+		}
+	}
+	return text
+}
+
+// maskShortSecrets masks the words of input that are secrets too short to mask elsewhere. Device CLIs separate the
+// arguments of a command with whitespace, so this catches a secret sent on its own or as part of a command such as
+// "enable 1", while the digits of an interface name or address are left alone and the session can still be replayed.
+func (t *transcript) maskShortSecrets(data []byte) []byte {
+	return transcriptWord.ReplaceAllFunc(data, func(word []byte) []byte {
+		for _, secret := range t.secrets {
+			if len(secret) < minTranscriptSecretLen && string(word) == secret {
+				return []byte(transcriptMask)
+			}
+		}
+		return word
+	})
+}
+
+func (t *transcript) writeLine(direction string, text string) {
+	var line bytes.Buffer
+	line.WriteString(t.now().Format(transcriptTimeFormat))
+	line.WriteString(" ")
+	line.WriteString(direction)
+	line.WriteString(" ")
+	line.WriteString(strconv.Quote(text))
+	line.WriteString("\n")
+
+	// The transcript is a debugging aid, failing to write it shouldn't fail the backup
+	t.w.Write(line.Bytes())
+}
+
+// reader returns a Reader that records everything read from r as received output
+func (t *transcript) reader(r io.Reader) io.Reader {
+	if t == nil {
+		return r
+	}
+	return &transcriptReader{r: r, t: t}
+}
+
+// writer returns a Writer that records everything written to w as sent input
+func (t *transcript) writer(w io.Writer) io.Writer {
+	if t == nil {
+		return w
+	}
+	return &transcriptWriter{w: w, t: t}
+}
+
+// Close writes any data held back and closes the transcript
+func (t *transcript) Close() error {
+	t.mutex.Lock()
+	t.flush()
+	t.mutex.Unlock()
+
+	return t.w.Close()
+}
+
+// transcriptEntry is a line of a transcript
+type transcriptEntry struct {
+	Time      time.Time
+	Direction string
+	Data      string
+}
+
+// parseTranscript reads the entries of a transcript
+func parseTranscript(r io.Reader) ([]transcriptEntry, error) {
+	var entries []transcriptEntry
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) != 3 {
+			return nil, errors.Errorf("line %d: expected a timestamp, direction and quoted data", lineNum)
+		}
+
+		when, err := time.Parse(transcriptTimeFormat, fields[0])
+		if err != nil {
+			return nil, errors.Errorf("line %d: invalid timestamp: %s", lineNum, err)
+		}
+
+		switch fields[1] {
+		case transcriptRecv, transcriptSent, transcriptNote:
+		default:
+			return nil, errors.Errorf("line %d: unknown direction '%s'", lineNum, fields[1])
+		}
+
+		data, err := strconv.Unquote(fields[2])
+		if err != nil {
+			return nil, errors.Errorf("line %d: invalid quoted data: %s", lineNum, err)
+		}
+
+		entries = append(entries, transcriptEntry{Time: when, Direction: fields[1], Data: data})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+type transcriptReader struct {
+	r io.Reader
+	t *transcript
+}
+
+func (r *transcriptReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.t.record(transcriptRecv, p[:n])
+	}
+	return n, err
+}
+
+type transcriptWriter struct {
+	w io.Writer
+	t *transcript
+}
+
+func (w *transcriptWriter) Write(p []byte) (int, error) {
+	w.t.record(transcriptSent, p)
+	return w.w.Write(p)
+}
@@ -0,0 +1,118 @@
+package device_processor
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/require"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+func TestTranscript_Record(t *testing.T) {
+	var out bytes.Buffer
+	tr := newTranscript(nopWriteCloser{&out})
+	tr.now = func() time.Time { return time.Date(2018, 3, 1, 2, 0, 0, 500, time.UTC) }
+
+	var sent bytes.Buffer
+	r := tr.reader(strings.NewReader("Password: "))
+	w := tr.writer(&sent)
+
+	tr.note("device '%s' target '%s'", "router", "running")
+
+	data, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, "Password: ", string(data))
+
+	// Secrets are masked in the transcript, but sent to the device unchanged
+	tr.addSecret("hunter2")
+	_, err = io.WriteString(w, "hunter2\r\n")
+	require.NoError(t, err)
+	require.Equal(t, "hunter2\r\n", sent.String())
+
+	require.Equal(t, `2018-03-01T02:00:00.000000Z # "device 'router' target 'running'"
+2018-03-01T02:00:00.000000Z < "Password: "
+2018-03-01T02:00:00.000000Z > "********\r\n"
+`, out.String())
+}
+
+func TestTranscript_SplitSecret(t *testing.T) {
+	var out bytes.Buffer
+	tr := newTranscript(nopWriteCloser{&out})
+	tr.now = func() time.Time { return time.Date(2018, 3, 1, 2, 0, 0, 0, time.UTC) }
+	tr.addSecret("hunter2")
+	tr.addSecret("en")
+
+	// The device echoes the secret back a few bytes at a time
+	for _, chunk := range []string{"key hun", "te", "r2\r\nR1", "#"} {
+		tr.record(transcriptRecv, []byte(chunk))
+	}
+
+	// Short secrets are only masked where they're sent as a word of their own, including within a command
+	tr.record(transcriptSent, []byte("enable\r\n"))
+	tr.record(transcriptSent, []byte("en\r\n"))
+	tr.record(transcriptSent, []byte("enable en\r\n"))
+	tr.record(transcriptRecv, []byte("R1#hunt"))
+	require.NoError(t, tr.Close())
+
+	require.Equal(t, `2018-03-01T02:00:00.000000Z < "key "
+2018-03-01T02:00:00.000000Z < "********\r\nR1"
+2018-03-01T02:00:00.000000Z < "#"
+2018-03-01T02:00:00.000000Z > "enable\r\n"
+2018-03-01T02:00:00.000000Z > "********\r\n"
+2018-03-01T02:00:00.000000Z > "enable ********\r\n"
+2018-03-01T02:00:00.000000Z < "R1#"
+2018-03-01T02:00:00.000000Z < "hunt"
+`, out.String())
+}
+
+func TestTranscript_Nil(t *testing.T) {
+	var tr *transcript
+
+	r := strings.NewReader("data")
+	require.Equal(t, r, tr.reader(r))
+
+	tr.addSecret("hunter2")
+	tr.note("nothing happens")
+}
+
+func TestOpenTranscript(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ndm-transcripts")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	tr, err := openTranscript(dir, "site-a/router", "running_config")
+	require.NoError(t, err)
+	tr.note("first run")
+	require.NoError(t, tr.Close())
+
+	// Transcripts from earlier runs are replaced
+	tr, err = openTranscript(dir, "site-a/router", "running_config")
+	require.NoError(t, err)
+	tr.note("second run")
+	require.NoError(t, tr.Close())
+
+	transcriptPath := path.Join(dir, "site-a", "router", "running_config.transcript")
+	data, err := ioutil.ReadFile(transcriptPath)
+	require.NoError(t, err)
+	require.Contains(t, string(data), "second run")
+	require.NotContains(t, string(data), "first run")
+
+	// Transcripts can hold configs and secrets, only their owner can read them
+	info, err := os.Stat(transcriptPath)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	info, err = os.Stat(path.Dir(transcriptPath))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0700), info.Mode().Perm())
+}
@@ -5,8 +5,11 @@ import (
 	"github.com/samhug/ndm/auth"
 	"github.com/samhug/ndm/config"
 	"path"
+	"sort"
 )
 
+// LoadDevices constructs a Device for each configured device. If authProviders is nil the devices' credentials are not
+// resolved, which allows the inventory to be inspected without unlocking any auth providers.
 func LoadDevices(deviceGroupCfgs map[string]*config.DeviceGroupConfig, deviceClasses map[string]*DeviceClass, authProviders *auth.ProviderPool) (map[string]*Device, error) {
 
 	devices := make(map[string]*Device)
@@ -29,25 +32,39 @@ func LoadDevices(deviceGroupCfgs map[string]*config.DeviceGroupConfig, deviceCla
 				return nil, errors.Errorf("Unable to initialize Device(%s): DeviceClass(%s) not found", deviceName, deviceCfg.ClassName)
 			}
 
-			authProvider, err := authProviders.GetProvider(deviceCfg.AuthProvider)
-			if err != nil {
-				return nil, errors.Errorf("Failed to retrieve AuthProvider(%s) from the pool: %s",
-					deviceCfg.AuthProvider, err)
+			var deviceAuth auth.Auth
+			if authProviders != nil {
+				authProvider, err := authProviders.GetProvider(deviceCfg.AuthProvider)
+				if err != nil {
+					return nil, errors.Errorf("Failed to retrieve AuthProvider(%s) from the pool: %s",
+						deviceCfg.AuthProvider, err)
+				}
+
+				deviceAuth, err = authProvider.Lookup(deviceCfg.AuthPath)
+				if err != nil {
+					return nil, errors.Errorf("Lookup failed for Auth(%s) in AuthProvider(%s): %s",
+						deviceCfg.AuthPath, deviceCfg.AuthProvider, err)
+				}
 			}
 
-			auth, err := authProvider.Lookup(deviceCfg.AuthPath)
+			retry, err := newRetryOverrides(deviceCfg.Retries, deviceCfg.RetryBackoff)
 			if err != nil {
-				return nil, errors.Errorf("Lookup failed for Auth(%s) in AuthProvider(%s): %s",
-					deviceCfg.AuthPath, deviceCfg.AuthProvider, err)
+				return nil, errors.Errorf("Unable to initialize Device(%s): %s", deviceName, err)
 			}
 
 			devices[deviceFullName] = &Device{
 				Name:             deviceFullName,
+				Group:            groupName,
 				Class:            deviceClass,
+				ClassName:        deviceCfg.ClassName,
 				Address:          deviceCfg.Address,
 				AuthProviderName: deviceCfg.AuthProvider,
 				AuthPath:         deviceCfg.AuthPath,
-				Auth:             auth,
+				Auth:             deviceAuth,
+				HostKey:          deviceCfg.HostKey,
+				Vars:             config.MergeVars(deviceGroupCfg.Vars, deviceCfg.Vars),
+				Tags:             mergeTags(deviceGroupCfg.Tags, deviceCfg.Tags),
+				retry:            retry,
 			}
 		}
 	}
@@ -58,9 +75,59 @@ func LoadDevices(deviceGroupCfgs map[string]*config.DeviceGroupConfig, deviceCla
 // Device represents a network device to be managed
 type Device struct {
 	Name             string
+	Group            string // The device_group the device belongs to, empty for top-level devices
 	Class            *DeviceClass
+	ClassName        string
 	Address          string
 	AuthProviderName string
 	AuthPath         string
 	Auth             auth.Auth
+	// HostKey is the fingerprint of the device's SSH host key, if it has been pinned
+	HostKey string
+	// Vars are the vars of the device and its device_group, they override the vars of its class
+	Vars map[string]string
+	// Tags holds the tags of the device and its device_group, sorted
+	Tags  []string
+	retry retryOverrides
+}
+
+// HasTag reports whether the device, or its device_group, is tagged with tag
+func (d *Device) HasTag(tag string) bool {
+	i := sort.SearchStrings(d.Tags, tag)
+	return i < len(d.Tags) && d.Tags[i] == tag
+}
+
+// mergeTags returns the sorted union of the given sets of tags
+func mergeTags(tagSets ...[]string) []string {
+	seen := make(map[string]struct{})
+	tags := []string{}
+	for _, set := range tagSets {
+		for _, tag := range set {
+			if _, ok := seen[tag]; !ok {
+				seen[tag] = struct{}{}
+				tags = append(tags, tag)
+			}
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// MacroVars returns the vars exposed to the device's macros as ctx.vars, the vars of its device_class overridden by
+// those of its device_group and its own
+func (d *Device) MacroVars() map[string]string {
+	if d.Class == nil {
+		return config.MergeVars(nil, d.Vars)
+	}
+	return config.MergeVars(d.Class.Vars, d.Vars)
+}
+
+// RetryPolicy returns the retry policy for the device's backup targets. Settings on the device take precedence over
+// those of its device_class, which take precedence over defaults.
+func (d *Device) RetryPolicy(defaults RetryPolicy) RetryPolicy {
+	policy := defaults
+	if d.Class != nil {
+		policy = d.Class.retry.apply(policy)
+	}
+	return d.retry.apply(policy)
 }
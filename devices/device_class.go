@@ -4,13 +4,14 @@ import (
 	"github.com/go-errors/errors"
 	"github.com/robertkrimen/otto"
 	"github.com/samhug/ndm/config"
+	"time"
 )
 
 func LoadDeviceClassTargets(deviceClassTargetCfgs map[string]*config.BackupTargetConfig) (map[string]*DeviceClassTarget, error) {
 	deviceClassTargets := make(map[string]*DeviceClassTarget)
 
 	for name, deviceClassTargetCfg := range deviceClassTargetCfgs {
-		target, err := NewDeviceClassTarget(name, deviceClassTargetCfg.Macro)
+		target, err := NewDeviceClassTarget(name, deviceClassTargetCfg)
 		if err != nil {
 			return nil, errors.Errorf("Unable to initialize DeviceClassTarget(%s): %s", name, err)
 		}
@@ -21,24 +22,105 @@ func LoadDeviceClassTargets(deviceClassTargetCfgs map[string]*config.BackupTarge
 	return deviceClassTargets, nil
 }
 
-func NewDeviceClassTarget(name string, macroSrc string) (*DeviceClassTarget, error) {
-	macro, err := otto.New().Compile("", macroSrc)
+func NewDeviceClassTarget(name string, cfg *config.BackupTargetConfig) (*DeviceClassTarget, error) {
+	if cfg.Fetch != "" {
+		protocol, remotePath, err := config.ParseFetchStr(cfg.Fetch)
+		if err != nil {
+			return nil, err
+		}
+
+		return &DeviceClassTarget{
+			Name:          name,
+			Mode:          config.BackupModeFetch,
+			FetchProtocol: protocol,
+			FetchPath:     remotePath,
+		}, nil
+	}
+
+	macro, err := otto.New().Compile("", cfg.Macro)
 	if err != nil {
 		return nil, errors.Errorf("Unable to compile JavaScript macro: %s", err)
 	}
 
+	mode := cfg.Mode
+	if mode == "" {
+		mode = config.BackupModeTFTP
+	}
+
+	expectTimeout, err := parseExpectTimeout(cfg.ExpectTimeout)
+	if err != nil {
+		return nil, err
+	}
+
 	return &DeviceClassTarget{
-		Name:  name,
-		Macro: macro,
+		Name:          name,
+		Macro:         macro,
+		Mode:          mode,
+		ExpectTimeout: expectTimeout,
 	}, nil
 }
 
+// parseExpectTimeout parses an 'expect_timeout' setting, an empty string yields zero
+func parseExpectTimeout(timeout string) (time.Duration, error) {
+	if timeout == "" {
+		return 0, nil
+	}
+
+	d, err := time.ParseDuration(timeout)
+	if err != nil {
+		return 0, errors.Errorf("Invalid expect_timeout: %s", err)
+	}
+	return d, nil
+}
+
 type DeviceClassTarget struct {
 	Name  string
 	Macro *otto.Script
+	// Mode determines how the config is retrieved from the device, one of the config.BackupMode* values
+	Mode string
+	// FetchProtocol and FetchPath locate the config file on the device for fetch mode targets
+	FetchProtocol string
+	FetchPath     string
+	// ExpectTimeout is the default timeout of the macro's expect calls, zero if the target doesn't set one
+	ExpectTimeout time.Duration
 }
 
-func LoadDeviceClasses(deviceClassCfgs map[string]*config.DeviceClassConfig) (map[string]*DeviceClass, error) {
+// UsesTFTP reports whether the target retrieves the config via the built-in TFTP server
+func (t *DeviceClassTarget) UsesTFTP() bool {
+	return t.Mode == config.BackupModeTFTP
+}
+
+// LoadMacroLibraries compiles each configured macro_library
+func LoadMacroLibraries(libraryCfgs map[string]*config.MacroLibraryConfig) (map[string]*MacroLibrary, error) {
+	libraries := make(map[string]*MacroLibrary)
+
+	for name, libraryCfg := range libraryCfgs {
+		script, err := otto.New().Compile(name, libraryCfg.Source)
+		if err != nil {
+			return nil, errors.Errorf("Unable to compile MacroLibrary(%s): %s", name, err)
+		}
+
+		libraries[name] = &MacroLibrary{Name: name, Script: script}
+	}
+
+	return libraries, nil
+}
+
+// MacroLibrary is a compiled macro_library. It's run in the JavaScript VM before the macros of the device classes
+// that use it, so the functions it defines are available to them.
+type MacroLibrary struct {
+	Name   string
+	Script *otto.Script
+}
+
+// LoadDeviceClasses constructs a DeviceClass for each configured device class, resolving the macro libraries they use
+// from libraryCfgs
+func LoadDeviceClasses(deviceClassCfgs map[string]*config.DeviceClassConfig, libraryCfgs map[string]*config.MacroLibraryConfig) (map[string]*DeviceClass, error) {
+
+	macroLibraries, err := LoadMacroLibraries(libraryCfgs)
+	if err != nil {
+		return nil, err
+	}
 
 	deviceClasses := make(map[string]*DeviceClass)
 
@@ -50,8 +132,29 @@ func LoadDeviceClasses(deviceClassCfgs map[string]*config.DeviceClassConfig) (ma
 		if len(targets) == 0 {
 			return nil, errors.Errorf("DeviceClass '%s': No BackupTargets defined", name)
 		}
+		retry, err := newRetryOverrides(deviceClassCfg.Retries, deviceClassCfg.RetryBackoff)
+		if err != nil {
+			return nil, errors.Errorf("DeviceClass '%s': %s", name, err)
+		}
+		expectTimeout, err := parseExpectTimeout(deviceClassCfg.ExpectTimeout)
+		if err != nil {
+			return nil, errors.Errorf("DeviceClass '%s': %s", name, err)
+		}
+		libraries := make([]*MacroLibrary, 0, len(deviceClassCfg.Libraries))
+		for _, libraryName := range deviceClassCfg.Libraries {
+			library, ok := macroLibraries[libraryName]
+			if !ok {
+				return nil, errors.Errorf("DeviceClass '%s': MacroLibrary(%s) not found", name, libraryName)
+			}
+			libraries = append(libraries, library)
+		}
 		deviceClasses[name] = &DeviceClass{
-			Targets: targets,
+			Targets:       targets,
+			ExpectTimeout: expectTimeout,
+			Pager:         deviceClassCfg.Pager,
+			Libraries:     libraries,
+			Vars:          deviceClassCfg.Vars,
+			retry:         retry,
 		}
 	}
 
@@ -61,4 +164,32 @@ func LoadDeviceClasses(deviceClassCfgs map[string]*config.DeviceClassConfig) (ma
 // DeviceClass represents a class of devices
 type DeviceClass struct {
 	Targets map[string]*DeviceClassTarget
+	// ExpectTimeout is the default timeout of expect calls in the class's macros, zero if the class doesn't set one
+	ExpectTimeout time.Duration
+	// Pager describes the class's pager prompt, nil if the class doesn't configure one
+	Pager *config.PagerConfig
+	// Libraries are run before each of the class's macros, in order
+	Libraries []*MacroLibrary
+	// Vars are exposed to the class's macros as ctx.vars, devices may override them
+	Vars  map[string]string
+	retry retryOverrides
+}
+
+// TargetExpectTimeout returns the default expect timeout for target, which takes precedence over the class's. Zero
+// means neither sets a timeout and the processor's default applies, it never means waiting indefinitely.
+func (t *DeviceClass) TargetExpectTimeout(target *DeviceClassTarget) time.Duration {
+	if target.ExpectTimeout > 0 {
+		return target.ExpectTimeout
+	}
+	return t.ExpectTimeout
+}
+
+// UsesTFTP reports whether any of the class's targets retrieve their config via the built-in TFTP server
+func (t *DeviceClass) UsesTFTP() bool {
+	for _, target := range t.Targets {
+		if target.UsesTFTP() {
+			return true
+		}
+	}
+	return false
 }
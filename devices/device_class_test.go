@@ -0,0 +1,59 @@
+package devices
+
+import (
+	"github.com/samhug/ndm/config"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestDeviceClass_TargetExpectTimeout(t *testing.T) {
+	classes, err := LoadDeviceClasses(map[string]*config.DeviceClassConfig{
+		"cisco": {
+			ExpectTimeout: "30s",
+			BackupTargets: map[string]*config.BackupTargetConfig{
+				"running": {Macro: `expect("#")`},
+				"startup": {Macro: `expect("#")`, ExpectTimeout: "2m"},
+			},
+		},
+	}, nil)
+	require.NoError(t, err)
+
+	class := classes["cisco"]
+	require.Equal(t, 30*time.Second, class.TargetExpectTimeout(class.Targets["running"]))
+	require.Equal(t, 2*time.Minute, class.TargetExpectTimeout(class.Targets["startup"]))
+}
+
+func TestLoadDeviceClasses_Libraries(t *testing.T) {
+	libraryCfgs := map[string]*config.MacroLibraryConfig{
+		"common": {Source: `function enable() { sendLine("enable") }`},
+		"cisco":  {Source: `function copyToTFTP(file) {}`},
+	}
+
+	classes, err := LoadDeviceClasses(map[string]*config.DeviceClassConfig{
+		"cisco": {
+			Libraries:     []string{"common", "cisco"},
+			BackupTargets: map[string]*config.BackupTargetConfig{"running": {Macro: `enable()`}},
+		},
+	}, libraryCfgs)
+	require.NoError(t, err)
+
+	libraries := classes["cisco"].Libraries
+	require.Len(t, libraries, 2)
+	require.Equal(t, "common", libraries[0].Name)
+	require.Equal(t, "cisco", libraries[1].Name)
+
+	// Unknown libraries
+	_, err = LoadDeviceClasses(map[string]*config.DeviceClassConfig{
+		"cisco": {
+			Libraries:     []string{"juniper"},
+			BackupTargets: map[string]*config.BackupTargetConfig{"running": {Macro: `enable()`}},
+		},
+	}, libraryCfgs)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "MacroLibrary(juniper) not found")
+
+	// Libraries that don't compile
+	_, err = LoadDeviceClasses(nil, map[string]*config.MacroLibraryConfig{"broken": {Source: `function (`}})
+	require.Error(t, err)
+}
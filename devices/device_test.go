@@ -0,0 +1,48 @@
+package devices
+
+import (
+	"github.com/samhug/ndm/config"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestDevice_MacroVars(t *testing.T) {
+	class := &DeviceClass{Vars: map[string]string{"prompt": "#", "pager": "terminal length 0"}}
+
+	d := &Device{Class: class, Vars: map[string]string{"prompt": "core-sw-01#"}}
+	require.Equal(t, map[string]string{"prompt": "core-sw-01#", "pager": "terminal length 0"}, d.MacroVars())
+
+	// The class's vars aren't modified
+	require.Equal(t, "#", class.Vars["prompt"])
+
+	// Macros can always rely on ctx.vars being an object
+	require.Equal(t, map[string]string{}, (&Device{Class: &DeviceClass{}}).MacroVars())
+}
+
+func TestLoadDevices_GroupVarsAndTags(t *testing.T) {
+	class := &DeviceClass{Vars: map[string]string{"vrf": "default", "prompt": "#"}}
+
+	_devices, err := LoadDevices(map[string]*config.DeviceGroupConfig{
+		"site-a": {
+			Vars: map[string]string{"vrf": "mgmt"},
+			Tags: []string{"datacenter", "core"},
+			Devices: map[string]*config.DeviceConfig{
+				"sw1": {ClassName: "cisco", Tags: []string{"core", "access"}, Vars: map[string]string{"prompt": "sw1#"}},
+				"sw2": {ClassName: "cisco"},
+			},
+		},
+	}, map[string]*DeviceClass{"cisco": class}, nil)
+	require.NoError(t, err)
+
+	sw1 := _devices["site-a/sw1"]
+	require.Equal(t, []string{"access", "core", "datacenter"}, sw1.Tags)
+	require.True(t, sw1.HasTag("access"))
+	require.False(t, sw1.HasTag("edge"))
+	require.Equal(t, map[string]string{"vrf": "mgmt", "prompt": "sw1#"}, sw1.Vars)
+	require.Equal(t, map[string]string{"vrf": "mgmt", "prompt": "sw1#"}, sw1.MacroVars())
+
+	// Devices inherit their group's values
+	sw2 := _devices["site-a/sw2"]
+	require.Equal(t, []string{"core", "datacenter"}, sw2.Tags)
+	require.Equal(t, map[string]string{"vrf": "mgmt", "prompt": "#"}, sw2.MacroVars())
+}
@@ -0,0 +1,89 @@
+package devices
+
+import (
+	"github.com/go-errors/errors"
+	"time"
+)
+
+// DefaultRetryBackoff is the delay before the first retry of a failed backup target when no retry_backoff is configured
+const DefaultRetryBackoff = 10 * time.Second
+
+// MaxRetryDelay limits how long the doubling backoff waits between retries. A retry_backoff longer than this is used
+// as is, without doubling.
+const MaxRetryDelay = 10 * time.Minute
+
+// NewRetryPolicy: Constructs a RetryPolicy from the 'retries' and 'retry_backoff' config settings
+func NewRetryPolicy(retries int, backoff string) (RetryPolicy, error) {
+	policy := RetryPolicy{Retries: retries, Backoff: DefaultRetryBackoff}
+
+	if backoff != "" {
+		d, err := time.ParseDuration(backoff)
+		if err != nil {
+			return RetryPolicy{}, errors.Errorf("Invalid retry_backoff: %s", err)
+		}
+		policy.Backoff = d
+	}
+
+	return policy, nil
+}
+
+// RetryPolicy controls how many times a failed backup target is retried and how long to wait between attempts
+type RetryPolicy struct {
+	Retries int
+	// Backoff is the delay before the first retry, it doubles with each subsequent retry up to MaxRetryDelay
+	Backoff time.Duration
+}
+
+// Attempts returns the maximum number of times a backup target will be attempted
+func (p RetryPolicy) Attempts() int {
+	return p.Retries + 1
+}
+
+// Delay returns how long to wait before the given retry, numbered from 1
+func (p RetryPolicy) Delay(retry int) time.Duration {
+	if retry < 1 || p.Backoff <= 0 {
+		return 0
+	}
+	if p.Backoff >= MaxRetryDelay {
+		return p.Backoff
+	}
+
+	delay := p.Backoff
+	for i := 1; i < retry && delay < MaxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > MaxRetryDelay {
+		delay = MaxRetryDelay
+	}
+	return delay
+}
+
+// retryOverrides holds the retry settings of a device_class or device, nil fields inherit from the level above
+type retryOverrides struct {
+	Retries *int
+	Backoff *time.Duration
+}
+
+func newRetryOverrides(retries *int, backoff string) (retryOverrides, error) {
+	overrides := retryOverrides{Retries: retries}
+
+	if backoff != "" {
+		d, err := time.ParseDuration(backoff)
+		if err != nil {
+			return retryOverrides{}, errors.Errorf("Invalid retry_backoff: %s", err)
+		}
+		overrides.Backoff = &d
+	}
+
+	return overrides, nil
+}
+
+func (o retryOverrides) apply(policy RetryPolicy) RetryPolicy {
+	if o.Retries != nil {
+		policy.Retries = *o.Retries
+	}
+	if o.Backoff != nil {
+		policy.Backoff = *o.Backoff
+	}
+	return policy
+}
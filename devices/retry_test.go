@@ -0,0 +1,63 @@
+package devices
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	p := RetryPolicy{Retries: 3, Backoff: 5 * time.Second}
+
+	require.Equal(t, 4, p.Attempts())
+	require.Equal(t, 5*time.Second, p.Delay(1))
+	require.Equal(t, 10*time.Second, p.Delay(2))
+	require.Equal(t, 20*time.Second, p.Delay(3))
+
+	// The delay stops doubling at MaxRetryDelay, however many retries there are
+	require.Equal(t, 320*time.Second, p.Delay(7))
+	require.Equal(t, MaxRetryDelay, p.Delay(8))
+	require.Equal(t, MaxRetryDelay, p.Delay(100))
+	require.Equal(t, MaxRetryDelay, p.Delay(1<<30))
+
+	// A backoff longer than the maximum is used without doubling
+	p = RetryPolicy{Retries: 3, Backoff: time.Hour}
+	require.Equal(t, time.Hour, p.Delay(1))
+	require.Equal(t, time.Hour, p.Delay(3))
+
+	require.Equal(t, time.Duration(0), RetryPolicy{Retries: 3}.Delay(2))
+}
+
+func TestNewRetryPolicy(t *testing.T) {
+	p, err := NewRetryPolicy(2, "")
+	require.NoError(t, err)
+	require.Equal(t, RetryPolicy{Retries: 2, Backoff: DefaultRetryBackoff}, p)
+
+	p, err = NewRetryPolicy(1, "1m30s")
+	require.NoError(t, err)
+	require.Equal(t, RetryPolicy{Retries: 1, Backoff: 90 * time.Second}, p)
+
+	_, err = NewRetryPolicy(1, "later")
+	require.Error(t, err)
+}
+
+func TestDevice_RetryPolicy(t *testing.T) {
+	defaults := RetryPolicy{Retries: 1, Backoff: DefaultRetryBackoff}
+
+	classRetries := 3
+	classBackoff := time.Minute
+	class := &DeviceClass{retry: retryOverrides{Retries: &classRetries, Backoff: &classBackoff}}
+
+	// Devices without their own settings inherit the class's
+	d := &Device{Class: class}
+	require.Equal(t, RetryPolicy{Retries: 3, Backoff: time.Minute}, d.RetryPolicy(defaults))
+
+	// The device's settings win, including an explicit zero
+	deviceRetries := 0
+	d = &Device{Class: class, retry: retryOverrides{Retries: &deviceRetries}}
+	require.Equal(t, RetryPolicy{Retries: 0, Backoff: time.Minute}, d.RetryPolicy(defaults))
+
+	// Without any overrides the defaults apply
+	d = &Device{Class: &DeviceClass{}}
+	require.Equal(t, defaults, d.RetryPolicy(defaults))
+}
@@ -0,0 +1,196 @@
+package devices
+
+import (
+	"github.com/go-errors/errors"
+	"github.com/ryanuber/go-glob"
+	"net"
+	"strings"
+)
+
+// selectorNegation prefixes the terms of a selection that exclude devices
+const selectorNegation = "!"
+
+// NewSelector: Constructs a Selector. patterns are globs matched against device names, and tags, classes, groups and
+// addresses are values of those attributes. Any term prefixed with '!' excludes the devices it matches instead.
+// Addresses may be IP addresses, CIDR ranges or hostnames.
+func NewSelector(patterns []string, tags []string, classes []string, groups []string, addresses []string) (*Selector, error) {
+	s := &Selector{}
+
+	s.names, s.excludedNames = splitNegated(patterns)
+	s.tags, s.excludedTags = splitNegated(tags)
+	s.classes, s.excludedClasses = splitNegated(classes)
+	s.groups, s.excludedGroups = splitNegated(groups)
+
+	include, exclude := splitNegated(addresses)
+	var err error
+	if s.addresses, err = parseAddressMatchers(include); err != nil {
+		return nil, err
+	}
+	if s.excludedAddresses, err = parseAddressMatchers(exclude); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Selector picks devices by name, tag, device_class, device_group and address. A device is selected when it matches
+// at least one of the name patterns, classes, groups and addresses that are given, has every tag, and matches none of
+// the exclusions. An empty Selector selects every device.
+type Selector struct {
+	names             []string
+	excludedNames     []string
+	tags              []string
+	excludedTags      []string
+	classes           []string
+	excludedClasses   []string
+	groups            []string
+	excludedGroups    []string
+	addresses         []addressMatcher
+	excludedAddresses []addressMatcher
+}
+
+// Match reports whether the device is selected
+func (s *Selector) Match(d *Device) bool {
+	if len(s.names) > 0 && !matchAnyGlob(s.names, d.Name) {
+		return false
+	}
+	if matchAnyGlob(s.excludedNames, d.Name) {
+		return false
+	}
+
+	for _, tag := range s.tags {
+		if !d.HasTag(tag) {
+			return false
+		}
+	}
+	for _, tag := range s.excludedTags {
+		if d.HasTag(tag) {
+			return false
+		}
+	}
+
+	if len(s.classes) > 0 && !contains(s.classes, d.ClassName) {
+		return false
+	}
+	if contains(s.excludedClasses, d.ClassName) {
+		return false
+	}
+
+	if len(s.groups) > 0 && !contains(s.groups, d.Group) {
+		return false
+	}
+	if contains(s.excludedGroups, d.Group) {
+		return false
+	}
+
+	if len(s.addresses) > 0 && !matchAnyAddress(s.addresses, d.Address) {
+		return false
+	}
+	if matchAnyAddress(s.excludedAddresses, d.Address) {
+		return false
+	}
+
+	return true
+}
+
+// Filter returns the selected devices
+func (s *Selector) Filter(devices map[string]*Device) map[string]*Device {
+	selected := make(map[string]*Device)
+	for name, d := range devices {
+		if s.Match(d) {
+			selected[name] = d
+		}
+	}
+	return selected
+}
+
+// splitNegated separates terms into those that include devices and, with the '!' removed, those that exclude them
+func splitNegated(terms []string) ([]string, []string) {
+	var include, exclude []string
+	for _, term := range terms {
+		if strings.HasPrefix(term, selectorNegation) {
+			exclude = append(exclude, strings.TrimPrefix(term, selectorNegation))
+		} else {
+			include = append(include, term)
+		}
+	}
+	return include, exclude
+}
+
+func matchAnyGlob(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if glob.Glob(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// addressMatcher matches a device address against an IP network, or a hostname if network is nil
+type addressMatcher struct {
+	network  *net.IPNet
+	hostname string
+}
+
+func parseAddressMatchers(addresses []string) ([]addressMatcher, error) {
+	matchers := make([]addressMatcher, 0, len(addresses))
+	for _, address := range addresses {
+		if address == "" {
+			return nil, errors.New("Empty address selection")
+		}
+
+		if strings.Contains(address, "/") {
+			_, network, err := net.ParseCIDR(address)
+			if err != nil {
+				return nil, errors.Errorf("Invalid address selection '%s': %s", address, err)
+			}
+			matchers = append(matchers, addressMatcher{network: network})
+			continue
+		}
+
+		if ip := net.ParseIP(address); ip != nil {
+			bits := 8 * net.IPv4len
+			if ip.To4() == nil {
+				bits = 8 * net.IPv6len
+			}
+			matchers = append(matchers, addressMatcher{network: &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}})
+			continue
+		}
+
+		matchers = append(matchers, addressMatcher{hostname: strings.ToLower(address)})
+	}
+	return matchers, nil
+}
+
+// deviceHost returns the host part of a device's address, which may include a port
+func deviceHost(address string) string {
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		return host
+	}
+	return address
+}
+
+func matchAnyAddress(matchers []addressMatcher, address string) bool {
+	host := deviceHost(address)
+	ip := net.ParseIP(host)
+
+	for _, m := range matchers {
+		if m.network != nil {
+			if ip != nil && m.network.Contains(ip) {
+				return true
+			}
+		} else if strings.EqualFold(m.hostname, host) {
+			return true
+		}
+	}
+	return false
+}
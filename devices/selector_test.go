@@ -0,0 +1,74 @@
+package devices
+
+import (
+	"github.com/stretchr/testify/require"
+	"sort"
+	"testing"
+)
+
+func testInventory() map[string]*Device {
+	inventory := map[string]*Device{}
+	for _, d := range []*Device{
+		{Name: "site-a/core-sw-01", Group: "site-a", ClassName: "cisco_ios", Address: "10.1.0.1:22", Tags: []string{"core"}},
+		{Name: "site-a/access-sw-01", Group: "site-a", ClassName: "cisco_ios", Address: "10.1.0.10:22", Tags: []string{"access"}},
+		{Name: "site-b/core-sw-01", Group: "site-b", ClassName: "cisco_nexus", Address: "10.2.0.1", Tags: []string{"core"}},
+		{Name: "lab/core-sw-01", Group: "lab", ClassName: "cisco_ios", Address: "lab-sw.example.com:22", Tags: []string{"core", "lab"}},
+		{Name: "edge-router", ClassName: "cisco_isr", Address: "[2001:db8::1]:22"},
+	} {
+		inventory[d.Name] = d
+	}
+	return inventory
+}
+
+func selectNames(t *testing.T, patterns []string, tags []string, classes []string, groups []string, addresses []string) []string {
+	s, err := NewSelector(patterns, tags, classes, groups, addresses)
+	require.NoError(t, err)
+
+	names := []string{}
+	for name := range s.Filter(testInventory()) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestSelector(t *testing.T) {
+	// Everything
+	require.Len(t, selectNames(t, nil, nil, nil, nil, nil), 5)
+
+	// Multiple globs and exclusions
+	require.Equal(t, []string{"site-a/access-sw-01", "site-a/core-sw-01", "site-b/core-sw-01"},
+		selectNames(t, []string{"site-a/*", "site-b/*"}, nil, nil, nil, nil))
+	require.Equal(t, []string{"edge-router", "site-a/access-sw-01", "site-a/core-sw-01", "site-b/core-sw-01"},
+		selectNames(t, []string{"!lab/*"}, nil, nil, nil, nil))
+
+	// All core switches except the lab
+	require.Equal(t, []string{"site-a/core-sw-01", "site-b/core-sw-01"},
+		selectNames(t, nil, []string{"core", "!lab"}, nil, nil, nil))
+
+	// Classes and groups
+	require.Equal(t, []string{"lab/core-sw-01", "site-a/access-sw-01", "site-a/core-sw-01"},
+		selectNames(t, nil, nil, []string{"cisco_ios"}, nil, nil))
+	require.Equal(t, []string{"site-a/core-sw-01"},
+		selectNames(t, nil, []string{"core"}, nil, []string{"site-a"}, nil))
+	require.Equal(t, []string{"edge-router"},
+		selectNames(t, nil, nil, []string{"!cisco_ios"}, []string{"!site-b"}, nil))
+
+	// Addresses, CIDR ranges and hostnames
+	require.Equal(t, []string{"site-a/access-sw-01", "site-a/core-sw-01"},
+		selectNames(t, nil, nil, nil, nil, []string{"10.1.0.0/16"}))
+	require.Equal(t, []string{"edge-router", "site-b/core-sw-01"},
+		selectNames(t, nil, nil, nil, nil, []string{"10.2.0.1", "2001:db8::/32"}))
+	require.Equal(t, []string{"lab/core-sw-01"},
+		selectNames(t, nil, nil, nil, nil, []string{"LAB-SW.example.com"}))
+	require.Equal(t, []string{"edge-router", "lab/core-sw-01", "site-b/core-sw-01"},
+		selectNames(t, nil, nil, nil, nil, []string{"!10.1.0.0/16"}))
+}
+
+func TestSelector_InvalidAddress(t *testing.T) {
+	_, err := NewSelector(nil, nil, nil, nil, []string{"10.1.0.0/33"})
+	require.Error(t, err)
+
+	_, err = NewSelector(nil, nil, nil, nil, []string{"!"})
+	require.Error(t, err)
+}
@@ -1,31 +1,33 @@
 module github.com/samhug/ndm
 
+go 1.21
+
 require (
-	github.com/armon/go-radix v1.0.0 // indirect
 	github.com/davecgh/go-spew v1.1.1
 	github.com/go-errors/errors v1.0.1
 	github.com/hashicorp/go-multierror v1.0.0
 	github.com/hashicorp/go-uuid v1.0.1
 	github.com/hashicorp/hcl v1.0.0
-	github.com/inconshreveable/mousetrap v1.0.0 // indirect
-	github.com/mattn/go-colorable v0.1.0 // indirect
-	github.com/mattn/go-isatty v0.0.4 // indirect
-	github.com/mitchellh/cli v1.0.0
 	github.com/mitchellh/mapstructure v1.1.2
 	github.com/pin/tftp v2.1.0+incompatible
 	github.com/pkg/errors v0.8.1
-	github.com/posener/complete v1.2.1 // indirect
+	github.com/pkg/sftp v1.10.0
 	github.com/robertkrimen/otto v0.0.0-20180617131154-15f95af6e78d
 	github.com/ryanuber/go-glob v1.0.0
-	github.com/samhug/gexpect v0.0.0-20170104164653-a59f32cf7d8c
-	github.com/samuelhug/gexpect v0.0.0-20170104164653-a59f32cf7d8c
-	github.com/samuelhug/ndm v0.1.1
 	github.com/segmentio/go-prompt v1.2.1-0.20161017233205-f0d19b6901ad
 	github.com/spf13/cobra v0.0.3
-	github.com/spf13/pflag v1.0.3 // indirect
-	github.com/stretchr/objx v0.1.1 // indirect
 	github.com/stretchr/testify v1.3.0
 	github.com/tobischo/gokeepasslib v1.0.0
 	golang.org/x/crypto v0.0.0-20190219172222-a4c6cb3142f2
+)
+
+require (
+	github.com/hashicorp/errwrap v1.0.0 // indirect
+	github.com/howeyc/gopass v0.0.0-20170109162249-bf9dde6d0d2c // indirect
+	github.com/inconshreveable/mousetrap v1.0.0 // indirect
+	github.com/kr/fs v0.1.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/spf13/pflag v1.0.3 // indirect
 	golang.org/x/sys v0.0.0-20190221075227-b4e8571b14e0 // indirect
+	gopkg.in/sourcemap.v1 v1.0.5 // indirect
 )
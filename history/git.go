@@ -0,0 +1,301 @@
+package history
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/go-errors/errors"
+	"os"
+	"os/exec"
+	"os/user"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// emptyTree is the hash of git's empty tree object, used as the snapshot preceding all others
+const emptyTree = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+
+// GitRepo records the history of a backup directory in a git repository
+type GitRepo struct {
+	dir string
+}
+
+// OpenGitRepo: Opens the git repository at dir, initializing a new repository if one doesn't exist
+func OpenGitRepo(dir string) (*GitRepo, error) {
+	if _, err := exec.LookPath("git"); err != nil {
+		return nil, errors.Errorf("Unable to find the git executable: %s", err)
+	}
+
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, errors.Errorf("Unable to create directory '%s': %s", dir, err)
+	}
+
+	r := &GitRepo{dir: dir}
+
+	if _, err := os.Stat(path.Join(dir, ".git")); os.IsNotExist(err) {
+		if _, err := r.git(nil, "init", "--quiet"); err != nil {
+			return nil, errors.Errorf("Unable to initialize git repository in '%s': %s", dir, err)
+		}
+	}
+
+	return r, nil
+}
+
+// Dir returns the path of the repository's working tree
+func (r *GitRepo) Dir() string {
+	return r.dir
+}
+
+// Stage adds the files at paths, relative to the working tree, to the index and returns those that changed. Other
+// files in the working tree are left alone.
+func (r *GitRepo) Stage(paths []string) ([]string, error) {
+	if len(paths) == 0 {
+		return nil, nil
+	}
+
+	if _, err := r.git(nil, append([]string{"add", "--"}, paths...)...); err != nil {
+		return nil, errors.Errorf("Unable to stage changes: %s", err)
+	}
+
+	out, err := r.git(nil, append([]string{"diff", "--cached", "--name-only", "--no-renames", "-z", "--"}, paths...)...)
+	if err != nil {
+		return nil, errors.Errorf("Unable to list staged changes: %s", err)
+	}
+
+	return split(out, "\x00"), nil
+}
+
+// Commit records the staged changes to paths as a new commit, changes staged to other files aren't committed. If paths
+// is empty, all of the staged changes are committed.
+func (r *GitRepo) Commit(author string, when time.Time, message string, paths []string) error {
+	name, email := parseAuthor(author)
+
+	date := when.Format(time.RFC3339)
+	env := []string{
+		"GIT_AUTHOR_NAME=" + name,
+		"GIT_AUTHOR_EMAIL=" + email,
+		"GIT_AUTHOR_DATE=" + date,
+		"GIT_COMMITTER_NAME=" + name,
+		"GIT_COMMITTER_EMAIL=" + email,
+		"GIT_COMMITTER_DATE=" + date,
+	}
+
+	args := append([]string{"commit", "--quiet", "--no-verify", "--message", message, "--"}, paths...)
+	if _, err := r.git(env, args...); err != nil {
+		return errors.Errorf("Unable to commit changes: %s", err)
+	}
+
+	return nil
+}
+
+// CommitRun stages the configs written by a run, at paths relative to the working tree, and, if any changed, commits
+// them with a message listing the devices whose configs changed. It returns the list of changed devices.
+func (r *GitRepo) CommitRun(author string, started time.Time, paths []string) ([]string, error) {
+	changed, err := r.Stage(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	devices := ChangedDevices(changed)
+	if len(devices) == 0 {
+		return nil, nil
+	}
+
+	if err := r.Commit(author, started, runMessage(started, devices), changed); err != nil {
+		return nil, err
+	}
+
+	return devices, nil
+}
+
+// ResolveSnapshot resolves since, a git revision, to the snapshot the backup directory was in at that point. An empty
+// since selects the snapshot prior to the most recent one, or the empty tree if only one snapshot has been recorded,
+// so every config is reported as new.
+func (r *GitRepo) ResolveSnapshot(since string) (string, error) {
+	if _, err := r.git(nil, "rev-parse", "--quiet", "--verify", "HEAD"); err != nil {
+		return "", errors.Errorf("No snapshots have been recorded in '%s'", r.dir)
+	}
+
+	rev := since
+	if rev == "" {
+		rev = "HEAD~1"
+	}
+
+	out, err := r.git(nil, "rev-parse", "--quiet", "--verify", rev+"^{commit}")
+	if err != nil {
+		if since == "" {
+			return emptyTree, nil
+		}
+		return "", errors.Errorf("Unknown revision '%s'", since)
+	}
+
+	return strings.TrimSpace(out), nil
+}
+
+// ResolveSince resolves since, either a date accepted by ParseDate or a git revision, to a snapshot. A date selects the
+// last snapshot before it, see SnapshotBefore, anything else is resolved by ResolveSnapshot.
+func (r *GitRepo) ResolveSince(since string, now time.Time) (string, error) {
+	if when, err := ParseDate(since, now); err == nil {
+		return r.SnapshotBefore(when)
+	}
+	return r.ResolveSnapshot(since)
+}
+
+// SnapshotBefore returns the most recent snapshot recorded before when. If there isn't one the empty tree is
+// returned, so every config is reported as new.
+func (r *GitRepo) SnapshotBefore(when time.Time) (string, error) {
+	if _, err := r.git(nil, "rev-parse", "--quiet", "--verify", "HEAD"); err != nil {
+		return "", errors.Errorf("No snapshots have been recorded in '%s'", r.dir)
+	}
+
+	out, err := r.git(nil, "rev-list", "-1", "--before="+when.Format(time.RFC3339), "HEAD")
+	if err != nil {
+		return "", errors.Errorf("Unable to find a snapshot before %s: %s", when.Format(time.RFC3339), err)
+	}
+
+	if rev := strings.TrimSpace(out); rev != "" {
+		return rev, nil
+	}
+
+	return emptyTree, nil
+}
+
+// dateLayouts are the absolute date formats accepted by ParseDate, in local time unless a zone is given
+var dateLayouts = []string{
+	"2006-01-02",
+	"2006-01-02 15:04",
+	"2006-01-02 15:04:05",
+	time.RFC3339,
+}
+
+// ParseDate parses s as either an absolute date, such as "2019-02-01" or "2019-02-01 15:04", or a time before now,
+// given as a number followed by a unit of 'm' (minutes), 'h' (hours), 'd' (days) or 'w' (weeks), such as "7d".
+func ParseDate(s string, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+
+	for _, layout := range dateLayouts {
+		if t, err := time.ParseInLocation(layout, s, now.Location()); err == nil {
+			return t, nil
+		}
+	}
+
+	units := map[byte]time.Duration{
+		'm': time.Minute,
+		'h': time.Hour,
+		'd': 24 * time.Hour,
+		'w': 7 * 24 * time.Hour,
+	}
+	if len(s) > 1 {
+		if unit, ok := units[s[len(s)-1]]; ok {
+			if n, err := strconv.Atoi(s[:len(s)-1]); err == nil && n >= 0 {
+				return now.Add(-time.Duration(n) * unit), nil
+			}
+		}
+	}
+
+	return time.Time{}, errors.Errorf("Invalid date '%s', expected a date like 2019-02-01 or 2019-02-01 15:04, or a time ago like 12h, 7d or 2w", s)
+}
+
+// Diff returns a unified diff of the given paths between two snapshots
+func (r *GitRepo) Diff(from string, to string, paths []string) (string, error) {
+	args := []string{"diff", "--no-color", "--no-ext-diff", from, to, "--"}
+	args = append(args, paths...)
+
+	out, err := r.git(nil, args...)
+	if err != nil {
+		return "", errors.Errorf("Unable to diff snapshots: %s", err)
+	}
+
+	return out, nil
+}
+
+// ChangedDevices maps a list of changed backup files, of the form '<device>/<target>.conf', to the sorted list of
+// device names they belong to
+func ChangedDevices(paths []string) []string {
+	seen := make(map[string]struct{})
+	devices := []string{}
+
+	for _, p := range paths {
+		device := path.Dir(p)
+		if device == "." {
+			continue
+		}
+		if _, ok := seen[device]; ok {
+			continue
+		}
+		seen[device] = struct{}{}
+		devices = append(devices, device)
+	}
+
+	sort.Strings(devices)
+	return devices
+}
+
+// DefaultAuthor returns an author string identifying the user running ndm
+func DefaultAuthor() string {
+	name := "ndm"
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		name = u.Username
+	}
+
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "localhost"
+	}
+
+	return fmt.Sprintf("%s <%s@%s>", name, name, host)
+}
+
+func runMessage(started time.Time, devices []string) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "ndm backup %s\n\n", started.Format(time.RFC3339))
+	fmt.Fprintf(&b, "Changed devices (%d):\n", len(devices))
+	for _, device := range devices {
+		fmt.Fprintf(&b, "  %s\n", device)
+	}
+
+	return b.String()
+}
+
+// parseAuthor splits an author string of the form "Name <email>" into its parts
+func parseAuthor(author string) (string, string) {
+	start := strings.Index(author, "<")
+	end := strings.LastIndex(author, ">")
+	if start < 0 || end < start {
+		return strings.TrimSpace(author), ""
+	}
+	return strings.TrimSpace(author[:start]), strings.TrimSpace(author[start+1 : end])
+}
+
+func (r *GitRepo) git(env []string, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", r.dir}, args...)...)
+	cmd.Env = append(os.Environ(), env...)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return "", errors.Errorf("git %s: %s", args[0], msg)
+	}
+
+	return stdout.String(), nil
+}
+
+// split splits s on sep, dropping empty entries
+func split(s string, sep string) []string {
+	parts := []string{}
+	for _, part := range strings.Split(s, sep) {
+		if part = strings.TrimSpace(part); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
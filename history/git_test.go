@@ -0,0 +1,221 @@
+package history
+
+import (
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestRepo(t *testing.T) (*GitRepo, func()) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git executable not available")
+	}
+
+	dir, err := ioutil.TempDir("", "ndm-history")
+	require.NoError(t, err)
+
+	repo, err := OpenGitRepo(dir)
+	require.NoError(t, err)
+
+	return repo, func() { os.RemoveAll(dir) }
+}
+
+func writeTestFile(t *testing.T, repo *GitRepo, name string, data string) {
+	p := path.Join(repo.Dir(), name)
+	require.NoError(t, os.MkdirAll(path.Dir(p), os.ModePerm))
+	require.NoError(t, ioutil.WriteFile(p, []byte(data), 0644))
+}
+
+func TestChangedDevices(t *testing.T) {
+	devices := ChangedDevices([]string{
+		"site-b/sw-01/running_config.conf",
+		"router-01/startup_config.conf",
+		"site-b/sw-01/startup_config.conf",
+		"README",
+	})
+	require.Equal(t, []string{"router-01", "site-b/sw-01"}, devices)
+}
+
+func TestParseAuthor(t *testing.T) {
+	name, email := parseAuthor("Backup Bot <backup@example.com>")
+	require.Equal(t, "Backup Bot", name)
+	require.Equal(t, "backup@example.com", email)
+
+	name, email = parseAuthor("ndm")
+	require.Equal(t, "ndm", name)
+	require.Equal(t, "", email)
+}
+
+func TestGitRepo_CommitRun(t *testing.T) {
+	repo, cleanup := newTestRepo(t)
+	defer cleanup()
+
+	author := "Backup Bot <backup@example.com>"
+	started := time.Date(2019, 2, 1, 3, 4, 5, 0, time.UTC)
+
+	writeTestFile(t, repo, "site-a/sw-01/running_config.conf", "hostname sw-01\n")
+	writeTestFile(t, repo, "router-01/running_config.conf", "hostname router-01\n")
+
+	// Files the run didn't write aren't recorded, even if they're already staged
+	writeTestFile(t, repo, "notes.txt", "not a config\n")
+	writeTestFile(t, repo, "router-02/running_config.conf", "hostname router-02\n")
+	_, err := repo.git(nil, "add", "router-02/running_config.conf")
+	require.NoError(t, err)
+
+	changed, err := repo.CommitRun(author, started, []string{"site-a/sw-01/running_config.conf", "router-01/running_config.conf"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"router-01", "site-a/sw-01"}, changed)
+
+	files, err := repo.git(nil, "ls-tree", "-r", "--name-only", "HEAD")
+	require.NoError(t, err)
+	require.Equal(t, "router-01/running_config.conf\nsite-a/sw-01/running_config.conf\n", files)
+
+	msg, err := repo.git(nil, "log", "-1", "--format=%an <%ae> %aI%n%B")
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(msg, "Backup Bot <backup@example.com> 2019-02-01T03:04:05+00:00\n"))
+	require.Contains(t, msg, "  router-01\n")
+	require.Contains(t, msg, "  site-a/sw-01\n")
+
+	// Rewriting identical content shouldn't produce a commit
+	writeTestFile(t, repo, "router-01/running_config.conf", "hostname router-01\n")
+
+	changed, err = repo.CommitRun(author, started.Add(time.Hour), []string{"router-01/running_config.conf"})
+	require.NoError(t, err)
+	require.Empty(t, changed)
+
+	count, err := repo.git(nil, "rev-list", "--count", "HEAD")
+	require.NoError(t, err)
+	require.Equal(t, "1", strings.TrimSpace(count))
+
+	// Only the device whose config changed is listed
+	writeTestFile(t, repo, "router-01/running_config.conf", "hostname router-02\n")
+
+	changed, err = repo.CommitRun(author, started.Add(2*time.Hour), []string{"router-01/running_config.conf"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"router-01"}, changed)
+}
+
+func TestGitRepo_Diff(t *testing.T) {
+	repo, cleanup := newTestRepo(t)
+	defer cleanup()
+
+	author := "Backup Bot <backup@example.com>"
+	started := time.Date(2019, 2, 1, 3, 4, 5, 0, time.UTC)
+
+	// No snapshots have been recorded yet
+	_, err := repo.ResolveSnapshot("")
+	require.Error(t, err)
+
+	writeTestFile(t, repo, "router-01/running_config.conf", "hostname router-01\n")
+	writeTestFile(t, repo, "router-02/running_config.conf", "hostname router-02\n")
+	configs := []string{"router-01/running_config.conf", "router-02/running_config.conf"}
+	_, err = repo.CommitRun(author, started, configs)
+	require.NoError(t, err)
+
+	writeTestFile(t, repo, "router-01/running_config.conf", "hostname core-01\n")
+	writeTestFile(t, repo, "router-02/running_config.conf", "hostname core-02\n")
+	_, err = repo.CommitRun(author, started.Add(48*time.Hour), configs)
+	require.NoError(t, err)
+
+	// The default snapshot is the previous run
+	from, err := repo.ResolveSnapshot("")
+	require.NoError(t, err)
+
+	diff, err := repo.Diff(from, "HEAD", []string{"router-01/"})
+	require.NoError(t, err)
+	require.Contains(t, diff, "-hostname router-01\n+hostname core-01\n")
+	require.NotContains(t, diff, "router-02")
+
+	// A date before the first snapshot compares against an empty backup directory
+	from, err = repo.SnapshotBefore(time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	require.Equal(t, emptyTree, from)
+
+	diff, err = repo.Diff(from, "HEAD", []string{"router-02/running_config.conf"})
+	require.NoError(t, err)
+	require.Contains(t, diff, "+hostname core-02\n")
+
+	// A date between the runs selects the first snapshot
+	from, err = repo.SnapshotBefore(time.Date(2019, 2, 2, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+
+	diff, err = repo.Diff(from, "HEAD", []string{"router-02/"})
+	require.NoError(t, err)
+	require.Contains(t, diff, "-hostname router-02\n+hostname core-02\n")
+
+	// --since takes either a date or a revision
+	now := started.Add(72 * time.Hour)
+	since, err := repo.ResolveSince("2019-02-02", now)
+	require.NoError(t, err)
+	require.Equal(t, from, since)
+
+	since, err = repo.ResolveSince("2d", now)
+	require.NoError(t, err)
+	require.Equal(t, from, since)
+
+	since, err = repo.ResolveSince("HEAD~1", now)
+	require.NoError(t, err)
+	require.Equal(t, from, since)
+
+	since, err = repo.ResolveSince("", now)
+	require.NoError(t, err)
+	require.Equal(t, from, since)
+
+	_, err = repo.ResolveSince("notarevision", now)
+	require.Error(t, err)
+
+	// Dates aren't revisions
+	_, err = repo.ResolveSnapshot("notarevision")
+	require.Error(t, err)
+	_, err = repo.ResolveSnapshot("2019-02-02")
+	require.Error(t, err)
+}
+
+func TestGitRepo_SingleSnapshot(t *testing.T) {
+	repo, cleanup := newTestRepo(t)
+	defer cleanup()
+
+	writeTestFile(t, repo, "router-01/running_config.conf", "hostname router-01\n")
+	_, err := repo.CommitRun("Backup Bot <backup@example.com>", time.Date(2019, 2, 1, 3, 4, 5, 0, time.UTC),
+		[]string{"router-01/running_config.conf"})
+	require.NoError(t, err)
+
+	// With only one snapshot the default compares against an empty backup directory
+	from, err := repo.ResolveSnapshot("")
+	require.NoError(t, err)
+	require.Equal(t, emptyTree, from)
+
+	diff, err := repo.Diff(from, "HEAD", []string{"router-01/"})
+	require.NoError(t, err)
+	require.Contains(t, diff, "+hostname router-01\n")
+}
+
+func TestParseDate(t *testing.T) {
+	now := time.Date(2019, 2, 10, 12, 0, 0, 0, time.UTC)
+
+	for s, expected := range map[string]time.Time{
+		"2019-02-01":           time.Date(2019, 2, 1, 0, 0, 0, 0, time.UTC),
+		"2019-02-01 15:04":     time.Date(2019, 2, 1, 15, 4, 0, 0, time.UTC),
+		"2019-02-01 15:04:05":  time.Date(2019, 2, 1, 15, 4, 5, 0, time.UTC),
+		"2019-02-01T15:04:05Z": time.Date(2019, 2, 1, 15, 4, 5, 0, time.UTC),
+		"30m":                  now.Add(-30 * time.Minute),
+		"12h":                  now.Add(-12 * time.Hour),
+		"7d":                   time.Date(2019, 2, 3, 12, 0, 0, 0, time.UTC),
+		"2w":                   time.Date(2019, 1, 27, 12, 0, 0, 0, time.UTC),
+	} {
+		when, err := ParseDate(s, now)
+		require.NoError(t, err, s)
+		require.True(t, expected.Equal(when), "%s: expected %s, got %s", s, expected, when)
+	}
+
+	// Revisions and mistyped dates are rejected rather than treated as now
+	for _, s := range []string{"HEAD~1", "master", "some-branch", "release/1.0", "2019-02-31", "7", "d", "last tusday"} {
+		_, err := ParseDate(s, now)
+		require.Error(t, err, s)
+	}
+}
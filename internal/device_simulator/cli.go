@@ -0,0 +1,308 @@
+package device_simulator
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/go-errors/errors"
+	"github.com/pin/tftp"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pagerPrompt is shown when paged output is paused
+const pagerPrompt = " --More-- "
+
+// tftpPort is used for uploads to hosts given without a port
+const tftpPort = "69"
+
+func newCLI(sim *Simulator, rw io.ReadWriter) *cli {
+	return &cli{
+		sim:        sim,
+		device:     sim.device,
+		r:          bufio.NewReader(rw),
+		w:          rw,
+		privileged: sim.device.EnablePassword == "",
+		pagerLines: sim.device.PagerLines,
+	}
+}
+
+// cli emulates a Cisco style command line over a shell session. Input is echoed back, and the prompt ends with '>' in
+// user mode or '#' in privileged mode.
+type cli struct {
+	sim        *Simulator
+	device     Device
+	r          *bufio.Reader
+	w          io.Writer
+	privileged bool
+	// pagerLines is the number of lines of output shown between pager prompts, zero disables paging. It starts as
+	// Device.PagerLines and is changed with 'terminal length <lines>'.
+	pagerLines int
+	// lastCR is set when the previous line ended with a carriage return, so a following line feed is ignored
+	lastCR bool
+}
+
+func (c *cli) run() {
+	c.write("\r\n")
+
+	for {
+		c.write(c.prompt())
+
+		line, err := c.readLine()
+		if err != nil {
+			return
+		}
+		c.write(line + "\r\n")
+
+		command := strings.TrimSpace(line)
+		if command == "" {
+			continue
+		}
+		c.sim.recordCommand(command)
+
+		if !c.execute(command) {
+			return
+		}
+	}
+}
+
+func (c *cli) prompt() string {
+	if c.privileged {
+		return c.device.Hostname + "#"
+	}
+	return c.device.Hostname + ">"
+}
+
+// execute runs a command and reports whether the session should continue
+func (c *cli) execute(command string) bool {
+	fields := strings.Fields(command)
+
+	switch fields[0] {
+	case "exit", "logout", "quit":
+		return false
+	case "enable":
+		return c.enable()
+	case "disable":
+		c.privileged = false
+	case "terminal":
+		lines, err := strconv.Atoi(fields[len(fields)-1])
+		if len(fields) != 3 || fields[1] != "length" || err != nil || lines < 0 {
+			c.write("% Invalid input detected\r\n")
+			break
+		}
+		c.pagerLines = lines
+	case "copy":
+		if !c.privileged {
+			c.write("% Privileged mode required\r\n")
+			break
+		}
+		if len(fields) != 3 {
+			c.write("% Incomplete command.\r\n")
+			break
+		}
+		return c.copy(fields[1], fields[2])
+	default:
+		output, ok := c.device.Commands[command]
+		if !ok {
+			c.write("% Invalid input detected\r\n")
+			break
+		}
+		return c.writeOutput(output)
+	}
+
+	return true
+}
+
+func (c *cli) enable() bool {
+	if c.privileged {
+		return true
+	}
+
+	// The password isn't echoed
+	c.write("Password:")
+	password, err := c.readLine()
+	if err != nil {
+		return false
+	}
+	c.write("\r\n")
+
+	if password != c.device.EnablePassword {
+		c.write("% Access denied\r\n")
+		return true
+	}
+
+	c.privileged = true
+	return true
+}
+
+// copy uploads a file to a TFTP server. The destination is either a tftp:// URL or just "tftp", missing details are
+// asked for interactively.
+func (c *cli) copy(src string, dst string) bool {
+	data, ok := c.device.Files[src]
+	if !ok {
+		c.write(fmt.Sprintf("%%Error opening %s (No such file or directory)\r\n", src))
+		return true
+	}
+
+	var host, filename string
+	if dst != "tftp" {
+		u, err := url.Parse(dst)
+		if err != nil || u.Scheme != "tftp" {
+			c.write("% Invalid destination\r\n")
+			return true
+		}
+		host = u.Host
+		filename = strings.TrimPrefix(u.Path, "/")
+	}
+
+	host, err := c.ask("Address or name of remote host", host)
+	if err != nil {
+		return false
+	}
+	filename, err = c.ask("Destination filename", filename)
+	if err != nil {
+		return false
+	}
+
+	if host == "" || filename == "" {
+		c.write("% Incomplete command.\r\n")
+		return true
+	}
+
+	started := time.Now()
+	if err := upload(host, filename, data); err != nil {
+		c.write(fmt.Sprintf("%%Error writing tftp://%s/%s (%s)\r\n", host, filename, err))
+		return true
+	}
+
+	c.write(fmt.Sprintf("!!\r\n%d bytes copied in %.3f secs\r\n", len(data), time.Since(started).Seconds()))
+	return true
+}
+
+// ask prompts for a value, offering def as the default that's used if the answer is empty
+func (c *cli) ask(question string, def string) (string, error) {
+	if def != "" {
+		c.write(fmt.Sprintf("%s [%s]? ", question, def))
+	} else {
+		c.write(fmt.Sprintf("%s? ", question))
+	}
+
+	answer, err := c.readLine()
+	if err != nil {
+		return "", err
+	}
+	c.write(answer + "\r\n")
+
+	if answer = strings.TrimSpace(answer); answer == "" {
+		return def, nil
+	}
+	return answer, nil
+}
+
+// writeOutput writes command output, pausing every pagerLines lines until a key is pressed. Pressing 'q' discards
+// the rest of the output.
+func (c *cli) writeOutput(output string) bool {
+	lines := strings.SplitAfter(strings.Replace(output, "\r\n", "\n", -1), "\n")
+
+	for i, line := range lines {
+		if c.pagerLines > 0 && i > 0 && i%c.pagerLines == 0 && line != "" {
+			c.write(pagerPrompt)
+
+			key, err := c.readKey()
+			if err != nil {
+				return false
+			}
+
+			// Erase the prompt like a real device does
+			n := len(pagerPrompt)
+			c.write(strings.Repeat("\b", n) + strings.Repeat(" ", n) + strings.Repeat("\b", n))
+
+			if key == 'q' {
+				return true
+			}
+		}
+
+		c.write(strings.Replace(line, "\n", "\r\n", -1))
+	}
+
+	if !strings.HasSuffix(output, "\n") {
+		c.write("\r\n")
+	}
+
+	return true
+}
+
+// readLine reads a line terminated by a carriage return, a line feed, or both
+func (c *cli) readLine() (string, error) {
+	var line []byte
+	for {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+
+		switch b {
+		case '\n':
+			if c.lastCR && len(line) == 0 {
+				c.lastCR = false
+				continue
+			}
+			c.lastCR = false
+			return string(line), nil
+		case '\r':
+			c.lastCR = true
+			return string(line), nil
+		default:
+			c.lastCR = false
+			line = append(line, b)
+		}
+	}
+}
+
+// readKey reads a single key press, skipping the line feed that may follow the carriage return ending the last line
+func (c *cli) readKey() (byte, error) {
+	for {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+
+		skip := c.lastCR && b == '\n'
+		c.lastCR = false
+		if !skip {
+			return b, nil
+		}
+	}
+}
+
+func (c *cli) write(s string) {
+	c.w.Write([]byte(s))
+}
+
+// upload sends data to the TFTP server at host, which may include a port
+func upload(host string, filename string, data string) error {
+	addr := host
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		addr = net.JoinHostPort(host, tftpPort)
+	}
+
+	client, err := tftp.NewClient(addr)
+	if err != nil {
+		return err
+	}
+	client.SetTimeout(5 * time.Second)
+
+	rf, err := client.Send(filename, "octet")
+	if err != nil {
+		return errors.Errorf("Unable to start transfer: %s", err)
+	}
+
+	if _, err := rf.ReadFrom(strings.NewReader(data)); err != nil {
+		return errors.Errorf("Transfer failed: %s", err)
+	}
+
+	return nil
+}
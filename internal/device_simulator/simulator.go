@@ -0,0 +1,231 @@
+// Package device_simulator provides an SSH server that emulates the CLI of a network device, so the backup process can
+// be exercised end-to-end in tests without real hardware.
+package device_simulator
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"github.com/go-errors/errors"
+	"golang.org/x/crypto/ssh"
+	"net"
+	"sync"
+)
+
+// DefaultListenAddr is the address the simulator binds to unless Simulator.ListenAddr is changed
+const DefaultListenAddr = "127.0.0.1:0"
+
+// Device describes the device a Simulator emulates
+type Device struct {
+	// Hostname is shown in the CLI prompt
+	Hostname string
+	// Username and Password are the credentials accepted for password authentication
+	Username string
+	Password string
+	// AuthorizedKey, if set, is accepted for public key authentication as Username
+	AuthorizedKey ssh.PublicKey
+	// EnablePassword is required by the enable command. If it's empty sessions start in privileged mode.
+	EnablePassword string
+	// Files holds the files that can be uploaded with 'copy <file> tftp://<host>/<name>', such as "running-config"
+	Files map[string]string
+	// Commands maps the commands the CLI understands to their output
+	Commands map[string]string
+	// PagerLines, if non-zero, pages command output, pausing at a --More-- prompt after this many lines. Sessions can
+	// change it with 'terminal length <lines>', where zero disables paging.
+	PagerLines int
+}
+
+// NewSimulator: Constructs a Simulator for device with a freshly generated host key
+func NewSimulator(device Device) (*Simulator, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, errors.Errorf("Unable to generate host key: %s", err)
+	}
+
+	hostKey, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		return nil, errors.Errorf("Unable to generate host key: %s", err)
+	}
+
+	return &Simulator{
+		ListenAddr: DefaultListenAddr,
+		device:     device,
+		hostKey:    hostKey,
+		mutex:      &sync.Mutex{},
+	}, nil
+}
+
+// Simulator is an SSH server emulating a device's CLI
+type Simulator struct {
+	// ListenAddr is the local address the server binds to
+	ListenAddr string
+	device     Device
+	hostKey    ssh.Signer
+	listener   net.Listener
+	conns      []net.Conn
+	stopped    bool
+	history    []string
+	mutex      *sync.Mutex
+	wg         sync.WaitGroup
+}
+
+// Addr returns the address the simulator is listening on, or an empty string if it isn't running
+func (s *Simulator) Addr() string {
+	if s.listener == nil {
+		return ""
+	}
+	return s.listener.Addr().String()
+}
+
+// HostKey returns the simulator's SSH host key
+func (s *Simulator) HostKey() ssh.PublicKey {
+	return s.hostKey.PublicKey()
+}
+
+// History returns every command entered at the CLI, across all sessions, in the order they were received
+func (s *Simulator) History() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string(nil), s.history...)
+}
+
+// Start binds to ListenAddr and accepts connections in the background
+func (s *Simulator) Start() error {
+	listener, err := net.Listen("tcp", s.ListenAddr)
+	if err != nil {
+		return errors.Errorf("Simulator: unable to listen on %s: %s", s.ListenAddr, err)
+	}
+	s.listener = listener
+
+	s.wg.Add(1)
+	go s.acceptLoop()
+
+	return nil
+}
+
+// Stop closes the listener and any open connections, and waits for their sessions to end
+func (s *Simulator) Stop() {
+	if s.listener == nil {
+		return
+	}
+	s.listener.Close()
+
+	s.mutex.Lock()
+	s.stopped = true
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.mutex.Unlock()
+
+	s.wg.Wait()
+}
+
+func (s *Simulator) serverConfig() *ssh.ServerConfig {
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(meta ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if meta.User() == s.device.Username && string(password) == s.device.Password {
+				return nil, nil
+			}
+			return nil, errors.Errorf("Invalid credentials for '%s'", meta.User())
+		},
+		PublicKeyCallback: func(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			authorized := s.device.AuthorizedKey
+			if authorized != nil && meta.User() == s.device.Username &&
+				string(key.Marshal()) == string(authorized.Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.Errorf("Unauthorized key for '%s'", meta.User())
+		},
+	}
+	config.AddHostKey(s.hostKey)
+
+	// Legacy devices are often limited to this cipher, and ndm enables it for them
+	config.SetDefaults()
+	config.Ciphers = append(config.Ciphers, "3des-cbc")
+
+	return config
+}
+
+func (s *Simulator) acceptLoop() {
+	defer s.wg.Done()
+
+	config := s.serverConfig()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mutex.Lock()
+		if s.stopped {
+			s.mutex.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns = append(s.conns, conn)
+		s.mutex.Unlock()
+
+		s.wg.Add(1)
+		go s.handleConn(conn, config)
+	}
+}
+
+func (s *Simulator) handleConn(conn net.Conn, config *ssh.ServerConfig) {
+	defer s.wg.Done()
+	defer conn.Close()
+
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only session channels are supported")
+			continue
+		}
+
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+
+		s.wg.Add(1)
+		go s.handleSession(channel, requests)
+	}
+}
+
+func (s *Simulator) handleSession(channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer s.wg.Done()
+	defer channel.Close()
+
+	for req := range requests {
+		switch req.Type {
+		case "pty-req", "env", "window-change":
+			req.Reply(true, nil)
+		case "shell":
+			req.Reply(true, nil)
+
+			// Keep replying to requests while the CLI runs
+			go func() {
+				for req := range requests {
+					req.Reply(req.Type == "window-change", nil)
+				}
+			}()
+
+			newCLI(s, channel).run()
+
+			channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+			return
+		default:
+			req.Reply(false, nil)
+		}
+	}
+}
+
+func (s *Simulator) recordCommand(command string) {
+	s.mutex.Lock()
+	s.history = append(s.history, command)
+	s.mutex.Unlock()
+}
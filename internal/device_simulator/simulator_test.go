@@ -0,0 +1,84 @@
+package device_simulator
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"testing"
+)
+
+// runSession logs in to sim, types input at the CLI and returns everything it printed
+func runSession(t *testing.T, sim *Simulator, password string, input string) (string, error) {
+	client, err := ssh.Dial("tcp", sim.Addr(), &ssh.ClientConfig{
+		User:            "admin",
+		Auth:            []ssh.AuthMethod{ssh.Password(password)},
+		HostKeyCallback: ssh.FixedHostKey(sim.HostKey()),
+	})
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	require.NoError(t, err)
+	defer session.Close()
+
+	var output bytes.Buffer
+	session.Stdin = bytes.NewBufferString(input)
+	session.Stdout = &output
+	require.NoError(t, session.RequestPty("xterm", 0, 200, ssh.TerminalModes{}))
+	require.NoError(t, session.Shell())
+	require.NoError(t, session.Wait())
+
+	return output.String(), nil
+}
+
+func TestSimulator_CLI(t *testing.T) {
+	sim, err := NewSimulator(Device{
+		Hostname:       "sw1",
+		Username:       "admin",
+		Password:       "secret",
+		EnablePassword: "enable",
+		Files:          map[string]string{"running-config": "hostname sw1\n"},
+		Commands:       map[string]string{"show version": "Version 1.0\nUptime 1 day\n"},
+		PagerLines:     1,
+	})
+	require.NoError(t, err)
+	require.NoError(t, sim.Start())
+	defer sim.Stop()
+
+	_, err = runSession(t, sim, "wrong", "exit\r\n")
+	require.Error(t, err)
+
+	output, err := runSession(t, sim, "secret", "copy running-config tftp\r\nenable\r\nwrong\r\nenable\r\nenable\r\nshow version\r\n bogus\r\nterminal length 0\r\nshow version\r\nexit\r\n")
+	require.NoError(t, err)
+
+	expected := "\r\nsw1>copy running-config tftp\r\n% Privileged mode required\r\n" +
+		"sw1>enable\r\nPassword:\r\n% Access denied\r\n" +
+		"sw1>enable\r\nPassword:\r\n" +
+		"sw1#show version\r\nVersion 1.0\r\n --More-- \b\b\b\b\b\b\b\b\b\b          \b\b\b\b\b\b\b\b\b\bUptime 1 day\r\n" +
+		"sw1#bogus\r\n% Invalid input detected\r\n" +
+		"sw1#terminal length 0\r\n" +
+		"sw1#show version\r\nVersion 1.0\r\nUptime 1 day\r\n" +
+		"sw1#exit\r\n"
+	require.Equal(t, expected, output)
+
+	require.Equal(t, []string{"copy running-config tftp", "enable", "enable", "show version", "bogus", "terminal length 0", "show version", "exit"}, sim.History())
+}
+
+func TestSimulator_CopyErrors(t *testing.T) {
+	sim, err := NewSimulator(Device{
+		Hostname: "router",
+		Username: "admin",
+		Password: "secret",
+		Files:    map[string]string{"running-config": "hostname router\n"},
+	})
+	require.NoError(t, err)
+	require.NoError(t, sim.Start())
+	defer sim.Stop()
+
+	output, err := runSession(t, sim, "secret", "copy flash:missing tftp\r\ncopy running-config tftp\r\n\r\n\r\nexit\r\n")
+	require.NoError(t, err)
+	require.Contains(t, output, "%Error opening flash:missing (No such file or directory)")
+	require.Contains(t, output, "Address or name of remote host? \r\nDestination filename? \r\n% Incomplete command.")
+}
@@ -0,0 +1,116 @@
+package inventory
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/go-errors/errors"
+	"github.com/samhug/ndm/devices"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// Output formats supported by Write
+const (
+	FormatTable = "table"
+	FormatJSON  = "json"
+	FormatCSV   = "csv"
+)
+
+// Formats lists the supported output formats
+var Formats = []string{FormatTable, FormatJSON, FormatCSV}
+
+// DeviceEntry describes a device as resolved from the configuration. It never holds credentials, only where they are
+// looked up.
+type DeviceEntry struct {
+	Name         string   `json:"name"`
+	Group        string   `json:"group"`
+	Class        string   `json:"class"`
+	Address      string   `json:"address"`
+	AuthProvider string   `json:"auth_provider"`
+	AuthPath     string   `json:"auth_path"`
+	Tags         []string `json:"tags"`
+	Targets      []string `json:"targets"`
+}
+
+// New: Constructs an Inventory of the given devices, ordered by name
+func New(deviceList map[string]*devices.Device) *Inventory {
+	inv := &Inventory{Devices: make([]DeviceEntry, 0, len(deviceList))}
+
+	for _, device := range deviceList {
+		entry := DeviceEntry{
+			Name:         device.Name,
+			Group:        device.Group,
+			Class:        device.ClassName,
+			Address:      device.Address,
+			AuthProvider: device.AuthProviderName,
+			AuthPath:     device.AuthPath,
+			Tags:         append([]string{}, device.Tags...),
+			Targets:      []string{},
+		}
+		if device.Class != nil {
+			for name := range device.Class.Targets {
+				entry.Targets = append(entry.Targets, name)
+			}
+			sort.Strings(entry.Targets)
+		}
+		inv.Devices = append(inv.Devices, entry)
+	}
+
+	sort.Slice(inv.Devices, func(i, j int) bool {
+		return inv.Devices[i].Name < inv.Devices[j].Name
+	})
+
+	return inv
+}
+
+// Inventory lists the devices ndm manages
+type Inventory struct {
+	Devices []DeviceEntry `json:"devices"`
+}
+
+// Write writes the inventory to w in the given format, one of the Format* values
+func (inv *Inventory) Write(w io.Writer, format string) error {
+	switch format {
+	case FormatTable:
+		return inv.WriteTable(w)
+	case FormatJSON:
+		return inv.WriteJSON(w)
+	case FormatCSV:
+		return inv.WriteCSV(w)
+	default:
+		return errors.Errorf("Unsupported format '%s', must be one of: %s", format, strings.Join(Formats, ", "))
+	}
+}
+
+// WriteTable writes a human readable table of the devices to w
+func (inv *Inventory) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DEVICE\tGROUP\tCLASS\tADDRESS\tAUTH\tTAGS\tTARGETS")
+	for _, d := range inv.Devices {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s:%s\t%s\t%s\n", d.Name, d.Group, d.Class, d.Address, d.AuthProvider,
+			d.AuthPath, strings.Join(d.Tags, ","), strings.Join(d.Targets, ","))
+	}
+	return tw.Flush()
+}
+
+// WriteJSON writes the inventory to w as JSON
+func (inv *Inventory) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(inv)
+}
+
+// WriteCSV writes the devices to w as CSV with a header row. Tags and targets are joined with spaces.
+func (inv *Inventory) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"name", "group", "class", "address", "auth_provider", "auth_path", "tags", "targets"})
+	for _, d := range inv.Devices {
+		cw.Write([]string{d.Name, d.Group, d.Class, d.Address, d.AuthProvider, d.AuthPath,
+			strings.Join(d.Tags, " "), strings.Join(d.Targets, " ")})
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,66 @@
+package inventory
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"github.com/samhug/ndm/devices"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func testInventory() *Inventory {
+	class := &devices.DeviceClass{Targets: map[string]*devices.DeviceClassTarget{
+		"startup_config": {Name: "startup_config"},
+		"running_config": {Name: "running_config"},
+	}}
+
+	return New(map[string]*devices.Device{
+		"site-a/router": {Name: "site-a/router", Group: "site-a", Class: class, ClassName: "cisco_isr",
+			Address: "10.1.0.1:22", AuthProviderName: "keepass", AuthPath: "network/router", Tags: []string{"core", "wan"}},
+		"edge": {Name: "edge", Class: class, ClassName: "cisco_isr", Address: "10.0.0.1:22",
+			AuthProviderName: "basic", AuthPath: "edge"},
+	})
+}
+
+func TestInventory_Table(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, testInventory().Write(&buf, FormatTable))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 3)
+	require.Equal(t, []string{"DEVICE", "GROUP", "CLASS", "ADDRESS", "AUTH", "TAGS", "TARGETS"}, strings.Fields(lines[0]))
+	require.Equal(t, []string{"edge", "cisco_isr", "10.0.0.1:22", "basic:edge", "running_config,startup_config"}, strings.Fields(lines[1]))
+	require.Equal(t, []string{"site-a/router", "site-a", "cisco_isr", "10.1.0.1:22", "keepass:network/router", "core,wan",
+		"running_config,startup_config"}, strings.Fields(lines[2]))
+}
+
+func TestInventory_JSON(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, testInventory().Write(&buf, FormatJSON))
+
+	var decoded Inventory
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+	require.Equal(t, testInventory(), &decoded)
+
+	require.Contains(t, buf.String(), `"auth_path": "network/router"`)
+	require.Contains(t, buf.String(), `"tags": []`)
+}
+
+func TestInventory_CSV(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, testInventory().Write(&buf, FormatCSV))
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	require.NoError(t, err)
+	require.Equal(t, [][]string{
+		{"name", "group", "class", "address", "auth_provider", "auth_path", "tags", "targets"},
+		{"edge", "", "cisco_isr", "10.0.0.1:22", "basic", "edge", "", "running_config startup_config"},
+		{"site-a/router", "site-a", "cisco_isr", "10.1.0.1:22", "keepass", "network/router", "core wan", "running_config startup_config"},
+	}, records)
+}
+
+func TestInventory_UnsupportedFormat(t *testing.T) {
+	require.Error(t, testInventory().Write(&bytes.Buffer{}, "yaml"))
+}
@@ -0,0 +1,186 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/go-errors/errors"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Target result statuses
+const (
+	StatusSuccess = "success"
+	StatusFailed  = "failed"
+)
+
+// TargetResult records the outcome of backing up a single target of a device
+type TargetResult struct {
+	Device   string        `json:"device"`
+	Target   string        `json:"target"`
+	Status   string        `json:"status"`
+	Attempts int           `json:"attempts"`
+	Duration time.Duration `json:"-"`
+	// Bytes is the size of the saved config
+	Bytes int `json:"bytes"`
+	// Path is where the config was saved, relative to the backup directory. It's empty if the target failed.
+	Path  string `json:"path,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// MarshalJSON encodes the duration in seconds, which is easier for other tools to consume
+func (r TargetResult) MarshalJSON() ([]byte, error) {
+	type plain TargetResult
+	return json.Marshal(struct {
+		plain
+		DurationSeconds float64 `json:"duration_seconds"`
+	}{plain(r), r.Duration.Seconds()})
+}
+
+// New: Constructs an empty Report for a run that began at started
+func New(started time.Time) *Report {
+	return &Report{
+		Started: started,
+		Targets: []TargetResult{},
+		mutex:   &sync.Mutex{},
+	}
+}
+
+// Report summarizes the outcome of a backup run. Results may be added concurrently.
+type Report struct {
+	Started  time.Time      `json:"started"`
+	Finished time.Time      `json:"finished"`
+	Targets  []TargetResult `json:"targets"`
+	mutex    *sync.Mutex
+}
+
+// Add records the results of a device's targets
+func (r *Report) Add(results ...TargetResult) {
+	r.mutex.Lock()
+	r.Targets = append(r.Targets, results...)
+	r.mutex.Unlock()
+}
+
+// Finish marks the run as complete and orders the results by device and target
+func (r *Report) Finish(finished time.Time) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.Finished = finished
+	sort.Slice(r.Targets, func(i, j int) bool {
+		if r.Targets[i].Device != r.Targets[j].Device {
+			return r.Targets[i].Device < r.Targets[j].Device
+		}
+		return r.Targets[i].Target < r.Targets[j].Target
+	})
+}
+
+// Failed returns the number of targets that failed
+func (r *Report) Failed() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	failed := 0
+	for _, result := range r.Targets {
+		if result.Status != StatusSuccess {
+			failed++
+		}
+	}
+	return failed
+}
+
+// SavedPaths returns the paths of the configs saved by the run, relative to the backup directory
+func (r *Report) SavedPaths() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	paths := []string{}
+	for _, result := range r.Targets {
+		if result.Status == StatusSuccess && result.Path != "" {
+			paths = append(paths, result.Path)
+		}
+	}
+	return paths
+}
+
+// WriteTable writes a human readable summary of the run to w. The table shows the first line of each error, the
+// failures are listed in full after it so they aren't lost among the interleaved log output of the run.
+func (r *Report) WriteTable(w io.Writer) error {
+	failed := r.Failed()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DEVICE\tTARGET\tSTATUS\tATTEMPTS\tDURATION\tBYTES\tERROR")
+	for _, result := range r.Targets {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%d\t%s\n", result.Device, result.Target, result.Status, result.Attempts,
+			result.Duration.Round(time.Millisecond), result.Bytes, firstLine(result.Error))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprintf(w, "\n%d target(s): %d succeeded, %d failed in %s\n", len(r.Targets), len(r.Targets)-failed,
+		failed, r.Finished.Sub(r.Started).Round(time.Second)); err != nil {
+		return err
+	}
+
+	if failed == 0 {
+		return nil
+	}
+
+	fmt.Fprintln(w, "\nFailed targets:")
+	for _, result := range r.Targets {
+		if result.Status == StatusSuccess {
+			continue
+		}
+		message := strings.Replace(strings.TrimRight(result.Error, "\n"), "\n", "\n    ", -1)
+		if _, err := fmt.Fprintf(w, "  %s %s: %s\n", result.Device, result.Target, message); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// firstLine returns the first line of s, marking that there's more if it has several
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return strings.TrimRight(s[:i], "\r") + " ..."
+	}
+	return s
+}
+
+// WriteJSON writes the report to w as JSON
+func (r *Report) WriteJSON(w io.Writer) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// SaveJSON writes the report as JSON to the file at path
+func (r *Report) SaveJSON(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return errors.Errorf("Unable to create report file '%s': %s", path, err)
+	}
+
+	if err := r.WriteJSON(f); err != nil {
+		f.Close()
+		return errors.Errorf("Unable to write report to '%s': %s", path, err)
+	}
+	// The report may not be on disk until the file is closed
+	if err := f.Close(); err != nil {
+		return errors.Errorf("Unable to write report to '%s': %s", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testReport() *Report {
+	started := time.Date(2018, 3, 1, 2, 0, 0, 0, time.UTC)
+
+	r := New(started)
+	r.Add(TargetResult{Device: "site-b/switch", Target: "running", Status: StatusFailed, Attempts: 3,
+		Duration: 95 * time.Second, Error: "Unable to connect: i/o timeout"})
+	r.Add(
+		TargetResult{Device: "site-a/router", Target: "startup", Status: StatusSuccess, Attempts: 1,
+			Duration: 1500 * time.Millisecond, Bytes: 2048, Path: "site-a/router/startup.conf"},
+		TargetResult{Device: "site-a/router", Target: "running", Status: StatusSuccess, Attempts: 2,
+			Duration: 12 * time.Second, Bytes: 4096, Path: "site-a/router/running.conf"},
+	)
+	r.Finish(started.Add(2 * time.Minute))
+
+	return r
+}
+
+func TestReport_Table(t *testing.T) {
+	r := testReport()
+	require.Equal(t, 1, r.Failed())
+
+	var buf bytes.Buffer
+	require.NoError(t, r.WriteTable(&buf))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 9)
+
+	require.Equal(t, []string{"DEVICE", "TARGET", "STATUS", "ATTEMPTS", "DURATION", "BYTES", "ERROR"}, strings.Fields(lines[0]))
+
+	// Results are ordered by device then target
+	require.Equal(t, []string{"site-a/router", "running", "success", "2", "12s", "4096"}, strings.Fields(lines[1]))
+	require.Equal(t, []string{"site-a/router", "startup", "success", "1", "1.5s", "2048"}, strings.Fields(lines[2]))
+	require.Contains(t, lines[3], "Unable to connect: i/o timeout")
+
+	require.Equal(t, "3 target(s): 2 succeeded, 1 failed in 2m0s", lines[5])
+
+	// The failures are listed again in full after the totals
+	require.Equal(t, "Failed targets:", lines[7])
+	require.Equal(t, "  site-b/switch running: Unable to connect: i/o timeout", lines[8])
+}
+
+func TestReport_TableMultilineError(t *testing.T) {
+	r := New(time.Date(2018, 3, 1, 2, 0, 0, 0, time.UTC))
+	r.Add(TargetResult{Device: "site-a/router", Target: "running", Status: StatusFailed, Attempts: 1,
+		Error: "Timed out after 15s waiting for '#'.\nOutput:\nrouter>"})
+	r.Finish(r.Started.Add(time.Minute))
+
+	var buf bytes.Buffer
+	require.NoError(t, r.WriteTable(&buf))
+
+	// Only the first line of the error goes in the table, keeping its columns aligned
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 9)
+	require.True(t, strings.HasSuffix(lines[1], "Timed out after 15s waiting for '#'. ..."), lines[1])
+	require.Equal(t, []string{
+		"  site-a/router running: Timed out after 15s waiting for '#'.",
+		"    Output:",
+		"    router>",
+	}, lines[6:])
+}
+
+func TestReport_JSON(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ndm-report")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	reportPath := path.Join(dir, "report.json")
+	require.NoError(t, testReport().SaveJSON(reportPath))
+
+	data, err := ioutil.ReadFile(reportPath)
+	require.NoError(t, err)
+
+	var decoded struct {
+		Started  time.Time
+		Finished time.Time
+		Targets  []map[string]interface{}
+	}
+	require.NoError(t, json.Unmarshal(data, &decoded))
+
+	require.Equal(t, 2*time.Minute, decoded.Finished.Sub(decoded.Started))
+	require.Len(t, decoded.Targets, 3)
+	require.Equal(t, map[string]interface{}{
+		"device":           "site-b/switch",
+		"target":           "running",
+		"status":           "failed",
+		"attempts":         float64(3),
+		"bytes":            float64(0),
+		"error":            "Unable to connect: i/o timeout",
+		"duration_seconds": float64(95),
+	}, decoded.Targets[2])
+
+	// Successful targets have no error
+	require.NotContains(t, decoded.Targets[0], "error")
+	require.Equal(t, "site-a/router/running.conf", decoded.Targets[0]["path"])
+}
+
+func TestReport_SavedPaths(t *testing.T) {
+	require.Equal(t, []string{"site-a/router/running.conf", "site-a/router/startup.conf"}, testReport().SavedPaths())
+}
@@ -0,0 +1,87 @@
+package scheduler
+
+import (
+	"sync"
+)
+
+// NewScheduler: Constructs a Scheduler that runs at most maxParallel jobs at once. A maxParallel of zero means no limit.
+func NewScheduler(maxParallel int) *Scheduler {
+	return &Scheduler{
+		maxParallel:  maxParallel,
+		groupLimits:  make(map[string]int),
+		groupRunning: make(map[string]int),
+		mutex:        &sync.Mutex{},
+	}
+}
+
+type job struct {
+	group string
+	run   func()
+}
+
+// Scheduler runs jobs concurrently in the order they're submitted, bounded by a global limit and optional per-group
+// limits. A job whose group is at its limit doesn't hold up jobs from other groups.
+type Scheduler struct {
+	maxParallel  int
+	groupLimits  map[string]int
+	groupRunning map[string]int
+	running      int
+	queue        []job
+	mutex        *sync.Mutex
+	wg           sync.WaitGroup
+}
+
+// SetGroupLimit: Limits the number of jobs in group that may run at once. A limit of zero means no limit.
+func (s *Scheduler) SetGroupLimit(group string, limit int) {
+	s.mutex.Lock()
+	s.groupLimits[group] = limit
+	s.mutex.Unlock()
+}
+
+// Submit: Queues run to be executed as part of group
+func (s *Scheduler) Submit(group string, run func()) {
+	s.wg.Add(1)
+
+	s.mutex.Lock()
+	s.queue = append(s.queue, job{group: group, run: run})
+	s.dispatch()
+	s.mutex.Unlock()
+}
+
+// Wait: Blocks until all submitted jobs have completed
+func (s *Scheduler) Wait() {
+	s.wg.Wait()
+}
+
+// dispatch starts as many queued jobs as the limits allow. It must be called with the mutex held.
+func (s *Scheduler) dispatch() {
+	for i := 0; i < len(s.queue); {
+		if s.maxParallel > 0 && s.running >= s.maxParallel {
+			return
+		}
+
+		j := s.queue[i]
+		if limit := s.groupLimits[j.group]; limit > 0 && s.groupRunning[j.group] >= limit {
+			i++
+			continue
+		}
+
+		s.queue = append(s.queue[:i], s.queue[i+1:]...)
+		s.running++
+		s.groupRunning[j.group]++
+
+		go s.execute(j)
+	}
+}
+
+func (s *Scheduler) execute(j job) {
+	defer s.wg.Done()
+
+	j.run()
+
+	s.mutex.Lock()
+	s.running--
+	s.groupRunning[j.group]--
+	s.dispatch()
+	s.mutex.Unlock()
+}
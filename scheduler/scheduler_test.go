@@ -0,0 +1,107 @@
+package scheduler
+
+import (
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+// concurrencyTracker records the peak number of jobs running at once, overall and per group
+type concurrencyTracker struct {
+	mutex     sync.Mutex
+	running   map[string]int
+	total     int
+	peak      map[string]int
+	peakTotal int
+}
+
+func newConcurrencyTracker() *concurrencyTracker {
+	return &concurrencyTracker{running: make(map[string]int), peak: make(map[string]int)}
+}
+
+func (c *concurrencyTracker) job(group string) func() {
+	return func() {
+		c.mutex.Lock()
+		c.running[group]++
+		c.total++
+		if c.running[group] > c.peak[group] {
+			c.peak[group] = c.running[group]
+		}
+		if c.total > c.peakTotal {
+			c.peakTotal = c.total
+		}
+		c.mutex.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		c.mutex.Lock()
+		c.running[group]--
+		c.total--
+		c.mutex.Unlock()
+	}
+}
+
+func TestScheduler_MaxParallel(t *testing.T) {
+	tracker := newConcurrencyTracker()
+
+	s := NewScheduler(3)
+	for i := 0; i < 20; i++ {
+		s.Submit("", tracker.job(""))
+	}
+	s.Wait()
+
+	require.Equal(t, 3, tracker.peakTotal)
+}
+
+func TestScheduler_GroupLimits(t *testing.T) {
+	tracker := newConcurrencyTracker()
+
+	s := NewScheduler(4)
+	s.SetGroupLimit("site-a", 1)
+	s.SetGroupLimit("site-b", 2)
+
+	// Queue site-a's devices first, they mustn't hold up the other groups
+	for i := 0; i < 5; i++ {
+		s.Submit("site-a", tracker.job("site-a"))
+	}
+	for i := 0; i < 5; i++ {
+		s.Submit("site-b", tracker.job("site-b"))
+		s.Submit("site-c", tracker.job("site-c"))
+	}
+	s.Wait()
+
+	require.Equal(t, 1, tracker.peak["site-a"])
+	require.Equal(t, 2, tracker.peak["site-b"])
+	require.Equal(t, 4, tracker.peakTotal)
+}
+
+func TestScheduler_Unlimited(t *testing.T) {
+	var started sync.WaitGroup
+	release := make(chan struct{})
+
+	s := NewScheduler(0)
+	for i := 0; i < 10; i++ {
+		started.Add(1)
+		s.Submit("", func() {
+			started.Done()
+			<-release
+		})
+	}
+
+	// Every job should be running at once
+	done := make(chan struct{})
+	go func() {
+		started.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for all jobs to start")
+	}
+
+	close(release)
+	s.Wait()
+}
@@ -0,0 +1,44 @@
+auth_provider "static" "basic" {
+	auth "router" {
+		username = "admin"
+		password = "secret"
+	}
+}
+
+macro_library "common" {
+	source = "function enable( { sendLine('enable') }"
+}
+
+device_class "router" {
+	libraries = ["common"]
+
+	backup_target "running_config" {
+		macro = "sendLine('show run')"
+	}
+
+	backup_target "startup_config" {
+		macro = <<EOT
+sendLine('copy startup-config tftp'
+EOT
+	}
+}
+
+device "router" "router-01" {
+	address = "10.0.0.1:22"
+	auth = "basic:router"
+}
+
+device "firewall" "firewall-01" {
+	address = "10.0.0.2:22"
+	auth = "basic:router"
+}
+
+device "router" "router-02" {
+	address = "10.0.0.3:22"
+	auth = "vault:router"
+}
+
+device "router" "router-03" {
+	address = "10.0.0.1:22"
+	auth = "basic:router"
+}
@@ -0,0 +1,49 @@
+auth_provider "static" "basic" {
+	auth "router" {
+		username = "admin"
+		password = "secret"
+	}
+}
+
+auth_provider "static" "unused" {
+	auth "nothing" {
+		username = "nobody"
+		password = "secret"
+	}
+}
+
+macro_library "common" {
+	source = "function enable() { sendLine('enable') }"
+}
+
+device_class "base" {
+	libraries = ["common"]
+
+	backup_target "running_config" {
+		macro = "sendLine('show run')"
+	}
+}
+
+device_class "router" {
+	extends = "base"
+
+	backup_target "startup_config" {
+		macro = "sendLine('copy startup-config tftp')"
+	}
+}
+
+device_class "switch" {
+	extends = "base"
+}
+
+device "router" "router-01" {
+	address = "10.0.0.1:22"
+	auth = "basic:router"
+}
+
+device_group "site-a" {
+	device "router" "router-01" {
+		address = "10.0.0.1:22"
+		auth = "basic:missing"
+	}
+}
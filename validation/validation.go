@@ -0,0 +1,278 @@
+package validation
+
+import (
+	"fmt"
+	"github.com/hashicorp/hcl/hcl/token"
+	"github.com/samhug/ndm/auth"
+	"github.com/samhug/ndm/config"
+	"github.com/samhug/ndm/config/utilities"
+	"github.com/samhug/ndm/devices"
+	"net"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Problem severities
+const (
+	// SeverityError: The configuration won't work as intended
+	SeverityError = "error"
+	// SeverityWarning: The configuration works, but contains something that's likely a mistake
+	SeverityWarning = "warning"
+)
+
+// Problem describes something wrong with a configuration
+type Problem struct {
+	Pos      token.Pos
+	Severity string
+	Message  string
+}
+
+// String formats the problem as file:line:column: severity: message
+func (p Problem) String() string {
+	return fmt.Sprintf("%s: %s: %s", p.Pos, p.Severity, p.Message)
+}
+
+// Validate checks a configuration for problems that would only otherwise surface during a backup run. loadErrs are the
+// errors from loading cfg with config.LoadFileLenient, which are reported first, and the checks run on the blocks that
+// did load. Every macro is compiled, and missing macro libraries, unused device classes, auth providers and macro
+// libraries and devices sharing an address are reported. If authProviders is not nil each device's credentials are
+// looked up too. The problems are ordered by position.
+func Validate(cfg *config.Config, loadErrs []error, authProviders *auth.ProviderPool) []Problem {
+	v := &validator{cfg: cfg, seen: make(map[Problem]bool)}
+
+	v.reportLoadErrors(loadErrs)
+	v.checkMacroLibraries()
+	v.checkDeviceClasses()
+	v.checkDevices(authProviders)
+	v.checkUnused()
+	v.checkDuplicateAddresses()
+
+	sort.SliceStable(v.problems, func(i, j int) bool {
+		a, b := v.problems[i].Pos, v.problems[j].Pos
+		if a.Filename != b.Filename {
+			return a.Filename < b.Filename
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+
+	return v.problems
+}
+
+// HasErrors reports whether any of the problems is an error rather than a warning
+func HasErrors(problems []Problem) bool {
+	for _, p := range problems {
+		if p.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+type validator struct {
+	cfg      *config.Config
+	problems []Problem
+	// seen prevents reporting a problem twice, such as a backup_target inherited by several device classes
+	seen map[Problem]bool
+}
+
+func (v *validator) report(pos token.Pos, severity string, format string, a ...interface{}) {
+	p := Problem{Pos: pos, Severity: severity, Message: fmt.Sprintf(format, a...)}
+	if v.seen[p] {
+		return
+	}
+	v.seen[p] = true
+	v.problems = append(v.problems, p)
+}
+
+// reportLoadErrors reports each error from loading the configuration at its position. Errors without one are reported
+// against the configuration file.
+func (v *validator) reportLoadErrors(loadErrs []error) {
+	for _, err := range loadErrs {
+		if posErr, ok := err.(*utilities.PositionError); ok {
+			message := posErr.Msg
+			if posErr.Err != nil {
+				message = fmt.Sprintf("%s: %s", message, posErr.Err)
+			}
+			v.report(posErr.Pos, SeverityError, "%s", message)
+			continue
+		}
+		v.report(token.Pos{Filename: path.Join(v.cfg.ConfigDir, v.cfg.ConfigName)}, SeverityError, "%s", err)
+	}
+}
+
+func (v *validator) checkMacroLibraries() {
+	for _, name := range sortedKeys(v.cfg.MacroLibraries) {
+		libraryCfgs := map[string]*config.MacroLibraryConfig{name: v.cfg.MacroLibraries[name]}
+		if _, err := devices.LoadMacroLibraries(libraryCfgs); err != nil {
+			v.report(v.cfg.Positions.MacroLibraries[name], SeverityError, "macro_library '%s': %s", name, err)
+		}
+	}
+}
+
+func (v *validator) checkDeviceClasses() {
+	for _, className := range sortedKeys(v.cfg.DeviceClasses) {
+		classCfg := v.cfg.DeviceClasses[className]
+		classPos := v.cfg.Positions.DeviceClasses[className]
+
+		// A class whose own backup_targets all failed to load has already been reported
+		if len(classCfg.BackupTargets) == 0 && len(v.cfg.Positions.BackupTargets[className]) == 0 {
+			v.report(classPos, SeverityError, "device_class '%s': No backup_target defined", className)
+		}
+
+		for _, libraryName := range classCfg.Libraries {
+			// A library that's defined but failed to load has already been reported
+			_, defined := v.cfg.Positions.MacroLibraries[libraryName]
+			if _, ok := v.cfg.MacroLibraries[libraryName]; !ok && !defined {
+				v.report(classPos, SeverityError, "device_class '%s': macro_library '%s' doesn't exist", className, libraryName)
+			}
+		}
+
+		for _, targetName := range sortedKeys(classCfg.BackupTargets) {
+			if _, err := devices.NewDeviceClassTarget(targetName, classCfg.BackupTargets[targetName]); err != nil {
+				definedBy := v.targetDefinedBy(className, targetName)
+				v.report(v.cfg.Positions.BackupTargets[definedBy][targetName], SeverityError,
+					"device_class '%s': backup_target '%s': %s", definedBy, targetName, err)
+			}
+		}
+	}
+}
+
+// targetDefinedBy returns the name of the device_class that defines a backup_target, which is className unless the
+// target is inherited from a class it extends
+func (v *validator) targetDefinedBy(className string, targetName string) string {
+	for name := className; name != ""; {
+		if _, ok := v.cfg.Positions.BackupTargets[name][targetName]; ok {
+			return name
+		}
+		classCfg, ok := v.cfg.DeviceClasses[name]
+		if !ok {
+			break
+		}
+		name = classCfg.Extends
+	}
+	return className
+}
+
+func (v *validator) checkDevices(authProviders *auth.ProviderPool) {
+	for _, groupName := range sortedKeys(v.cfg.DeviceGroups) {
+		groupCfg := v.cfg.DeviceGroups[groupName]
+
+		for _, deviceName := range sortedKeys(groupCfg.Devices) {
+			deviceCfg := groupCfg.Devices[deviceName]
+			fullName := path.Join(groupName, deviceName)
+			pos := v.cfg.Positions.Devices[fullName]
+
+			// Missing device classes and auth providers are reported when the configuration is loaded
+			if _, ok := v.cfg.AuthProviders[deviceCfg.AuthProvider]; !ok || authProviders == nil {
+				continue
+			}
+			provider, err := authProviders.GetProvider(deviceCfg.AuthProvider)
+			if err != nil {
+				v.report(pos, SeverityError, "device '%s': auth_provider '%s': %s", fullName, deviceCfg.AuthProvider, err)
+				continue
+			}
+			if _, err := provider.Lookup(deviceCfg.AuthPath); err != nil {
+				v.report(pos, SeverityError, "device '%s': Lookup failed for auth '%s' in auth_provider '%s': %s",
+					fullName, deviceCfg.AuthPath, deviceCfg.AuthProvider, err)
+			}
+		}
+	}
+}
+
+// checkUnused reports device classes no device uses, directly or through extends, and auth providers and macro
+// libraries nothing refers to
+func (v *validator) checkUnused() {
+	usedClasses := make(map[string]bool)
+	usedProviders := make(map[string]bool)
+	for _, groupCfg := range v.cfg.DeviceGroups {
+		for _, deviceCfg := range groupCfg.Devices {
+			for name := deviceCfg.ClassName; name != "" && !usedClasses[name]; {
+				usedClasses[name] = true
+				classCfg, ok := v.cfg.DeviceClasses[name]
+				if !ok {
+					break
+				}
+				name = classCfg.Extends
+			}
+			usedProviders[deviceCfg.AuthProvider] = true
+		}
+	}
+
+	usedLibraries := make(map[string]bool)
+	for _, classCfg := range v.cfg.DeviceClasses {
+		for _, libraryName := range classCfg.Libraries {
+			usedLibraries[libraryName] = true
+		}
+	}
+
+	for _, name := range sortedKeys(v.cfg.DeviceClasses) {
+		if !usedClasses[name] {
+			v.report(v.cfg.Positions.DeviceClasses[name], SeverityWarning, "device_class '%s' isn't used by any device", name)
+		}
+	}
+	for _, name := range sortedKeys(v.cfg.AuthProviders) {
+		if !usedProviders[name] {
+			v.report(v.cfg.Positions.AuthProviders[name], SeverityWarning, "auth_provider '%s' isn't used by any device", name)
+		}
+	}
+	for _, name := range sortedKeys(v.cfg.MacroLibraries) {
+		if !usedLibraries[name] {
+			v.report(v.cfg.Positions.MacroLibraries[name], SeverityWarning, "macro_library '%s' isn't used by any device_class", name)
+		}
+	}
+}
+
+// checkDuplicateAddresses reports devices that share an address with another device. Each device after the first
+// with an address is reported.
+func (v *validator) checkDuplicateAddresses() {
+	type device struct {
+		name string
+		pos  token.Pos
+	}
+
+	byAddress := make(map[string][]device)
+	for groupName, groupCfg := range v.cfg.DeviceGroups {
+		for deviceName, deviceCfg := range groupCfg.Devices {
+			fullName := path.Join(groupName, deviceName)
+			address := normalizeAddress(deviceCfg.Address)
+			byAddress[address] = append(byAddress[address], device{fullName, v.cfg.Positions.Devices[fullName]})
+		}
+	}
+
+	for _, address := range sortedKeys(byAddress) {
+		devs := byAddress[address]
+		if len(devs) < 2 {
+			continue
+		}
+		sort.Slice(devs, func(i, j int) bool { return devs[i].name < devs[j].name })
+		for _, d := range devs[1:] {
+			v.report(d.pos, SeverityError, "device '%s': address '%s' is also used by device '%s'", d.name, address, devs[0].name)
+		}
+	}
+}
+
+// normalizeAddress lowercases the host part of an address so equivalent addresses compare equal
+func normalizeAddress(address string) string {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return strings.ToLower(address)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		host = ip.String()
+	}
+	return net.JoinHostPort(strings.ToLower(host), port)
+}
+
+// sortedKeys returns the keys of m, sorted
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
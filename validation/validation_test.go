@@ -0,0 +1,128 @@
+package validation
+
+import (
+	"github.com/samhug/ndm/auth"
+	"github.com/samhug/ndm/config"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func loadTestConfig(t *testing.T) *config.Config {
+	cfg, err := config.LoadFile("test_data/problems.hcl")
+	require.NoError(t, err)
+	return cfg
+}
+
+// requireProblem asserts that a problem containing message was reported at line of the test config
+func requireProblem(t *testing.T, problems []Problem, line int, severity string, message string) {
+	requireProblemIn(t, problems, "test_data/problems.hcl", line, severity, message)
+}
+
+// requireProblemIn asserts that a problem containing message was reported at line of filename
+func requireProblemIn(t *testing.T, problems []Problem, filename string, line int, severity string, message string) {
+	for _, p := range problems {
+		if p.Pos.Filename == filename && p.Pos.Line == line && p.Severity == severity && strings.Contains(p.Message, message) {
+			return
+		}
+	}
+	t.Fatalf("No %s containing %q reported at %s:%d, got:\n%s", severity, message, filename, line, formatProblems(problems))
+}
+
+func formatProblems(problems []Problem) string {
+	lines := make([]string, 0, len(problems))
+	for _, p := range problems {
+		lines = append(lines, p.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestValidate(t *testing.T) {
+	problems := Validate(loadTestConfig(t), nil, nil)
+
+	requireProblem(t, problems, 8, SeverityWarning, "auth_provider 'unused' isn't used by any device")
+	requireProblem(t, problems, 35, SeverityWarning, "device_class 'switch' isn't used by any device")
+	requireProblem(t, problems, 45, SeverityError, "device 'site-a/router-01': address '10.0.0.1:22' is also used by device 'router-01'")
+
+	// Classes that are used through extends aren't reported as unused
+	require.Len(t, problems, 3, formatProblems(problems))
+	require.True(t, HasErrors(problems))
+
+	// Problems are ordered by position
+	for i := 1; i < len(problems); i++ {
+		require.True(t, problems[i-1].Pos.Line <= problems[i].Pos.Line)
+	}
+
+	require.Regexp(t, `^test_data/problems\.hcl:8:\d+: warning: auth_provider 'unused'`, problems[0].String())
+}
+
+func TestValidate_LoadErrors(t *testing.T) {
+	// Errors loading the config don't stop the rest of it loading, or the other checks running
+	filename := "test_data/broken.hcl"
+	cfg, loadErrs := config.LoadFileLenient(filename)
+	require.NotNil(t, cfg)
+	require.NotEmpty(t, loadErrs)
+
+	problems := Validate(cfg, loadErrs, nil)
+
+	requireProblemIn(t, problems, filename, 9, SeverityError, "macro_library 'common': Invalid script: ")
+	requireProblemIn(t, problems, filename, 22, SeverityError, "device_class 'router': backup_target 'startup_config': Invalid macro: ")
+	requireProblemIn(t, problems, filename, 31, SeverityError, "device 'firewall-01': device_class 'firewall' doesn't exist")
+	requireProblemIn(t, problems, filename, 36, SeverityError, "device 'router-02': auth_provider 'vault' doesn't exist")
+	requireProblemIn(t, problems, filename, 41, SeverityError, "device 'router-03': address '10.0.0.1:22' is also used by device 'router-01'")
+
+	// Blocks that failed to load aren't reported again as missing
+	require.Len(t, problems, 5, formatProblems(problems))
+}
+
+func TestValidate_ResolveAuth(t *testing.T) {
+	provider := auth.NewStaticProvider()
+	require.NoError(t, provider.AddAuth("router", "admin", "secret", nil))
+
+	pool := auth.NewProviderPool()
+	require.NoError(t, pool.RegisterProvider("basic", provider))
+	require.NoError(t, pool.RegisterProvider("unused", auth.NewStaticProvider()))
+
+	problems := Validate(loadTestConfig(t), nil, pool)
+
+	requireProblem(t, problems, 45, SeverityError, "device 'site-a/router-01': Lookup failed for auth 'missing' in auth_provider 'basic'")
+	require.Len(t, problems, 4, formatProblems(problems))
+}
+
+func TestValidate_Clean(t *testing.T) {
+	cfg, err := config.LoadString(`
+auth_provider "static" "basic" {
+	auth "router" {
+		username = "admin"
+		password = "secret"
+	}
+}
+
+device_class "router" {
+	backup_target "running_config" {
+		macro = "sendLine('show run')"
+	}
+}
+
+device "router" "router-01" {
+	address = "10.0.0.1:22"
+	auth = "basic:router"
+}
+
+device "router" "router-02" {
+	address = "10.0.0.2:22"
+	auth = "basic:router"
+}
+`)
+	require.NoError(t, err)
+
+	problems := Validate(cfg, nil, nil)
+	require.Empty(t, problems)
+	require.False(t, HasErrors(problems))
+}
+
+func TestNormalizeAddress(t *testing.T) {
+	require.Equal(t, "router.example.com:22", normalizeAddress("Router.Example.COM:22"))
+	require.Equal(t, "[2001:db8::1]:22", normalizeAddress("[2001:DB8:0::1]:22"))
+	require.Equal(t, "10.0.0.1", normalizeAddress("10.0.0.1"))
+}